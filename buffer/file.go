@@ -42,7 +42,7 @@ func (f *file) Create(name string, size int64) error {
 func (f *file) Open(name string, chunkSize int) (ChunkedFile, error) {
 	filename := filepath.Join(f.path, name)
 
-	file, err := f.filesystem.OpenFile(filename, os.O_APPEND|os.O_RDWR, 0755)
+	file, err := f.filesystem.OpenFile(filename, os.O_RDWR, 0755)
 	if err != nil {
 		return nil, err
 	}
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/daeMOn63/gorrent/buffer"
@@ -12,13 +14,17 @@ import (
 	"github.com/daeMOn63/gorrent/gorrent"
 )
 
+// ErrEmptyTrackerTier is returned when an -announce flag does not list any tracker
+var ErrEmptyTrackerTier = errors.New("empty tracker tier")
+
 // Create is a cli command, allowing to create gorrent
 type Create struct {
 	pieceLength int
 	src         string
 	dst         string
 	fsWorkers   int
-	announce    string
+	announce    trackerTiers
+	private     bool
 
 	flagSet *flag.FlagSet
 }
@@ -33,10 +39,11 @@ func NewCreate() Command {
 	}
 
 	cmd.flagSet.StringVar(&cmd.src, "src", "", "Required. File / folder to create the gorrent from.")
-	cmd.flagSet.StringVar(&cmd.announce, "announce", "", "Required. Tracker ip / port.")
+	cmd.flagSet.Var(&cmd.announce, "announce", "Tier of trackers ip / port, comma separated. Can be repeated for backup tiers. Without it, the gorrent peers are only found through the DHT, local discovery and peer exchange.")
 	cmd.flagSet.StringVar(&cmd.dst, "dst", fmt.Sprintf("./%d.gorrent", time.Now().Unix()), "Output filename")
 	cmd.flagSet.IntVar(&cmd.pieceLength, "pieceLength", gorrent.DefaultPieceLength, "Gorrent pieces length.")
 	cmd.flagSet.IntVar(&cmd.fsWorkers, "fsWorkers", 10, "Number of parallel workers when accessing file system")
+	cmd.flagSet.BoolVar(&cmd.private, "private", false, "Protect the gorrent swarm with a random secret. Only peers holding the gorrent file can join it.")
 	return cmd
 }
 
@@ -56,10 +63,6 @@ func (c *Create) Run(w io.Writer, r io.Reader) error {
 		return ErrRequiredFlag{Name: "dst"}
 	}
 
-	if c.announce == "" {
-		return ErrRequiredFlag{Name: "announce"}
-	}
-
 	pb := buffer.NewMemoryPieceBuffer(c.pieceLength)
 	filesystem := fs.NewFileSystem()
 	rw := gorrent.NewReadWriter()
@@ -77,13 +80,33 @@ func (c *Create) Run(w io.Writer, r io.Reader) error {
 		return err
 	}
 	elapsed := time.Since(start)
-	g.Announce = c.announce
+	if len(c.announce) > 0 {
+		g.Announce = c.announce[0][0]
+		g.AnnounceList = c.announce
+	}
+
+	if c.private {
+		g.Secret, err = gorrent.NewSecret()
+		if err != nil {
+			return err
+		}
+	}
 
 	fmt.Fprintf(w, "gorrent created in %s\n", elapsed)
-	fmt.Fprintf(w, "\t - announce url: %s\n", g.Announce)
+	if len(g.Announce) > 0 {
+		for i, tier := range g.AnnounceList {
+			fmt.Fprintf(w, "\t - announce tier %d: %s\n", i, strings.Join(tier, ", "))
+		}
+	} else {
+		fmt.Fprintf(w, "\t - trackerless\n")
+	}
 	fmt.Fprintf(w, "\t - files %d\n", len(g.Files))
 	fmt.Fprintf(w, "\t - pieces %d\n", len(g.Pieces))
 	fmt.Fprintf(w, "\t - total file size: %d bytes\n", g.TotalFileSize())
+	fmt.Fprintf(w, "\t - info hash: %s\n", g.InfoHash().HexString())
+	if g.IsPrivate() {
+		fmt.Fprintf(w, "\t - swarm secret: %s\n", g.SecretHexString())
+	}
 
 	if err := creator.Save(c.dst, g); err != nil {
 		return err
@@ -92,3 +115,32 @@ func (c *Create) Run(w io.Writer, r io.Reader) error {
 
 	return nil
 }
+
+// trackerTiers is a repeatable flag, each value being a comma separated tier of trackers
+type trackerTiers [][]string
+
+func (t *trackerTiers) String() string {
+	var tiers []string
+	for _, tier := range *t {
+		tiers = append(tiers, strings.Join(tier, ","))
+	}
+
+	return strings.Join(tiers, " ")
+}
+
+func (t *trackerTiers) Set(value string) error {
+	var tier []string
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			tier = append(tier, addr)
+		}
+	}
+
+	if len(tier) == 0 {
+		return ErrEmptyTrackerTier
+	}
+
+	*t = append(*t, tier)
+
+	return nil
+}
@@ -4,9 +4,14 @@ import (
 	"flag"
 	"io"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/daeMOn63/gorrent/buffer"
+	"github.com/daeMOn63/gorrent/dht"
 	"github.com/daeMOn63/gorrent/fs"
 	"github.com/daeMOn63/gorrent/gorrent"
 	"github.com/daeMOn63/gorrent/peer"
@@ -62,25 +67,96 @@ func (c *PeerDaemon) Run(w io.Writer, r io.Reader) error {
 
 	var peerID gorrent.PeerID
 	peerID.SetString(cfg.ID)
-	// TODO: move timeout to config
-	peerClient := peer.NewClient(2 * time.Second)
+
+	security, err := peer.NewSecurity(cfg.Security)
+	if err != nil {
+		return err
+	}
 
 	peerData := *gorrent.NewPeer(cfg.ID, cfg.PublicIP, cfg.PublicPort)
+
+	readTimeout := time.Duration(cfg.ReadTimeout) * time.Millisecond
+	if readTimeout == 0 {
+		readTimeout = peer.DefaultReadTimeout
+	}
+
+	peerClient := peer.NewClient(peer.ClientConfig{
+		ReadTimeout:   readTimeout,
+		MinQueueDepth: cfg.MinQueueDepth,
+		MaxQueueDepth: cfg.MaxQueueDepth,
+		Security:      security,
+		Secrets:       peer.NewStoreSwarmSecrets(store),
+		OnPeers: func(infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr) {
+			if err := peer.MergePeers(store, peerData.PeerAddr, infoHash, addrs); err != nil {
+				log.Printf("Failed merging exchanged peers for %s: %s", infoHash.HexString(), err)
+			}
+		},
+	})
+	defer peerClient.Close()
+
 	tracker := tracker.NewClient(peerData, cfg.TrackerProtocol)
 
-	watcher := peer.NewWatcher(store, filesystem, fileBuffer, tracker, peerClient)
+	parallelChunks := cfg.ParallelChunks
+	if parallelChunks == 0 {
+		parallelChunks = peer.DefaultParallelChunks
+	}
+
+	watcher := peer.NewWatcher(store, filesystem, fileBuffer, tracker, peerClient, parallelChunks)
 	go func() {
 		if err := watcher.Watch(); err != nil {
 			log.Println("watcher error: ", err)
 		}
 	}()
 
+	peerMaxAge := time.Duration(cfg.PeerMaxAge) * time.Millisecond
+	if peerMaxAge == 0 {
+		peerMaxAge = peer.DefaultPeerMaxAge
+	}
+
 	// Start announcer
-	announcer := peer.NewAnnouncer(store, tracker, time.Duration(cfg.AnnounceDelay)*time.Millisecond)
+	announcer := peer.NewAnnouncer(store, tracker, time.Duration(cfg.AnnounceDelay)*time.Millisecond, peerMaxAge)
 	go announcer.AnnounceForever()
 
+	// Start peer exchange, disabled when no interval is configured
+	if cfg.PexInterval > 0 {
+		pexchanger := peer.NewPeerExchanger(store, peerClient, peerData.PeerAddr, time.Duration(cfg.PexInterval)*time.Millisecond, peerMaxAge)
+		go pexchanger.ExchangeForever()
+	}
+
+	// Start local discovery
+	if cfg.LocalDiscovery != nil {
+		discovery, err := newLocalDiscovery(cfg.LocalDiscovery, store, peerData)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := discovery.Listen(); err != nil {
+				log.Println("local discovery error: ", err)
+			}
+		}()
+		go discovery.AnnounceForever()
+	}
+
+	// Start DHT
+	if cfg.DHT != nil {
+		node, err := dht.NewNode(dht.Config{Addr: cfg.DHT.Addr})
+		if err != nil {
+			return err
+		}
+		defer node.Close()
+
+		interval := time.Duration(cfg.DHT.Interval) * time.Millisecond
+		if interval == 0 {
+			interval = peer.DefaultDHTInterval
+		}
+
+		dhtAnnouncer := peer.NewDHTAnnouncer(store, node, peerData.PeerAddr, cfg.DHT.Bootstrap, interval)
+		go dhtAnnouncer.AnnounceForever()
+	}
+
 	// Start public server
-	publicServer := server.NewPublicServer(peerData, filesystem, store)
+	publicServer := server.NewPublicServer(peerData, filesystem, store, security, readTimeout)
 	go func() {
 		if err := publicServer.Listen(); err != nil {
 			log.Println("public server error: ", err)
@@ -89,6 +165,49 @@ func (c *PeerDaemon) Run(w io.Writer, r io.Reader) error {
 
 	// Start local server
 	localServer := server.NewLocalServer(cfg.SockPath, filesystem, store)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- localServer.Listen()
+	}()
+
+	// Announce the stopped event to the trackers on graceful shutdown
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %s, stopping", sig)
+
+		return announcer.Stop()
+	}
+}
+
+// newLocalDiscovery creates the LocalDiscovery from its configuration, applying the defaults
+func newLocalDiscovery(cfg *peer.LocalDiscoveryConfig, store peer.GorrentStore, self gorrent.Peer) (peer.LocalDiscovery, error) {
+	groupAddr := cfg.Group
+	if len(groupAddr) == 0 {
+		groupAddr = peer.DefaultDiscoveryGroup
+	}
+
+	group, err := net.ResolveUDPAddr("udp", groupAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var iface *net.Interface
+	if len(cfg.Interface) > 0 {
+		iface, err = net.InterfaceByName(cfg.Interface)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	interval := time.Duration(cfg.Interval) * time.Millisecond
+	if interval == 0 {
+		interval = peer.DefaultDiscoveryInterval
+	}
 
-	return localServer.Listen()
+	return peer.NewLocalDiscovery(store, self, group, iface, interval)
 }
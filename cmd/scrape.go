@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/daeMOn63/gorrent/fs"
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker"
+)
+
+var (
+	// ErrInvalidProtocol is returned when the protocol flag is not one of the tracker protocols
+	ErrInvalidProtocol = errors.New("protocol must be udp, tcp, http or https")
+)
+
+// Scrape is a cli command, allowing to query a tracker for swarm statistics
+type Scrape struct {
+	tracker    string
+	protocol   string
+	infoHashes string
+	gorrents   string
+
+	flagSet *flag.FlagSet
+}
+
+var _ Command = &Scrape{}
+
+// NewScrape instantiates the command
+func NewScrape() Command {
+
+	cmd := &Scrape{
+		flagSet: flag.NewFlagSet("scrape", flag.ExitOnError),
+	}
+
+	cmd.flagSet.StringVar(&cmd.tracker, "tracker", "", "Required. Tracker ip / port to query over protocol, or http(s) url.")
+	cmd.flagSet.StringVar(&cmd.protocol, "protocol", tracker.ProtocolUDP, "Protocol used to reach the tracker ip / port, udp, tcp, http or https.")
+	cmd.flagSet.StringVar(&cmd.infoHashes, "infoHash", "", "Hex encoded info hashes of the gorrents to get the statistics of, comma separated. Required without gorrent.")
+	cmd.flagSet.StringVar(&cmd.gorrents, "gorrent", "", "Gorrent files to get the statistics of, comma separated. Private ones prove their swarm secret to the tracker.")
+
+	return cmd
+}
+
+// FlagSet returns command flags
+func (c *Scrape) FlagSet() *flag.FlagSet {
+	return c.flagSet
+}
+
+// Run executes the command
+func (c *Scrape) Run(w io.Writer, r io.Reader) error {
+	if c.tracker == "" {
+		return ErrRequiredFlag{Name: "tracker"}
+	}
+
+	if c.infoHashes == "" && c.gorrents == "" {
+		return ErrRequiredFlag{Name: "infoHash"}
+	}
+
+	switch c.protocol {
+	case tracker.ProtocolUDP, tracker.ProtocolTCP, tracker.ProtocolHTTP, tracker.ProtocolHTTPS:
+	default:
+		return ErrInvalidProtocol
+	}
+
+	var infoHashes []gorrent.Sha1Hash
+	if c.infoHashes != "" {
+		for _, hexInfoHash := range strings.Split(c.infoHashes, ",") {
+			infoHash, err := gorrent.ParseSha1Hash(strings.TrimSpace(hexInfoHash))
+			if err != nil {
+				return fmt.Errorf("infoHash: %s: %s", hexInfoHash, err)
+			}
+			infoHashes = append(infoHashes, infoHash)
+		}
+	}
+
+	secrets := make(map[gorrent.Sha1Hash][]byte)
+	if c.gorrents != "" {
+		filesystem := fs.NewFileSystem()
+		rw := gorrent.NewReadWriter()
+
+		for _, path := range strings.Split(c.gorrents, ",") {
+			g, err := readGorrent(filesystem, rw, strings.TrimSpace(path))
+			if err != nil {
+				return fmt.Errorf("gorrent: %s: %s", path, err)
+			}
+
+			infoHashes = append(infoHashes, g.InfoHash())
+			if g.IsPrivate() {
+				secrets[g.InfoHash()] = g.Secret
+			}
+		}
+	}
+
+	client := tracker.NewClient(gorrent.Peer{}, c.protocol)
+	response, err := client.Scrape(c.tracker, infoHashes, secrets)
+	if err != nil {
+		return err
+	}
+
+	for i, stats := range response.Stats {
+		fmt.Fprintf(w, "%s\n", infoHashes[i].HexString())
+		fmt.Fprintf(w, "\t - seeders: %d\n", stats.Seeders)
+		fmt.Fprintf(w, "\t - leechers: %d\n", stats.Leechers)
+		fmt.Fprintf(w, "\t - completed: %d\n", stats.Completed)
+	}
+
+	return nil
+}
+
+// readGorrent reads the gorrent file at path
+func readGorrent(filesystem fs.FileSystem, rw gorrent.ReadWriter, path string) (*gorrent.Gorrent, error) {
+	file, err := filesystem.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return rw.Read(file)
+}
@@ -1,25 +1,79 @@
 package cmd
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/daeMOn63/gorrent/gorrent"
 	"github.com/daeMOn63/gorrent/tracker"
 	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/admin"
+	"github.com/daeMOn63/gorrent/tracker/cluster"
 	"github.com/daeMOn63/gorrent/tracker/handlers"
 	"github.com/daeMOn63/gorrent/tracker/store"
 )
 
+var (
+	// ErrInvalidAnnounceInterval is returned when the announce intervals are not positive, or the minimum one is above the other
+	ErrInvalidAnnounceInterval = errors.New("announceInterval must be positive and above minAnnounceInterval")
+	// ErrInvalidStore is returned when the store flag is neither memory nor bolt
+	ErrInvalidStore = errors.New("store must be memory or bolt")
+)
+
+const (
+	// StoreMemory keeps the tracker swarms in memory, losing them on restart
+	StoreMemory = "memory"
+	// StoreBolt persists the tracker swarms in a bolt database
+	StoreBolt = "bolt"
+
+	// countersLogInterval defines how often the tracker servers counters are logged
+	countersLogInterval = time.Minute
+)
+
 // TrackerDaemon is a cli command, allowing to start a gorrent Tracker
 type TrackerDaemon struct {
 	flagSet *flag.FlagSet
 
-	bind         string
-	maxPeerAge   int
-	readTimeout  int64
-	writeTimeout int64
+	bind                string
+	httpBind            string
+	tcpBind             string
+	tlsCert             string
+	tlsKey              string
+	announceInterval    int
+	minAnnounceInterval int
+	maxPeerAge          int
+	readTimeout         int64
+	writeTimeout        int64
+	swarmSecrets        string
+	maxPeers            int
+	useSourceIP         bool
+	store               string
+	dbPath              string
+	allowlist           bool
+	adminBind           string
+	adminToken          string
+	rateLimit           float64
+	rateBurst           int
+	maxInfoHashes       int
+	maxLogsPerSecond    int
+	clusterBind         string
+	clusterPeers        string
+	clusterToken        string
+	workers             int
 }
 
 var _ Command = &TrackerDaemon{}
@@ -32,9 +86,31 @@ func NewTrackerDaemon() Command {
 	}
 
 	cmd.flagSet.StringVar(&cmd.bind, "bind", ":4444", "interface:port where the tracker will listen on.")
-	cmd.flagSet.IntVar(&cmd.maxPeerAge, "maxPeerAge", 5000, "threshold in millisecond where peer are considered dead if they not send an announce")
+	cmd.flagSet.StringVar(&cmd.httpBind, "httpBind", "", "interface:port where the tracker will also listen on for HTTP requests. Disabled when empty")
+	cmd.flagSet.StringVar(&cmd.tcpBind, "tcpBind", "", "interface:port where the tracker will also listen on for TCP connections. Disabled when empty")
+	cmd.flagSet.StringVar(&cmd.tlsCert, "tlsCert", "", "certificate file serving HTTP requests over TLS")
+	cmd.flagSet.StringVar(&cmd.tlsKey, "tlsKey", "", "private key file of tlsCert")
+	cmd.flagSet.IntVar(&cmd.announceInterval, "announceInterval", int(handlers.DefaultAnnounceInterval/time.Millisecond), "delay in millisecond peers are asked to wait between announces. Peers are considered dead after missing 2 announces")
+	cmd.flagSet.IntVar(&cmd.minAnnounceInterval, "minAnnounceInterval", int(handlers.DefaultMinAnnounceInterval/time.Millisecond), "minimum delay in millisecond peers must wait between announces")
+	cmd.flagSet.IntVar(&cmd.maxPeerAge, "maxPeerAge", 0, "deprecated, threshold in millisecond where peers are considered dead if they do not send an announce. Overrides the 2 missed announces when set")
 	cmd.flagSet.Int64Var(&cmd.readTimeout, "read-timeout", 100, "maximum network read time")
 	cmd.flagSet.Int64Var(&cmd.writeTimeout, "write-timeout", 100, "maximum network write time")
+	cmd.flagSet.StringVar(&cmd.swarmSecrets, "swarmSecrets", "", "json file mapping private gorrents info hashes to their swarm secret, both hex encoded")
+	cmd.flagSet.IntVar(&cmd.maxPeers, "maxPeers", handlers.DefaultMaxPeers, "maximum number of peers returned on announces, whatever the peers ask for")
+	cmd.flagSet.BoolVar(&cmd.useSourceIP, "useSourceIP", false, "register peers with the IP their announces are received from, instead of the one they report")
+	cmd.flagSet.StringVar(&cmd.store, "store", StoreMemory, "where swarms and registered gorrents are stored, memory or bolt. Bolt ones survive tracker restarts")
+	cmd.flagSet.StringVar(&cmd.dbPath, "dbPath", "./tracker.db", "path of the bolt database, when store is bolt")
+	cmd.flagSet.BoolVar(&cmd.allowlist, "allowlist", false, "only track the registered gorrents, and the ones listed in swarmSecrets")
+	cmd.flagSet.StringVar(&cmd.adminBind, "adminBind", "", "interface:port where the admin HTTP API, registering gorrents, will listen on. Disabled when empty")
+	cmd.flagSet.StringVar(&cmd.adminToken, "adminToken", "", "bearer token required on admin API requests. Required with adminBind")
+	cmd.flagSet.Float64Var(&cmd.rateLimit, "rateLimit", 10, "maximum number of actions per second accepted from a single IP. Disabled when 0")
+	cmd.flagSet.IntVar(&cmd.rateBurst, "rateBurst", 50, "number of actions a single IP can send at once above rateLimit")
+	cmd.flagSet.IntVar(&cmd.maxInfoHashes, "maxInfoHashes", 1000, "maximum number of gorrents a single IP can announce. Disabled when 0")
+	cmd.flagSet.IntVar(&cmd.maxLogsPerSecond, "maxLogsPerSecond", tracker.DefaultMaxLogsPerSecond, "maximum number of per action messages logged each second, the others being counted")
+	cmd.flagSet.StringVar(&cmd.clusterBind, "clusterBind", "", "interface:port where the tracker receives the announces replicated by the other cluster trackers. Disabled when empty")
+	cmd.flagSet.StringVar(&cmd.clusterPeers, "clusterPeers", "", "comma separated clusterBind addresses of the other cluster trackers")
+	cmd.flagSet.StringVar(&cmd.clusterToken, "clusterToken", "", "bearer token shared by the cluster trackers. Required with clusterBind")
+	cmd.flagSet.IntVar(&cmd.workers, "workers", tracker.DefaultWorkers, "maximum number of actions handled concurrently by each tracker server")
 
 	return cmd
 }
@@ -51,20 +127,248 @@ func (c *TrackerDaemon) Run(w io.Writer, r io.Reader) error {
 		return ErrRequiredFlag{Name: "bind"}
 	}
 
+	if c.tlsCert != "" && c.tlsKey == "" {
+		return ErrRequiredFlag{Name: "tlsKey"}
+	}
+
+	if c.clusterBind != "" && c.clusterToken == "" {
+		return ErrRequiredFlag{Name: "clusterToken"}
+	}
+
+	if c.adminBind != "" && c.adminToken == "" {
+		return ErrRequiredFlag{Name: "adminToken"}
+	}
+
+	if c.announceInterval <= 0 || c.minAnnounceInterval < 0 || c.minAnnounceInterval > c.announceInterval {
+		return ErrInvalidAnnounceInterval
+	}
+
 	actionReader := actions.NewReader()
 	actionRouter := actions.NewRouter()
 
-	announceStore := store.NewAnnounceMemory()
-	announceHandler := handlers.NewAnnounce(announceStore, time.Duration(c.maxPeerAge)*time.Millisecond)
+	configured := store.NewRegistryMemory()
+	if c.swarmSecrets != "" {
+		if err := loadSwarmSecrets(c.swarmSecrets, configured); err != nil {
+			return err
+		}
+	}
+
+	var announceStore store.Announce
+	var registryStore store.Registry
+	switch c.store {
+	case StoreMemory:
+		announceStore = store.NewAnnounceMemory()
+		registryStore = store.NewRegistryMemory()
+	case StoreBolt:
+		boltStore, err := store.NewAnnounceBolt(c.dbPath, 0600)
+		if err != nil {
+			return err
+		}
+		defer boltStore.Close()
+
+		registryStore, err = store.NewRegistryBolt(boltStore)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("tracker swarms and registry stored in %s", c.dbPath)
+		announceStore = boltStore
+	default:
+		return ErrInvalidStore
+	}
+
+	if c.clusterBind != "" {
+		var peers []string
+		for _, peer := range strings.Split(c.clusterPeers, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				peers = append(peers, peer)
+			}
+		}
+
+		node := cluster.NewNode(cluster.Config{Addr: c.clusterBind, Peers: peers, Token: c.clusterToken}, announceStore)
+		go func() {
+			if err := node.Listen(); err != nil {
+				log.Printf("cluster node error: %s", err)
+			}
+		}()
+		go node.ReplicateForever()
+
+		announceStore = node
+		registryStore = node.Registry(registryStore)
+	}
+
+	// Gorrents of the configuration file are tracked along the registered ones, their secrets taking precedence
+	registry := store.NewRegistryOverlay(configured, registryStore)
+
+	announceConfig := handlers.AnnounceConfig{
+		Interval:    time.Duration(c.announceInterval) * time.Millisecond,
+		MinInterval: time.Duration(c.minAnnounceInterval) * time.Millisecond,
+		MaxPeers:    c.maxPeers,
+		UseSourceIP: c.useSourceIP,
+	}
+
+	if c.maxPeerAge > 0 {
+		log.Printf("maxPeerAge is deprecated, peers are considered dead after missing 2 announces when it is not set")
+		announceConfig.PeerAge = time.Duration(c.maxPeerAge) * time.Millisecond
+	}
+
+	connectionSecret := make([]byte, 32)
+	if _, err := rand.Read(connectionSecret); err != nil {
+		return err
+	}
+	connections := handlers.NewConnectionIDs(connectionSecret, actions.ConnectionIDLifetime)
+
+	connectHandler := handlers.NewConnect(connections)
+	announceHandler := handlers.NewAnnounce(announceStore, registry, connections, announceConfig)
+	scrapeHandler := handlers.NewScrape(announceStore, registry, announceConfig.MaxPeerAge())
+
+	if c.maxInfoHashes > 0 {
+		announceHandler = handlers.NewInfoHashLimit(c.maxInfoHashes, announceConfig.MaxPeerAge(), announceHandler)
+	}
+
+	if c.allowlist {
+		log.Printf("tracking registered gorrents only")
+		announceHandler = handlers.NewAllowlist(registry, announceHandler)
+		scrapeHandler = handlers.NewAllowlist(registry, scrapeHandler)
+	}
 
+	actionRouter.Register(actions.ConnectID, connectHandler)
 	actionRouter.Register(actions.AnnounceID, announceHandler)
+	actionRouter.Register(actions.ScrapeID, scrapeHandler)
+
+	if c.adminBind != "" {
+		adminServer := admin.NewServer(c.adminBind, c.adminToken, registry, announceStore, announceConfig.MaxPeerAge())
+		go func() {
+			if err := adminServer.Listen(); err != nil {
+				log.Printf("admin server error: %s", err)
+			}
+		}()
+	}
+
+	sweeper := store.NewSweeper(announceStore, announceConfig.MaxPeerAge(), announceConfig.Interval)
+	go sweeper.SweepForever()
+
+	var limiter tracker.RateLimiter
+	if c.rateLimit > 0 {
+		limiter = tracker.NewRateLimiter(c.rateLimit, c.rateBurst)
+	}
+
+	// Servers stop on SIGINT or SIGTERM, once the actions being handled are responded
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	servers := make(map[string]tracker.Server)
+
+	if c.httpBind != "" {
+		httpCfg := tracker.ServerConfig{
+			Addr:             c.httpBind,
+			Protocol:         tracker.ProtocolHTTP,
+			TLSCertFile:      c.tlsCert,
+			TLSKeyFile:       c.tlsKey,
+			Limiter:          limiter,
+			MaxLogsPerSecond: c.maxLogsPerSecond,
+			Workers:          c.workers,
+		}
+		if c.tlsCert != "" {
+			httpCfg.Protocol = tracker.ProtocolHTTPS
+		}
+
+		httpServer := tracker.NewServer(httpCfg, actionReader, actionRouter)
+		servers[httpCfg.Protocol] = httpServer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			log.Printf("tracker listening on %s %s", httpCfg.Protocol, c.httpBind)
+			if err := httpServer.Listen(ctx); err != nil {
+				log.Printf("tracker %s server error: %s", httpCfg.Protocol, err)
+			}
+		}()
+	}
+
+	if c.tcpBind != "" {
+		tcpCfg := tracker.ServerConfig{
+			Addr:             c.tcpBind,
+			Protocol:         tracker.ProtocolTCP,
+			Limiter:          limiter,
+			MaxLogsPerSecond: c.maxLogsPerSecond,
+			Workers:          c.workers,
+		}
+
+		tcpServer := tracker.NewServer(tcpCfg, actionReader, actionRouter)
+		servers[tcpCfg.Protocol] = tcpServer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			log.Printf("tracker listening on %s %s", tracker.ProtocolTCP, c.tcpBind)
+			if err := tcpServer.Listen(ctx); err != nil {
+				log.Printf("tracker %s server error: %s", tracker.ProtocolTCP, err)
+			}
+		}()
+	}
 
 	cfg := tracker.ServerConfig{
-		Addr:     c.bind,
-		Protocol: "udp",
+		Addr:             c.bind,
+		Protocol:         tracker.ProtocolUDP,
+		Limiter:          limiter,
+		MaxLogsPerSecond: c.maxLogsPerSecond,
+		Workers:          c.workers,
 	}
 
 	t := tracker.NewServer(cfg, actionReader, actionRouter)
+	servers[cfg.Protocol] = t
+	go logCounters(servers)
+
 	log.Printf("tracker listening on udp %s", c.bind)
-	return t.Listen()
+	err := t.Listen(ctx)
+	stop()
+	wg.Wait()
+
+	if err == nil {
+		log.Printf("tracker stopped")
+	}
+
+	return err
+}
+
+// logCounters periodically logs the counters of each tracker server
+func logCounters(servers map[string]tracker.Server) {
+	for range time.Tick(countersLogInterval) {
+		for protocol, server := range servers {
+			log.Printf("tracker %s: %s", protocol, server.Counters())
+		}
+	}
+}
+
+// loadSwarmSecrets reads the json file at path, and registers its gorrents along their secret
+func loadSwarmSecrets(path string, registry store.Registry) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var hexSecrets map[string]string
+	if err := json.Unmarshal(data, &hexSecrets); err != nil {
+		return err
+	}
+
+	for hexInfoHash, hexSecret := range hexSecrets {
+		infoHash, err := gorrent.ParseSha1Hash(hexInfoHash)
+		if err != nil {
+			return fmt.Errorf("swarmSecrets: %s: %s", hexInfoHash, err)
+		}
+
+		secret, err := hex.DecodeString(hexSecret)
+		if err != nil {
+			return fmt.Errorf("swarmSecrets: %s: %s", hexInfoHash, err)
+		}
+
+		registry.Register(infoHash, secret)
+	}
+
+	log.Printf("loaded %d swarm secrets", len(hexSecrets))
+
+	return nil
 }
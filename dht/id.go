@@ -0,0 +1,61 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"math/bits"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+// IDLength defines the number of bytes of a NodeID
+const IDLength = 20
+
+// NodeID identifies a DHT node. Info hashes live in the same key space,
+// the peers of an info hash being stored on the nodes whose ID is the closest to it.
+type NodeID [IDLength]byte
+
+// NewNodeID generates a new random NodeID
+func NewNodeID() NodeID {
+	var id NodeID
+	rand.Read(id[:])
+
+	return id
+}
+
+// NodeIDFromInfoHash returns the NodeID key of the info hash
+func NodeIDFromInfoHash(infoHash gorrent.Sha1Hash) NodeID {
+	return NodeID(infoHash)
+}
+
+// Distance returns the XOR distance between the two ids
+func (id NodeID) Distance(other NodeID) NodeID {
+	var d NodeID
+	for i := range id {
+		d[i] = id[i] ^ other[i]
+	}
+
+	return d
+}
+
+// Less returns true when id is lower than other
+func (id NodeID) Less(other NodeID) bool {
+	return bytes.Compare(id[:], other[:]) < 0
+}
+
+// PrefixLen returns the number of leading zero bits of the id
+func (id NodeID) PrefixLen() int {
+	for i, b := range id {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+
+	return IDLength * 8
+}
+
+// HexString returns the hexadecimal string representation of the id
+func (id NodeID) HexString() string {
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,159 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+const (
+	// MessagePing checks a node is alive
+	MessagePing MessageType = 0x1
+	// MessagePong answers a MessagePing
+	MessagePong MessageType = 0x2
+	// MessageFindNode asks for the closest known nodes of Target
+	MessageFindNode MessageType = 0x3
+	// MessageNodes answers a MessageFindNode with the closest known Nodes
+	MessageNodes MessageType = 0x4
+	// MessageFindPeers asks for the peers of the info hash Target, or the closest known nodes when none are stored
+	MessageFindPeers MessageType = 0x5
+	// MessagePeers answers a MessageFindPeers with Peers and Nodes, along a Token allowing to announce
+	MessagePeers MessageType = 0x6
+	// MessageAnnounce stores the sender as a peer of the info hash Target, listening on Port
+	MessageAnnounce MessageType = 0x7
+	// MessageAnnounced answers a MessageAnnounce
+	MessageAnnounced MessageType = 0x8
+
+	// TokenSize defines the number of bytes of an announce token
+	TokenSize = 20
+)
+
+var (
+	// ErrInvalidMessage is returned when a datagram cannot be decoded as a DHT message
+	ErrInvalidMessage = errors.New("invalid dht message")
+)
+
+// MessageType identifies a DHT message
+type MessageType uint8
+
+// Token is handed by nodes answering a MessageFindPeers, and must be sent back on MessageAnnounce
+// to prove the announcing node owns its address.
+type Token [TokenSize]byte
+
+// Contact holds a node id and address
+type Contact struct {
+	ID   NodeID
+	Addr gorrent.PeerAddr
+}
+
+// Message is a DHT protocol message.
+// On the wire, it is the type byte, the transaction id as a big endian uint32, the sender id, the target id, the port
+// as a big endian uint16, the token, the nodes and the peers.
+// Nodes are prefixed by their count as a big endian uint16, each being the node id followed by its address length and
+// compact address. Peers are encoded with gorrent.EncodePeerAddrs.
+type Message struct {
+	Type   MessageType
+	TxID   uint32
+	Sender NodeID
+	Target NodeID
+	Port   uint16
+	Token  Token
+	Nodes  []Contact
+	Peers  []gorrent.PeerAddr
+}
+
+type messageHeader struct {
+	Type   MessageType
+	TxID   uint32
+	Sender NodeID
+	Target NodeID
+	Port   uint16
+	Token  Token
+}
+
+// EncodeMessage returns the binary representation of the message
+func EncodeMessage(m *Message) []byte {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, &messageHeader{
+		Type:   m.Type,
+		TxID:   m.TxID,
+		Sender: m.Sender,
+		Target: m.Target,
+		Port:   m.Port,
+		Token:  m.Token,
+	})
+
+	binary.Write(buf, binary.BigEndian, uint16(len(m.Nodes)))
+	for _, c := range m.Nodes {
+		addr, _ := c.Addr.Bytes()
+		buf.Write(c.ID[:])
+		buf.WriteByte(byte(len(addr)))
+		buf.Write(addr)
+	}
+
+	buf.Write(gorrent.EncodePeerAddrs(m.Peers))
+
+	return buf.Bytes()
+}
+
+// DecodeMessage reads a message from b
+func DecodeMessage(b []byte) (*Message, error) {
+	r := bytes.NewReader(b)
+
+	h := &messageHeader{}
+	if err := binary.Read(r, binary.BigEndian, h); err != nil {
+		return nil, ErrInvalidMessage
+	}
+
+	m := &Message{
+		Type:   h.Type,
+		TxID:   h.TxID,
+		Sender: h.Sender,
+		Target: h.Target,
+		Port:   h.Port,
+		Token:  h.Token,
+	}
+
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, ErrInvalidMessage
+	}
+
+	for i := 0; i < int(count); i++ {
+		c := Contact{}
+		if _, err := io.ReadFull(r, c.ID[:]); err != nil {
+			return nil, ErrInvalidMessage
+		}
+
+		size, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidMessage
+		}
+
+		addr := make([]byte, size)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return nil, ErrInvalidMessage
+		}
+
+		c.Addr, err = gorrent.ParsePeerAddr(addr)
+		if err != nil {
+			return nil, ErrInvalidMessage
+		}
+
+		m.Nodes = append(m.Nodes, c)
+	}
+
+	rest := make([]byte, r.Len())
+	r.Read(rest)
+
+	peers, _, err := gorrent.DecodePeerAddrs(rest)
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+	m.Peers = peers
+
+	return m, nil
+}
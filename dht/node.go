@@ -0,0 +1,495 @@
+package dht
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+const (
+	// DefaultRequestTimeout defines how long a node waits for an answer before considering the remote node dead
+	DefaultRequestTimeout = time.Second
+	// DefaultPeerTTL defines how long announced peers are stored
+	DefaultPeerTTL = 30 * time.Minute
+	// Alpha defines the number of concurrent requests during a lookup
+	Alpha = 3
+	// MaxPeers defines the maximum number of peers returned on a MessageFindPeers
+	MaxPeers = 50
+
+	maxDatagramSize = 4096
+)
+
+var (
+	// ErrTimeout is returned when a remote node did not answer in time
+	ErrTimeout = errors.New("dht request timed out")
+	// ErrNodeClosed is returned when sending a request on a closed node
+	ErrNodeClosed = errors.New("dht node closed")
+	// ErrBootstrapFailed is returned when none of the bootstrap nodes answered
+	ErrBootstrapFailed = errors.New("no dht bootstrap node answered")
+	// ErrNoNodes is returned when no remote node could be reached
+	ErrNoNodes = errors.New("no dht node reachable")
+)
+
+// Config list the options of a DHT node
+// A zero ID generates a random one, and zero durations fallback to their defaults.
+type Config struct {
+	Addr           string
+	ID             NodeID
+	RequestTimeout time.Duration
+	PeerTTL        time.Duration
+}
+
+// Node is a member of the gorrent distributed hash table, storing and looking up peers by info hash
+type Node interface {
+	ID() NodeID
+	Addr() net.Addr
+	Bootstrap(addrs []string) error
+	Announce(infoHash gorrent.Sha1Hash, port uint16) ([]gorrent.PeerAddr, error)
+	FindPeers(infoHash gorrent.Sha1Hash) ([]gorrent.PeerAddr, error)
+	Close() error
+}
+
+type node struct {
+	id          NodeID
+	conn        net.PacketConn
+	table       *routingTable
+	peers       *peerStore
+	timeout     time.Duration
+	tokenSecret []byte
+
+	mutex    sync.Mutex
+	pending  map[uint32]chan *Message
+	nextTxID uint32
+	closed   bool
+}
+
+var _ Node = &node{}
+
+// NewNode creates a new DHT node, listening on cfg.Addr
+func NewNode(cfg Config) (Node, error) {
+	if cfg.ID == (NodeID{}) {
+		cfg.ID = NewNodeID()
+	}
+
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = DefaultRequestTimeout
+	}
+
+	if cfg.PeerTTL == 0 {
+		cfg.PeerTTL = DefaultPeerTTL
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenPacket("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{
+		id:          cfg.ID,
+		conn:        conn,
+		table:       newRoutingTable(cfg.ID),
+		peers:       newPeerStore(cfg.PeerTTL),
+		timeout:     cfg.RequestTimeout,
+		tokenSecret: secret,
+		pending:     make(map[uint32]chan *Message),
+		nextTxID:    binary.BigEndian.Uint32(secret),
+	}
+
+	go n.readLoop()
+
+	return n, nil
+}
+
+func (n *node) ID() NodeID {
+	return n.id
+}
+
+func (n *node) Addr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// Bootstrap joins the DHT through the given nodes, and fills the routing table with the closest nodes of its own id
+func (n *node) Bootstrap(addrs []string) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	joined := false
+	for _, addr := range addrs {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			log.Printf("DHT: invalid bootstrap node %s: %s", addr, err)
+
+			continue
+		}
+
+		if _, err := n.rpc(gorrent.NewPeerAddr(udpAddr.IP, uint16(udpAddr.Port)), &Message{Type: MessagePing}); err != nil {
+			log.Printf("DHT: bootstrap node %s: %s", addr, err)
+
+			continue
+		}
+
+		joined = true
+	}
+
+	if !joined {
+		return ErrBootstrapFailed
+	}
+
+	n.lookup(n.id, MessageFindNode)
+	log.Printf("DHT: joined with %d known nodes", n.table.size())
+
+	return nil
+}
+
+// Announce stores the local peer, listening on port, on the closest nodes of the info hash.
+// It returns the peers already known for this info hash.
+func (n *node) Announce(infoHash gorrent.Sha1Hash, port uint16) ([]gorrent.PeerAddr, error) {
+	key := NodeIDFromInfoHash(infoHash)
+
+	result := n.lookup(key, MessageFindPeers)
+	if len(result.contacts) == 0 {
+		return result.peers, ErrNoNodes
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range result.contacts {
+		wg.Add(1)
+		go func(c Contact) {
+			defer wg.Done()
+
+			_, err := n.rpc(c.Addr, &Message{
+				Type:   MessageAnnounce,
+				Target: key,
+				Port:   port,
+				Token:  result.tokens[c.ID],
+			})
+			if err != nil {
+				log.Printf("DHT: announce %s on %s failed: %s", infoHash.HexString(), c.Addr, err)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return result.peers, nil
+}
+
+// FindPeers returns the peers announced for the info hash
+func (n *node) FindPeers(infoHash gorrent.Sha1Hash) ([]gorrent.PeerAddr, error) {
+	result := n.lookup(NodeIDFromInfoHash(infoHash), MessageFindPeers)
+	if len(result.contacts) == 0 && len(result.peers) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	return result.peers, nil
+}
+
+func (n *node) Close() error {
+	n.mutex.Lock()
+	n.closed = true
+	n.mutex.Unlock()
+
+	return n.conn.Close()
+}
+
+type lookupResult struct {
+	contacts []Contact
+	tokens   map[NodeID]Token
+	peers    []gorrent.PeerAddr
+}
+
+// lookup iteratively queries the closest nodes of target with msgType, until the BucketSize closest known nodes
+// have all answered. It returns these nodes, along their tokens and the peers they returned.
+func (n *node) lookup(target NodeID, msgType MessageType) *lookupResult {
+	result := &lookupResult{
+		tokens: make(map[NodeID]Token),
+	}
+
+	foundPeers := make(map[gorrent.PeerAddr]bool)
+	addPeers := func(peers []gorrent.PeerAddr) {
+		for _, p := range peers {
+			if !foundPeers[p] {
+				foundPeers[p] = true
+				result.peers = append(result.peers, p)
+			}
+		}
+	}
+
+	if msgType == MessageFindPeers {
+		addPeers(n.peers.get(target, MaxPeers, time.Now()))
+	}
+
+	shortlist := n.table.closest(target, BucketSize)
+	known := map[NodeID]bool{n.id: true}
+	for _, c := range shortlist {
+		known[c.ID] = true
+	}
+	queried := make(map[NodeID]bool)
+
+	type reply struct {
+		contact Contact
+		msg     *Message
+		err     error
+	}
+
+	for {
+		var batch []Contact
+		for i := 0; i < len(shortlist) && i < BucketSize && len(batch) < Alpha; i++ {
+			if !queried[shortlist[i].ID] {
+				queried[shortlist[i].ID] = true
+				batch = append(batch, shortlist[i])
+			}
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		replies := make(chan reply, len(batch))
+		for _, c := range batch {
+			go func(c Contact) {
+				msg, err := n.rpc(c.Addr, &Message{Type: msgType, Target: target})
+				replies <- reply{contact: c, msg: msg, err: err}
+			}(c)
+		}
+
+		failed := make(map[NodeID]bool)
+		for range batch {
+			r := <-replies
+			if r.err != nil {
+				n.table.remove(r.contact.ID)
+				failed[r.contact.ID] = true
+
+				continue
+			}
+
+			result.contacts = append(result.contacts, r.contact)
+			result.tokens[r.contact.ID] = r.msg.Token
+			addPeers(r.msg.Peers)
+
+			for _, c := range r.msg.Nodes {
+				if !known[c.ID] {
+					known[c.ID] = true
+					shortlist = append(shortlist, c)
+				}
+			}
+		}
+
+		alive := shortlist[:0]
+		for _, c := range shortlist {
+			if !failed[c.ID] {
+				alive = append(alive, c)
+			}
+		}
+		shortlist = alive
+
+		sortByDistance(shortlist, target)
+	}
+
+	sortByDistance(result.contacts, target)
+	if len(result.contacts) > BucketSize {
+		result.contacts = result.contacts[:BucketSize]
+	}
+
+	return result
+}
+
+// rpc sends the request to addr and waits for its answer
+func (n *node) rpc(addr gorrent.PeerAddr, req *Message) (*Message, error) {
+	n.mutex.Lock()
+	if n.closed {
+		n.mutex.Unlock()
+		return nil, ErrNodeClosed
+	}
+
+	n.nextTxID++
+	req.TxID = n.nextTxID
+	req.Sender = n.id
+
+	ch := make(chan *Message, 1)
+	n.pending[req.TxID] = ch
+	n.mutex.Unlock()
+
+	defer func() {
+		n.mutex.Lock()
+		delete(n.pending, req.TxID)
+		n.mutex.Unlock()
+	}()
+
+	udpAddr := &net.UDPAddr{IP: addr.IP(), Port: int(addr.Port)}
+	if _, err := n.conn.WriteTo(EncodeMessage(req), udpAddr); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-time.After(n.timeout):
+		return nil, ErrTimeout
+	}
+}
+
+func (n *node) readLoop() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		size, src, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			if n.isClosed() {
+				return
+			}
+
+			log.Printf("DHT: read error: %s", err)
+
+			continue
+		}
+
+		msg, err := DecodeMessage(buf[:size])
+		if err != nil {
+			log.Printf("DHT: ignoring datagram from %s: %s", src, err)
+
+			continue
+		}
+
+		udpAddr := src.(*net.UDPAddr)
+		from := gorrent.NewPeerAddr(udpAddr.IP, uint16(udpAddr.Port))
+
+		switch msg.Type {
+		case MessagePong, MessageNodes, MessagePeers, MessageAnnounced:
+			if n.resolve(msg) {
+				n.table.seen(Contact{ID: msg.Sender, Addr: from})
+			}
+		default:
+			n.table.seen(Contact{ID: msg.Sender, Addr: from})
+			n.handle(msg, from, src)
+		}
+	}
+}
+
+// resolve hands the response to the pending request, and returns false when no request matches
+func (n *node) resolve(msg *Message) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	ch, ok := n.pending[msg.TxID]
+	if !ok {
+		return false
+	}
+
+	delete(n.pending, msg.TxID)
+	ch <- msg
+
+	return true
+}
+
+// handle answers the request of a remote node
+func (n *node) handle(req *Message, from gorrent.PeerAddr, src net.Addr) {
+	resp := &Message{
+		TxID:   req.TxID,
+		Sender: n.id,
+		Target: req.Target,
+	}
+
+	switch req.Type {
+	case MessagePing:
+		resp.Type = MessagePong
+	case MessageFindNode:
+		resp.Type = MessageNodes
+		resp.Nodes = n.table.closest(req.Target, BucketSize)
+	case MessageFindPeers:
+		resp.Type = MessagePeers
+		resp.Token = n.token(from)
+		resp.Peers = n.peers.get(req.Target, MaxPeers, time.Now())
+		resp.Nodes = n.table.closest(req.Target, BucketSize)
+	case MessageAnnounce:
+		expected := n.token(from)
+		if !hmac.Equal(req.Token[:], expected[:]) {
+			log.Printf("DHT: %s announced %s with an invalid token", from, req.Target.HexString())
+
+			return
+		}
+
+		n.peers.add(req.Target, gorrent.NewPeerAddr(from.IP(), req.Port), time.Now())
+		resp.Type = MessageAnnounced
+	default:
+		log.Printf("DHT: %s sent unexpected message %#x", from, req.Type)
+
+		return
+	}
+
+	if _, err := n.conn.WriteTo(EncodeMessage(resp), src); err != nil {
+		log.Printf("DHT: write error to %s: %s", from, err)
+	}
+}
+
+// token returns the announce token of the remote ip
+func (n *node) token(from gorrent.PeerAddr) Token {
+	h := hmac.New(sha256.New, n.tokenSecret)
+	h.Write(from.IPAddr[:])
+
+	var t Token
+	copy(t[:], h.Sum(nil))
+
+	return t
+}
+
+func (n *node) isClosed() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.closed
+}
+
+// DummyNode provides a configurable Node
+type DummyNode struct {
+	IDFunc        func() NodeID
+	AddrFunc      func() net.Addr
+	BootstrapFunc func(addrs []string) error
+	AnnounceFunc  func(infoHash gorrent.Sha1Hash, port uint16) ([]gorrent.PeerAddr, error)
+	FindPeersFunc func(infoHash gorrent.Sha1Hash) ([]gorrent.PeerAddr, error)
+	CloseFunc     func() error
+}
+
+var _ Node = &DummyNode{}
+
+// ID calls IDFunc
+func (d *DummyNode) ID() NodeID {
+	return d.IDFunc()
+}
+
+// Addr calls AddrFunc
+func (d *DummyNode) Addr() net.Addr {
+	return d.AddrFunc()
+}
+
+// Bootstrap calls BootstrapFunc
+func (d *DummyNode) Bootstrap(addrs []string) error {
+	return d.BootstrapFunc(addrs)
+}
+
+// Announce calls AnnounceFunc
+func (d *DummyNode) Announce(infoHash gorrent.Sha1Hash, port uint16) ([]gorrent.PeerAddr, error) {
+	return d.AnnounceFunc(infoHash, port)
+}
+
+// FindPeers calls FindPeersFunc
+func (d *DummyNode) FindPeers(infoHash gorrent.Sha1Hash) ([]gorrent.PeerAddr, error) {
+	return d.FindPeersFunc(infoHash)
+}
+
+// Close calls CloseFunc
+func (d *DummyNode) Close() error {
+	return d.CloseFunc()
+}
@@ -0,0 +1,140 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestMessage(t *testing.T) {
+	t.Run("DecodeMessage reads what EncodeMessage wrote", func(t *testing.T) {
+		m := &Message{
+			Type:   MessagePeers,
+			TxID:   42,
+			Sender: NewNodeID(),
+			Target: NewNodeID(),
+			Port:   1234,
+			Token:  Token{0x1},
+			Nodes: []Contact{
+				{ID: NewNodeID(), Addr: gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1)},
+				{ID: NewNodeID(), Addr: gorrent.NewPeerAddr(net.ParseIP("2001:db8::1"), 2)},
+			},
+			Peers: []gorrent.PeerAddr{gorrent.NewPeerAddr(net.ParseIP("10.0.0.2"), 3)},
+		}
+
+		decoded, err := DecodeMessage(EncodeMessage(m))
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if decoded.Type != m.Type || decoded.TxID != m.TxID || decoded.Sender != m.Sender || decoded.Target != m.Target || decoded.Port != m.Port || decoded.Token != m.Token {
+			t.Fatalf("Expected message to be %#v, got %#v", m, decoded)
+		}
+
+		if len(decoded.Nodes) != 2 || decoded.Nodes[0] != m.Nodes[0] || decoded.Nodes[1] != m.Nodes[1] {
+			t.Fatalf("Expected nodes to be %v, got %v", m.Nodes, decoded.Nodes)
+		}
+
+		if len(decoded.Peers) != 1 || decoded.Peers[0] != m.Peers[0] {
+			t.Fatalf("Expected peers to be %v, got %v", m.Peers, decoded.Peers)
+		}
+	})
+
+	t.Run("DecodeMessage fails on truncated datagrams", func(t *testing.T) {
+		if _, err := DecodeMessage([]byte{0x1, 0x2}); err != ErrInvalidMessage {
+			t.Fatalf("Expected err to be %s, got %v", ErrInvalidMessage, err)
+		}
+	})
+}
+
+func newTestNetwork(t *testing.T, size int) []Node {
+	var nodes []Node
+	for i := 0; i < size; i++ {
+		n, err := NewNode(Config{Addr: "127.0.0.1:0", RequestTimeout: 200 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Close() })
+
+		if i > 0 {
+			if err := n.Bootstrap([]string{nodes[0].Addr().String()}); err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+func TestNode(t *testing.T) {
+	t.Run("Peers announced on a node are found from any other node", func(t *testing.T) {
+		nodes := newTestNetwork(t, 12)
+		infoHash := gorrent.RandomSha1Hash()
+
+		if _, err := nodes[5].Announce(infoHash, 1234); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		for i, n := range nodes {
+			peers, err := n.FindPeers(infoHash)
+			if err != nil {
+				t.Fatalf("Expected no error on node %d, got %s", i, err)
+			}
+
+			expected := gorrent.NewPeerAddr(net.ParseIP("127.0.0.1"), 1234)
+			if len(peers) != 1 || peers[0] != expected {
+				t.Fatalf("Expected node %d to find %v, got %v", i, expected, peers)
+			}
+		}
+	})
+
+	t.Run("Announce returns the peers already announced", func(t *testing.T) {
+		nodes := newTestNetwork(t, 4)
+		infoHash := gorrent.RandomSha1Hash()
+
+		if _, err := nodes[1].Announce(infoHash, 1111); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		peers, err := nodes[2].Announce(infoHash, 2222)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if len(peers) != 1 || peers[0].Port != 1111 {
+			t.Fatalf("Expected peers to be the first announcer, got %v", peers)
+		}
+	})
+
+	t.Run("Bootstrap fails when no node answers", func(t *testing.T) {
+		nodes := newTestNetwork(t, 2)
+		addr := nodes[1].Addr().String()
+		nodes[1].Close()
+
+		n, err := NewNode(Config{Addr: "127.0.0.1:0", RequestTimeout: 100 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer n.Close()
+
+		if err := n.Bootstrap([]string{addr}); err != ErrBootstrapFailed {
+			t.Fatalf("Expected err to be %s, got %v", ErrBootstrapFailed, err)
+		}
+	})
+
+	t.Run("Announce fails without any reachable node", func(t *testing.T) {
+		n, err := NewNode(Config{Addr: "127.0.0.1:0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer n.Close()
+
+		if _, err := n.Announce(gorrent.RandomSha1Hash(), 1234); err != ErrNoNodes {
+			t.Fatalf("Expected err to be %s, got %v", ErrNoNodes, err)
+		}
+	})
+}
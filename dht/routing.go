@@ -0,0 +1,105 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+)
+
+// BucketSize defines the maximum number of contacts per bucket, and the number of nodes storing each info hash
+const BucketSize = 8
+
+// routingTable holds the known contacts, in one bucket per distance prefix length from the local node.
+// Buckets are ordered from the least to the most recently seen contact.
+type routingTable struct {
+	self    NodeID
+	mutex   sync.RWMutex
+	buckets [IDLength*8 + 1][]Contact
+}
+
+func newRoutingTable(self NodeID) *routingTable {
+	return &routingTable{
+		self: self,
+	}
+}
+
+func (t *routingTable) bucketIndex(id NodeID) int {
+	return t.self.Distance(id).PrefixLen()
+}
+
+// seen adds or refreshes the contact. Full buckets keep their long lived contacts over new ones,
+// dead contacts being removed when they fail to answer.
+func (t *routingTable) seen(c Contact) {
+	if c.ID == t.self {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	i := t.bucketIndex(c.ID)
+	bucket := t.buckets[i]
+
+	for j, known := range bucket {
+		if known.ID == c.ID {
+			bucket = append(bucket[:j], bucket[j+1:]...)
+			t.buckets[i] = append(bucket, c)
+
+			return
+		}
+	}
+
+	if len(bucket) < BucketSize {
+		t.buckets[i] = append(bucket, c)
+	}
+}
+
+// remove forgets the contact
+func (t *routingTable) remove(id NodeID) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	i := t.bucketIndex(id)
+	for j, known := range t.buckets[i] {
+		if known.ID == id {
+			t.buckets[i] = append(t.buckets[i][:j], t.buckets[i][j+1:]...)
+
+			return
+		}
+	}
+}
+
+// closest returns up to count known contacts, sorted by their distance to target
+func (t *routingTable) closest(target NodeID, count int) []Contact {
+	t.mutex.RLock()
+	var contacts []Contact
+	for _, bucket := range t.buckets {
+		contacts = append(contacts, bucket...)
+	}
+	t.mutex.RUnlock()
+
+	sortByDistance(contacts, target)
+	if len(contacts) > count {
+		contacts = contacts[:count]
+	}
+
+	return contacts
+}
+
+// size returns the number of known contacts
+func (t *routingTable) size() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	n := 0
+	for _, bucket := range t.buckets {
+		n += len(bucket)
+	}
+
+	return n
+}
+
+func sortByDistance(contacts []Contact, target NodeID) {
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].ID.Distance(target).Less(contacts[j].ID.Distance(target))
+	})
+}
@@ -0,0 +1,48 @@
+package dht
+
+import (
+	"testing"
+)
+
+func TestRoutingTable(t *testing.T) {
+	t.Run("closest returns contacts sorted by distance to the target", func(t *testing.T) {
+		table := newRoutingTable(NodeID{})
+
+		ids := []NodeID{{0x80}, {0x01}, {0x40}, {0x03}}
+		for _, id := range ids {
+			table.seen(Contact{ID: id})
+		}
+
+		closest := table.closest(NodeID{0x02}, 3)
+
+		expected := []NodeID{{0x03}, {0x01}, {0x40}}
+		if len(closest) != len(expected) {
+			t.Fatalf("Expected %d contacts, got %d", len(expected), len(closest))
+		}
+
+		for i, c := range closest {
+			if c.ID != expected[i] {
+				t.Fatalf("Expected contact %d to be %s, got %s", i, expected[i].HexString(), c.ID.HexString())
+			}
+		}
+	})
+
+	t.Run("seen keeps the known contacts of full buckets", func(t *testing.T) {
+		table := newRoutingTable(NodeID{})
+
+		for i := 0; i < BucketSize+1; i++ {
+			table.seen(Contact{ID: NodeID{0x80, byte(i)}})
+		}
+
+		if table.size() != BucketSize {
+			t.Fatalf("Expected %d contacts, got %d", BucketSize, table.size())
+		}
+
+		table.remove(NodeID{0x80, 0x0})
+		table.seen(Contact{ID: NodeID{0x80, byte(BucketSize)}})
+
+		if len(table.closest(NodeID{0x80, byte(BucketSize)}, 1)) != 1 || table.closest(NodeID{0x80, byte(BucketSize)}, 1)[0].ID != (NodeID{0x80, byte(BucketSize)}) {
+			t.Fatalf("Expected new contact to be added once a slot is free")
+		}
+	})
+}
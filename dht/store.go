@@ -0,0 +1,107 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+const (
+	// MaxStoredKeys defines the maximum number of info hashes a node stores peers for
+	MaxStoredKeys = 10000
+	// MaxStoredPeers defines the maximum number of peers a node stores per info hash
+	MaxStoredPeers = 200
+)
+
+// peerStore holds the peers announced on the local node, by info hash
+// Expired peers are swept from all the keys at most once per ttl, when adding peers.
+type peerStore struct {
+	ttl       time.Duration
+	mutex     sync.Mutex
+	peers     map[NodeID]map[gorrent.PeerAddr]time.Time
+	lastSweep time.Time
+}
+
+func newPeerStore(ttl time.Duration) *peerStore {
+	return &peerStore{
+		ttl:   ttl,
+		peers: make(map[NodeID]map[gorrent.PeerAddr]time.Time),
+	}
+}
+
+// add stores addr as a peer of key. New keys are dropped once MaxStoredKeys is reached,
+// and the oldest peer of key makes room when it already holds MaxStoredPeers.
+func (s *peerStore) add(key NodeID, addr gorrent.PeerAddr, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.Sub(s.lastSweep) > s.ttl {
+		s.sweep(now)
+	}
+
+	peers, ok := s.peers[key]
+	if !ok {
+		if len(s.peers) >= MaxStoredKeys {
+			return
+		}
+
+		peers = make(map[gorrent.PeerAddr]time.Time)
+		s.peers[key] = peers
+	}
+
+	if _, ok := peers[addr]; !ok && len(peers) >= MaxStoredPeers {
+		var oldest gorrent.PeerAddr
+		var oldestSeen time.Time
+		for a, seen := range peers {
+			if oldestSeen.IsZero() || seen.Before(oldestSeen) {
+				oldest, oldestSeen = a, seen
+			}
+		}
+		delete(peers, oldest)
+	}
+
+	peers[addr] = now
+}
+
+// sweep removes the expired peers of all the keys. Must be called with the mutex held.
+func (s *peerStore) sweep(now time.Time) {
+	for key, peers := range s.peers {
+		for addr, seen := range peers {
+			if now.Sub(seen) > s.ttl {
+				delete(peers, addr)
+			}
+		}
+
+		if len(peers) == 0 {
+			delete(s.peers, key)
+		}
+	}
+
+	s.lastSweep = now
+}
+
+// get returns up to max peers of key, removing the expired ones
+func (s *peerStore) get(key NodeID, max int, now time.Time) []gorrent.PeerAddr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var addrs []gorrent.PeerAddr
+	for addr, seen := range s.peers[key] {
+		if now.Sub(seen) > s.ttl {
+			delete(s.peers[key], addr)
+
+			continue
+		}
+
+		if len(addrs) < max {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if len(s.peers[key]) == 0 {
+		delete(s.peers, key)
+	}
+
+	return addrs
+}
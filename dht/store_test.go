@@ -0,0 +1,63 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestPeerStore(t *testing.T) {
+	t.Run("add sweeps the expired peers of all the keys", func(t *testing.T) {
+		s := newPeerStore(time.Minute)
+		now := time.Now()
+
+		s.add(NodeID{0x01}, gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1), now)
+		s.add(NodeID{0x02}, gorrent.NewPeerAddr(net.ParseIP("10.0.0.2"), 2), now.Add(2*time.Minute))
+
+		if len(s.peers) != 1 || s.peers[NodeID{0x01}] != nil {
+			t.Fatalf("Expected the expired key to be swept, got %v", s.peers)
+		}
+	})
+
+	t.Run("add caps the number of keys", func(t *testing.T) {
+		s := newPeerStore(time.Minute)
+		now := time.Now()
+		addr := gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1)
+
+		for i := 0; i < MaxStoredKeys+1; i++ {
+			s.add(NodeID{byte(i >> 8), byte(i)}, addr, now)
+		}
+
+		if len(s.peers) != MaxStoredKeys {
+			t.Fatalf("Expected %d keys, got %d", MaxStoredKeys, len(s.peers))
+		}
+
+		// Known keys still get their peers refreshed
+		s.add(NodeID{0, 0}, gorrent.NewPeerAddr(net.ParseIP("10.0.0.2"), 2), now)
+		if len(s.peers[NodeID{0, 0}]) != 2 {
+			t.Fatalf("Expected the known key to have 2 peers, got %d", len(s.peers[NodeID{0, 0}]))
+		}
+	})
+
+	t.Run("add replaces the oldest peer of full keys", func(t *testing.T) {
+		s := newPeerStore(time.Hour)
+		now := time.Now()
+		key := NodeID{0x01}
+
+		oldest := gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1)
+		s.add(key, oldest, now)
+		for i := 1; i < MaxStoredPeers+1; i++ {
+			s.add(key, gorrent.NewPeerAddr(net.ParseIP("10.0.1.1"), uint16(i)), now.Add(time.Second))
+		}
+
+		if len(s.peers[key]) != MaxStoredPeers {
+			t.Fatalf("Expected %d peers, got %d", MaxStoredPeers, len(s.peers[key]))
+		}
+
+		if _, ok := s.peers[key][oldest]; ok {
+			t.Fatalf("Expected the oldest peer to be replaced")
+		}
+	})
+}
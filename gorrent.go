@@ -7,13 +7,14 @@ import (
 	"github.com/daeMOn63/gorrent/cmd"
 )
 
-var create, peerd, trackerd cmd.Command
+var create, peerd, trackerd, scrape cmd.Command
 
 func main() {
 
 	create = cmd.NewCreate()
 	peerd = cmd.NewPeerDaemon()
 	trackerd = cmd.NewTrackerDaemon()
+	scrape = cmd.NewScrape()
 
 	if len(os.Args) < 2 {
 		usage()
@@ -26,6 +27,8 @@ func main() {
 		peerd.FlagSet().Parse(os.Args[2:])
 	case trackerd.FlagSet().Name():
 		trackerd.FlagSet().Parse(os.Args[2:])
+	case scrape.FlagSet().Name():
+		scrape.FlagSet().Parse(os.Args[2:])
 	default:
 		fmt.Printf("error: unknown command `%s`\n", os.Args[1])
 		usage()
@@ -40,6 +43,9 @@ func main() {
 	} else if trackerd.FlagSet().Parsed() {
 		err := trackerd.Run(os.Stdout, os.Stdin)
 		checkCmdError(trackerd, err)
+	} else if scrape.FlagSet().Parsed() {
+		err := scrape.Run(os.Stdout, os.Stdin)
+		checkCmdError(scrape, err)
 	}
 }
 
@@ -60,12 +66,14 @@ func usage() {
 	fmt.Printf("\t%s <subcommand> [flag...]\n", os.Args[0])
 	fmt.Println()
 	fmt.Printf("Available subcommands:\n\n")
-	fmt.Printf("create -src <path> [-dst <path>] [-fsWorkers <num>] [-pieceLength <num>]\n")
+	fmt.Printf("create -src <path> [-announce <ip>:<port>[,<ip>:<port>...]]... [-dst <path>] [-fsWorkers <num>] [-pieceLength <num>] [-private]\n")
 	fmt.Printf("  Create a new gorrent file from a source file or directory.\n\n")
 	fmt.Printf("peerd [-config <path>]\n")
 	fmt.Printf("  Start a gorrent peer daemon.\n\n")
-	fmt.Printf("trackerd [-bind <ip>:<port>] [-read-timeout <num>] [-write-timeout <num>]\n")
+	fmt.Printf("trackerd [-bind <ip>:<port>] [-tcpBind <ip>:<port>] [-httpBind <ip>:<port> [-tlsCert <path> -tlsKey <path>]] [-announceInterval <num>] [-minAnnounceInterval <num>] [-maxPeerAge <num>] [-read-timeout <num>] [-write-timeout <num>] [-swarmSecrets <path>] [-maxPeers <num>] [-useSourceIP] [-store memory|bolt] [-dbPath <path>] [-allowlist] [-adminBind <ip>:<port> -adminToken <token>] [-rateLimit <num>] [-rateBurst <num>] [-maxInfoHashes <num>] [-maxLogsPerSecond <num>] [-clusterBind <ip>:<port> -clusterPeers <ip>:<port>,... -clusterToken <token>] [-workers <num>]\n")
 	fmt.Printf("  Start a gorrent tracker daemon.\n\n")
+	fmt.Printf("scrape -tracker <ip>:<port>|<url> [-protocol udp|tcp|http|https] -infoHash <hex>[,<hex>...] | -gorrent <file>[,<file>...]\n")
+	fmt.Printf("  Query a tracker for the seeders, leechers and completed downloads of gorrents.\n\n")
 	fmt.Println()
 	os.Exit(1)
 }
@@ -3,6 +3,7 @@ package gorrent
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"time"
 )
 
@@ -13,11 +14,16 @@ const (
 
 // Gorrent is a struct holding informations about the shared file(s).
 type Gorrent struct {
-	Files        []File
-	Announce     string
+	Files    []File
+	Announce string
+	// AnnounceList holds tiers of trackers ip:port. Trackers of a tier are tried in turn until one responds,
+	// and all the tiers are announced to. When empty, Announce is the single tracker.
+	AnnounceList [][]string
 	CreationDate time.Time
 	Pieces       []Sha1Hash
 	PieceLength  int
+	// Secret is an optional swarm secret, peers and trackers only serve peers proving its knowledge
+	Secret []byte
 }
 
 // File is a struct containing shared file details
@@ -28,9 +34,28 @@ type File struct {
 	Hash   Sha1Hash
 }
 
+var (
+	// ErrInvalidSha1Hash is returned when a string cannot be parsed as a Sha1Hash
+	ErrInvalidSha1Hash = errors.New("invalid sha1 hash")
+)
+
 // Sha1Hash is an alias for sha1 hashes
 type Sha1Hash [sha1.Size]byte
 
+// ParseSha1Hash returns the Sha1Hash from its hexadecimal string representation
+func ParseSha1Hash(s string) (Sha1Hash, error) {
+	var h Sha1Hash
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, ErrInvalidSha1Hash
+	}
+
+	copy(h[:], b)
+
+	return h, nil
+}
+
 // Bytes returns the Sha1Hash as a byte slice
 func (s Sha1Hash) Bytes() []byte {
 	return s[:]
@@ -41,6 +66,19 @@ func (s Sha1Hash) HexString() string {
 	return hex.EncodeToString(s.Bytes())
 }
 
+// Trackers returns the tiers of trackers to announce to
+func (g *Gorrent) Trackers() [][]string {
+	if len(g.AnnounceList) > 0 {
+		return g.AnnounceList
+	}
+
+	if len(g.Announce) > 0 {
+		return [][]string{{g.Announce}}
+	}
+
+	return nil
+}
+
 // TotalFileSize return the summed size of all files in this gorrent
 func (g *Gorrent) TotalFileSize() uint64 {
 	var t uint64
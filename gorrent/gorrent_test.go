@@ -27,3 +27,27 @@ func TestGorrent(t *testing.T) {
 		}
 	})
 }
+
+func TestProof(t *testing.T) {
+	t.Run("VerifyProof accepts proofs from the same secret and challenge", func(t *testing.T) {
+		secret, err := NewSecret()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		infoHash := RandomSha1Hash()
+		proof := NewProof(secret, infoHash, []byte("challenge"))
+
+		if !VerifyProof(proof, secret, infoHash, []byte("challenge")) {
+			t.Fatalf("Expected proof to be valid")
+		}
+
+		if VerifyProof(proof, secret, infoHash, []byte("other")) {
+			t.Fatalf("Expected proof to be invalid with another challenge")
+		}
+
+		if VerifyProof(proof, []byte("other"), infoHash, []byte("challenge")) {
+			t.Fatalf("Expected proof to be invalid with another secret")
+		}
+	})
+}
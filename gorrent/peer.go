@@ -1,32 +1,89 @@
 package gorrent
 
 import (
-	"bytes"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+)
+
+const (
+	// CompactIPv4Size is the size of the compact representation of an IPv4 PeerAddr
+	CompactIPv4Size = net.IPv4len + 2
+	// CompactIPv6Size is the size of the compact representation of an IPv6 PeerAddr
+	CompactIPv6Size = net.IPv6len + 2
+)
+
+var (
+	// ErrInvalidPeerAddr is returned when bytes cannot be decoded as a PeerAddr
+	ErrInvalidPeerAddr = errors.New("invalid peer address")
+	// ErrInvalidPeerID is returned when a string cannot be parsed as a PeerID
+	ErrInvalidPeerID = errors.New("invalid peer ID")
 )
 
 // PeerAddr describes a peer address (ip and port)
+// Both IPv4 and IPv6 addresses are stored on 16 bytes, IPv4 ones being IPv4-mapped IPv6 addresses.
 type PeerAddr struct {
-	IPAddr uint32
+	IPAddr [net.IPv6len]byte
 	Port   uint16
 }
 
-// Bytes return the byte representation of the PeerAddr
+// NewPeerAddr creates a new PeerAddr
+func NewPeerAddr(ip net.IP, port uint16) PeerAddr {
+	addr := PeerAddr{
+		Port: port,
+	}
+	copy(addr.IPAddr[:], ip.To16())
+
+	return addr
+}
+
+// IP returns the PeerAddr ip
+func (p PeerAddr) IP() net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, p.IPAddr[:])
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
+
+// IsIPv4 returns true when the PeerAddr holds an IPv4 address
+func (p PeerAddr) IsIPv4() bool {
+	return p.IP().To4() != nil
+}
+
+// Bytes return the compact byte representation of the PeerAddr
+// It is the ip followed by the port, on 6 bytes for IPv4 addresses and 18 bytes for IPv6 ones.
 func (p PeerAddr) Bytes() ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
-	err := binary.Write(buf, binary.BigEndian, p)
-	if err != nil {
-		return nil, err
+	ip := p.IP()
+
+	b := make([]byte, len(ip)+2)
+	copy(b, ip)
+	binary.BigEndian.PutUint16(b[len(ip):], p.Port)
+
+	return b, nil
+}
+
+// ParsePeerAddr reads a PeerAddr from its compact byte representation
+func ParsePeerAddr(b []byte) (PeerAddr, error) {
+	if len(b) != CompactIPv4Size && len(b) != CompactIPv6Size {
+		return PeerAddr{}, ErrInvalidPeerAddr
 	}
 
-	return buf.Bytes(), nil
+	ip := net.IP(b[:len(b)-2])
+	port := binary.BigEndian.Uint16(b[len(b)-2:])
+
+	return NewPeerAddr(ip, port), nil
 }
 
 // String retuns a string representation of the PeerAddr
 func (p PeerAddr) String() string {
-	return fmt.Sprintf("%s:%d", int2ip(p.IPAddr), p.Port)
+	return net.JoinHostPort(p.IP().String(), strconv.Itoa(int(p.Port)))
 }
 
 // Peer defines the peer id, and exposed ip and port
@@ -39,28 +96,11 @@ type Peer struct {
 func NewPeer(id string, ip net.IP, port uint16) *Peer {
 	peerID := &PeerID{}
 	peerID.SetString(id)
-	ipInt := ip2int(ip)
 
 	return &Peer{
-		ID: *peerID,
-		PeerAddr: PeerAddr{
-			IPAddr: ipInt,
-			Port:   port,
-		},
-	}
-}
-
-func ip2int(ip net.IP) uint32 {
-	if len(ip) == 16 {
-		return binary.BigEndian.Uint32(ip[12:16])
+		ID:       *peerID,
+		PeerAddr: NewPeerAddr(ip, port),
 	}
-	return binary.BigEndian.Uint32(ip)
-}
-
-func int2ip(nn uint32) net.IP {
-	ip := make(net.IP, 4)
-	binary.BigEndian.PutUint32(ip, nn)
-	return ip
 }
 
 // PeerID defines a custom type to store the Peer IDentifier
@@ -75,3 +115,79 @@ func (p *PeerID) SetString(id string) {
 
 	copy(p[:], id)
 }
+
+// ParsePeerID returns the PeerID from its hexadecimal string representation
+func ParsePeerID(s string) (PeerID, error) {
+	var p PeerID
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(p) {
+		return p, ErrInvalidPeerID
+	}
+
+	copy(p[:], b)
+
+	return p, nil
+}
+
+// HexString returns the hexadecimal string representation of PeerID
+func (p PeerID) HexString() string {
+	return hex.EncodeToString(p[:])
+}
+
+// EncodePeerAddrs returns the compact representation of a list of PeerAddr
+// It is the number of IPv4 addresses as a big endian uint16 followed by their compact form, then the same for IPv6 addresses.
+func EncodePeerAddrs(addrs []PeerAddr) []byte {
+	var peers4, peers6 []byte
+	var count4, count6 uint16
+
+	for _, p := range addrs {
+		b, _ := p.Bytes()
+
+		if p.IsIPv4() {
+			peers4 = append(peers4, b...)
+			count4++
+		} else {
+			peers6 = append(peers6, b...)
+			count6++
+		}
+	}
+
+	out := make([]byte, 0, 4+len(peers4)+len(peers6))
+	out = append(out, byte(count4>>8), byte(count4))
+	out = append(out, peers4...)
+	out = append(out, byte(count6>>8), byte(count6))
+	out = append(out, peers6...)
+
+	return out
+}
+
+// DecodePeerAddrs reads a list of PeerAddr from the beginning of b and returns it along the remaining bytes
+func DecodePeerAddrs(b []byte) ([]PeerAddr, []byte, error) {
+	var addrs []PeerAddr
+
+	for _, size := range []int{CompactIPv4Size, CompactIPv6Size} {
+		if len(b) < 2 {
+			return nil, nil, ErrInvalidPeerAddr
+		}
+
+		count := int(binary.BigEndian.Uint16(b))
+		b = b[2:]
+
+		if len(b) < count*size {
+			return nil, nil, ErrInvalidPeerAddr
+		}
+
+		for i := 0; i < count; i++ {
+			addr, err := ParsePeerAddr(b[i*size : (i+1)*size])
+			if err != nil {
+				return nil, nil, err
+			}
+			addrs = append(addrs, addr)
+		}
+
+		b = b[count*size:]
+	}
+
+	return addrs, b, nil
+}
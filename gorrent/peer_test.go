@@ -0,0 +1,100 @@
+package gorrent
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPeerAddr(t *testing.T) {
+	t.Run("IPv4 addresses use the 6 bytes compact form", func(t *testing.T) {
+		addr := NewPeerAddr(net.ParseIP("127.0.0.1"), 4444)
+
+		if !addr.IsIPv4() {
+			t.Fatalf("Expected address to be IPv4")
+		}
+
+		if addr.String() != "127.0.0.1:4444" {
+			t.Fatalf("Expected string to be 127.0.0.1:4444, got %s", addr.String())
+		}
+
+		b, err := addr.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []byte{127, 0, 0, 1, 0x11, 0x5c}
+		if reflect.DeepEqual(b, expected) == false {
+			t.Fatalf("Expected bytes to be %v, got %v", expected, b)
+		}
+
+		parsed, err := ParsePeerAddr(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed != addr {
+			t.Fatalf("Expected parsed address to be %s, got %s", addr, parsed)
+		}
+	})
+
+	t.Run("IPv6 addresses use the 18 bytes compact form", func(t *testing.T) {
+		addr := NewPeerAddr(net.ParseIP("2001:db8::1"), 4444)
+
+		if addr.IsIPv4() {
+			t.Fatalf("Expected address to be IPv6")
+		}
+
+		if addr.String() != "[2001:db8::1]:4444" {
+			t.Fatalf("Expected string to be [2001:db8::1]:4444, got %s", addr.String())
+		}
+
+		b, err := addr.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b) != CompactIPv6Size {
+			t.Fatalf("Expected bytes len to be %d, got %d", CompactIPv6Size, len(b))
+		}
+
+		parsed, err := ParsePeerAddr(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed != addr {
+			t.Fatalf("Expected parsed address to be %s, got %s", addr, parsed)
+		}
+	})
+
+	t.Run("ParsePeerAddr fails on invalid length", func(t *testing.T) {
+		if _, err := ParsePeerAddr([]byte{1, 2, 3}); err != ErrInvalidPeerAddr {
+			t.Fatalf("Expected err to be %s, got %v", ErrInvalidPeerAddr, err)
+		}
+	})
+}
+
+func TestPeerID(t *testing.T) {
+	t.Run("ParsePeerID parses the HexString representation", func(t *testing.T) {
+		var id PeerID
+		id.SetString("peer")
+
+		parsed, err := ParsePeerID(id.HexString())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed != id {
+			t.Fatalf("Expected peer ID to be %v, got %v", id, parsed)
+		}
+	})
+
+	t.Run("ParsePeerID rejects invalid strings", func(t *testing.T) {
+		for _, s := range []string{"zz", "abcd"} {
+			if _, err := ParsePeerID(s); err != ErrInvalidPeerID {
+				t.Fatalf("Expected err to be %s for %s, got %v", ErrInvalidPeerID, s, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package gorrent
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	// SecretSize defines the number of bytes of generated swarm secrets
+	SecretSize = 32
+)
+
+// Proof holds a proof of knowledge of a swarm secret
+type Proof [sha256.Size]byte
+
+// NewSecret generates a new random swarm secret
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// IsPrivate returns true when the gorrent swarm is protected by a secret
+func (g *Gorrent) IsPrivate() bool {
+	return len(g.Secret) > 0
+}
+
+// SecretHexString returns the hexadecimal string representation of the gorrent swarm secret
+func (g *Gorrent) SecretHexString() string {
+	return hex.EncodeToString(g.Secret)
+}
+
+// NewProof computes the proof of knowledge of secret for infoHash, over the given challenge
+func NewProof(secret []byte, infoHash Sha1Hash, challenge ...[]byte) Proof {
+	h := hmac.New(sha256.New, secret)
+	h.Write(infoHash[:])
+	for _, c := range challenge {
+		h.Write(c)
+	}
+
+	var p Proof
+	copy(p[:], h.Sum(nil))
+
+	return p
+}
+
+// VerifyProof checks the proof has been computed from secret, infoHash and challenge
+func VerifyProof(proof Proof, secret []byte, infoHash Sha1Hash, challenge ...[]byte) bool {
+	expected := NewProof(secret, infoHash, challenge...)
+
+	return hmac.Equal(proof[:], expected[:])
+}
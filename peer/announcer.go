@@ -1,37 +1,91 @@
 package peer
 
 import (
+	"errors"
 	"log"
+	"math/rand"
+	"sync"
 	"time"
 
+	"github.com/daeMOn63/gorrent/gorrent"
 	"github.com/daeMOn63/gorrent/tracker"
 	"github.com/daeMOn63/gorrent/tracker/actions"
 )
 
+const (
+	// MaxTrackerBackoff defines the maximum delay before retrying a failing tracker
+	MaxTrackerBackoff = 30 * time.Minute
+	// AnnounceJitter defines the maximum fraction of the interval by which announces are advanced,
+	// so announces of peers started together spread over time
+	AnnounceJitter = 0.1
+
+	// announceCheckInterval defines how often the announcer looks for due announces
+	announceCheckInterval = time.Second
+)
+
+var (
+	// ErrNoTrackerResponded is returned when none of the gorrent trackers responded to the announce
+	ErrNoTrackerResponded = errors.New("no tracker responded")
+)
+
 // Announcer interface
 type Announcer interface {
 	AnnounceForever() error
+	Stop() error
+}
+
+// announceState holds what has been announced for a gorrent
+type announceState struct {
+	next      time.Time
+	status    Status
+	started   bool
+	completed bool
+}
+
+// trackerBackoff holds the failures of a tracker, which is skipped until retryAt
+type trackerBackoff struct {
+	failures int
+	retryAt  time.Time
 }
 
 type announcer struct {
 	store    GorrentStore
 	tracker  tracker.Client
 	interval time.Duration
+	maxAge   time.Duration
+
+	mutex    sync.Mutex
+	stopped  bool
+	backoffs map[string]*trackerBackoff
+	tiers    map[gorrent.Sha1Hash][][]string
+	states   map[gorrent.Sha1Hash]*announceState
 }
 
 var _ Announcer = &announcer{}
 
 // NewAnnouncer creates a new Announcer
-func NewAnnouncer(store GorrentStore, tracker tracker.Client, interval time.Duration) Announcer {
+// Each gorrent is announced according to the interval returned by its trackers, interval being used
+// when they do not return any, or when none of them responded.
+// Announced peers are merged with the ones learnt from peer exchange, and expire after maxAge.
+func NewAnnouncer(store GorrentStore, tracker tracker.Client, interval time.Duration, maxAge time.Duration) Announcer {
 	return &announcer{
 		store:    store,
 		tracker:  tracker,
 		interval: interval,
+		maxAge:   maxAge,
+		backoffs: make(map[string]*trackerBackoff),
+		tiers:    make(map[gorrent.Sha1Hash][][]string),
+		states:   make(map[gorrent.Sha1Hash]*announceState),
 	}
 }
 
 func (a *announcer) AnnounceForever() error {
-	ticker := time.NewTicker(a.interval)
+	checkInterval := announceCheckInterval
+	if a.interval < checkInterval {
+		checkInterval = a.interval
+	}
+
+	ticker := time.NewTicker(checkInterval)
 	log.Printf("Starting announcer")
 	for range ticker.C {
 		err := a.Announce()
@@ -44,6 +98,13 @@ func (a *announcer) AnnounceForever() error {
 }
 
 func (a *announcer) Announce() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.stopped {
+		return nil
+	}
+
 	entries, err := a.store.All()
 	if err != nil {
 		return err
@@ -55,21 +116,199 @@ func (a *announcer) Announce() error {
 			continue
 		}
 
-		log.Printf("Announcing %s (%s)", entry.Name, entry.Gorrent.InfoHash().HexString())
-		peers, err := a.tracker.Announce(entry.Gorrent, actions.AnnounceEventStarted, actions.AnnounceStatus{
-			Downloaded: 0,
-			Uploaded:   0,
-		})
+		if len(entry.Gorrent.Trackers()) == 0 {
+			continue
+		}
+
+		infoHash := entry.Gorrent.InfoHash()
+		now := time.Now()
+
+		state, ok := a.states[infoHash]
+		if !ok {
+			state = &announceState{status: entry.Status}
+			a.states[infoHash] = state
+		}
+
+		// Completion is announced right away, without waiting for the next scheduled announce
+		if entry.Status != state.status {
+			if entry.Status == StatusCompleted {
+				state.next = now
+			}
+			state.status = entry.Status
+		}
+
+		if now.Before(state.next) {
+			continue
+		}
+
+		event := actions.AnnounceEventNone
+		if !state.started {
+			event = actions.AnnounceEventStarted
+		} else if entry.Status == StatusCompleted && !state.completed {
+			event = actions.AnnounceEventCompleted
+		}
+
+		interval, minInterval, err := a.announce(entry, event)
 		if err != nil {
-			return err
+			log.Printf("Announce error for %s (%s): %s", entry.Name, infoHash.HexString(), err)
+			state.next = now.Add(a.interval)
+
+			continue
+		}
+
+		state.started = true
+		state.completed = entry.Status == StatusCompleted
+		state.next = now.Add(nextAnnounceDelay(interval, minInterval))
+	}
+
+	return nil
+}
+
+// Stop announces the stopped event for all the started gorrents, and stops any further announce
+func (a *announcer) Stop() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.stopped = true
+
+	entries, err := a.store.All()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		state, ok := a.states[entry.Gorrent.InfoHash()]
+		if !ok || !state.started {
+			continue
 		}
 
-		entry.PeerAddrs = peers
-		if err := a.store.Save(entry); err != nil {
-			return err
+		if _, _, err := a.announce(entry, actions.AnnounceEventStopped); err != nil {
+			log.Printf("Stop announce error for %s (%s): %s", entry.Name, entry.Gorrent.InfoHash().HexString(), err)
 		}
-		log.Printf("Got %d peers: %s for %s (%s)", len(peers), peers, entry.Name, entry.Gorrent.InfoHash().HexString())
+		state.started = false
 	}
 
 	return nil
 }
+
+// nextAnnounceDelay returns the interval, randomly advanced by up to AnnounceJitter, but never below minInterval
+func nextAnnounceDelay(interval time.Duration, minInterval time.Duration) time.Duration {
+	delay := interval - time.Duration(rand.Float64()*AnnounceJitter*float64(interval))
+	if delay < minInterval {
+		delay = minInterval
+	}
+
+	return delay
+}
+
+// announce reports the entry to one tracker of each tier, and merges the peers returned by all of them.
+// Within a tier, trackers are tried in turn, skipping the ones in backoff, and the responding one is moved first.
+// It returns the shortest interval and the longest min interval of the responding trackers, so the gorrent
+// is announced often enough for all of them, without announcing too early for any.
+func (a *announcer) announce(entry *GorrentEntry, event actions.AnnounceEvent) (time.Duration, time.Duration, error) {
+	infoHash := entry.Gorrent.InfoHash()
+	now := time.Now()
+
+	log.Printf("Announcing %s (%s): %s", entry.Name, infoHash.HexString(), event.Name())
+
+	status := actions.AnnounceStatus{
+		Downloaded: entry.Downloaded,
+		Uploaded:   entry.Uploaded,
+		Left:       entry.Left(),
+	}
+
+	var peers []gorrent.PeerAddr
+	var interval, minInterval time.Duration
+	responded := false
+
+	for _, tier := range a.tiersOf(entry.Gorrent) {
+		for i, addr := range tier {
+			if b, ok := a.backoffs[addr]; ok && now.Before(b.retryAt) {
+				continue
+			}
+
+			response, err := a.tracker.Announce(addr, entry.Gorrent, event, status)
+			if err != nil {
+				a.fail(addr, now)
+				log.Printf("Tracker %s failed for %s: %s", addr, infoHash.HexString(), err)
+
+				continue
+			}
+
+			delete(a.backoffs, addr)
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = addr
+
+			peers = append(peers, response.Peers...)
+			responded = true
+
+			if response.Interval > 0 && (interval == 0 || response.Interval < interval) {
+				interval = response.Interval
+			}
+			if response.MinInterval > minInterval {
+				minInterval = response.MinInterval
+			}
+
+			break
+		}
+	}
+
+	if !responded {
+		return 0, 0, ErrNoTrackerResponded
+	}
+
+	if interval == 0 {
+		interval = a.interval
+	}
+
+	err := a.store.Update(infoHash, func(g *GorrentEntry) error {
+		g.MergePeerAddrs(peers, now)
+		g.ExpirePeerAddrs(a.maxAge, now)
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	log.Printf("Got %d peers: %s for %s (%s), announce interval %s", len(peers), peers, entry.Name, infoHash.HexString(), interval)
+
+	return interval, minInterval, nil
+}
+
+// tiersOf returns the tiers of trackers of the gorrent, in their preferred order.
+// Trackers of each tier are shuffled on first use, to spread the load among them.
+func (a *announcer) tiersOf(g *gorrent.Gorrent) [][]string {
+	infoHash := g.InfoHash()
+	if tiers, ok := a.tiers[infoHash]; ok {
+		return tiers
+	}
+
+	var tiers [][]string
+	for _, tier := range g.Trackers() {
+		shuffled := append([]string(nil), tier...)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		tiers = append(tiers, shuffled)
+	}
+	a.tiers[infoHash] = tiers
+
+	return tiers
+}
+
+// fail records a tracker failure, doubling its backoff from the announce interval up to MaxTrackerBackoff
+func (a *announcer) fail(addr string, now time.Time) {
+	b, ok := a.backoffs[addr]
+	if !ok {
+		b = &trackerBackoff{}
+		a.backoffs[addr] = b
+	}
+
+	delay := a.interval << uint(b.failures)
+	if delay > MaxTrackerBackoff || delay <= 0 {
+		delay = MaxTrackerBackoff
+	}
+
+	b.failures++
+	b.retryAt = now.Add(delay)
+}
@@ -0,0 +1,202 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+func TestAnnouncer(t *testing.T) {
+	peer1 := gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1)
+	peer2 := gorrent.NewPeerAddr(net.ParseIP("10.0.0.2"), 2)
+
+	newAnnouncer := func(t *testing.T, g *gorrent.Gorrent, responses map[string]*actions.AnnounceResponse, calls *[]string) (*announcer, GorrentStore) {
+		store := newTestStore(t, &GorrentEntry{Name: "test", Gorrent: g, Status: StatusDownloading})
+
+		client := &tracker.DummyClient{
+			AnnounceFunc: func(addr string, g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) (*actions.AnnounceResponse, error) {
+				*calls = append(*calls, addr)
+
+				response, ok := responses[addr]
+				if !ok {
+					return nil, errors.New("unreachable")
+				}
+
+				return response, nil
+			},
+		}
+
+		return NewAnnouncer(store, client, time.Minute, time.Hour).(*announcer), store
+	}
+
+	t.Run("Announce fails over to the next tracker of the tier and merges peers from all tiers", func(t *testing.T) {
+		g := &gorrent.Gorrent{AnnounceList: [][]string{{"down", "up"}, {"backup"}}}
+
+		var calls []string
+		a, store := newAnnouncer(t, g, map[string]*actions.AnnounceResponse{
+			"up":     {Peers: []gorrent.PeerAddr{peer1}},
+			"backup": {Peers: []gorrent.PeerAddr{peer2}},
+		}, &calls)
+
+		// Force the tier order, to make sure the failing tracker is tried first
+		a.tiers[g.InfoHash()] = [][]string{{"down", "up"}, {"backup"}}
+
+		if err := a.Announce(); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		entry, err := store.Get(g.InfoHash())
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if len(entry.PeerAddrs) != 2 || entry.PeerAddrs[0] != peer1 || entry.PeerAddrs[1] != peer2 {
+			t.Fatalf("Expected peers to be %v, got %v", []gorrent.PeerAddr{peer1, peer2}, entry.PeerAddrs)
+		}
+
+		if a.tiers[g.InfoHash()][0][0] != "up" {
+			t.Fatalf("Expected responding tracker to be moved first, got %v", a.tiers[g.InfoHash()][0])
+		}
+
+		// The failing tracker is now in backoff, and the responding one is tried first
+		calls = nil
+		a.states[g.InfoHash()].next = time.Now()
+		if err := a.Announce(); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		expected := []string{"up", "backup"}
+		if len(calls) != len(expected) || calls[0] != expected[0] || calls[1] != expected[1] {
+			t.Fatalf("Expected trackers %v to be called, got %v", expected, calls)
+		}
+	})
+
+	t.Run("Failing trackers are skipped until their backoff expires", func(t *testing.T) {
+		g := &gorrent.Gorrent{Announce: "down"}
+
+		var calls []string
+		a, _ := newAnnouncer(t, g, nil, &calls)
+
+		entry := &GorrentEntry{Gorrent: g}
+		if _, _, err := a.announce(entry, actions.AnnounceEventNone); err != ErrNoTrackerResponded {
+			t.Fatalf("Expected err to be %s, got %v", ErrNoTrackerResponded, err)
+		}
+
+		if _, _, err := a.announce(entry, actions.AnnounceEventNone); err != ErrNoTrackerResponded {
+			t.Fatalf("Expected err to be %s, got %v", ErrNoTrackerResponded, err)
+		}
+
+		if len(calls) != 1 {
+			t.Fatalf("Expected tracker to be called once, got %d", len(calls))
+		}
+
+		if a.backoffs["down"].retryAt.Sub(time.Now()) > time.Minute {
+			t.Fatalf("Expected first backoff to be the announce interval")
+		}
+
+		a.backoffs["down"].retryAt = time.Now()
+		a.announce(entry, actions.AnnounceEventNone)
+
+		if len(calls) != 2 || a.backoffs["down"].failures != 2 {
+			t.Fatalf("Expected tracker to be retried after its backoff")
+		}
+	})
+
+	t.Run("Announce schedules the next announce from the trackers intervals", func(t *testing.T) {
+		g := &gorrent.Gorrent{AnnounceList: [][]string{{"fast"}, {"slow"}}}
+
+		var calls []string
+		a, _ := newAnnouncer(t, g, map[string]*actions.AnnounceResponse{
+			"fast": {Interval: 10 * time.Minute, MinInterval: time.Minute},
+			"slow": {Interval: 20 * time.Minute, MinInterval: 2 * time.Minute},
+		}, &calls)
+
+		start := time.Now()
+		if err := a.Announce(); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		next := a.states[g.InfoHash()].next.Sub(start)
+		if next > 10*time.Minute || next < 9*time.Minute {
+			t.Fatalf("Expected next announce in 9 to 10 minutes, got %s", next)
+		}
+
+		if err := a.Announce(); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if len(calls) != 2 {
+			t.Fatalf("Expected no announce before the interval, got %v", calls)
+		}
+	})
+
+	t.Run("nextAnnounceDelay never goes below the min interval", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			if d := nextAnnounceDelay(time.Minute, time.Minute); d != time.Minute {
+				t.Fatalf("Expected delay to be %s, got %s", time.Minute, d)
+			}
+		}
+	})
+	t.Run("Announce sends lifecycle events with the transfer statistics", func(t *testing.T) {
+		g := &gorrent.Gorrent{Announce: "tracker", Files: []gorrent.File{{Length: 100}}}
+		store := newTestStore(t, &GorrentEntry{Name: "test", Gorrent: g, Status: StatusDownloading, Downloaded: 40, Uploaded: 10})
+
+		var events []actions.AnnounceEvent
+		var statuses []actions.AnnounceStatus
+		client := &tracker.DummyClient{
+			AnnounceFunc: func(addr string, g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) (*actions.AnnounceResponse, error) {
+				events = append(events, evt)
+				statuses = append(statuses, status)
+
+				return &actions.AnnounceResponse{Interval: time.Hour}, nil
+			},
+		}
+
+		a := NewAnnouncer(store, client, time.Minute, time.Hour).(*announcer)
+
+		a.Announce()
+		a.Announce()
+
+		// Completion is announced without waiting for the interval
+		store.Update(g.InfoHash(), func(e *GorrentEntry) error {
+			e.Status = StatusCompleted
+			e.Downloaded = 100
+
+			return nil
+		})
+		a.Announce()
+
+		a.states[g.InfoHash()].next = time.Now()
+		a.Announce()
+
+		if err := a.Stop(); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		a.Announce()
+
+		expected := []actions.AnnounceEvent{
+			actions.AnnounceEventStarted,
+			actions.AnnounceEventCompleted,
+			actions.AnnounceEventNone,
+			actions.AnnounceEventStopped,
+		}
+		if reflect.DeepEqual(events, expected) == false {
+			t.Fatalf("Expected events to be %v, got %v", expected, events)
+		}
+
+		expectedStatus := actions.AnnounceStatus{Downloaded: 40, Uploaded: 10, Left: 60}
+		if statuses[0] != expectedStatus {
+			t.Fatalf("Expected status to be %#v, got %#v", expectedStatus, statuses[0])
+		}
+
+		if statuses[1].Left != 0 {
+			t.Fatalf("Expected nothing left once completed, got %d", statuses[1].Left)
+		}
+	})
+}
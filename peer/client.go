@@ -1,63 +1,213 @@
 package peer
 
 import (
-	"bytes"
-	"encoding/binary"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/daeMOn63/gorrent/gorrent"
 )
 
+const (
+	// DefaultMinQueueDepth is the default minimum number of outstanding requests per peer
+	DefaultMinQueueDepth = 4
+	// DefaultMaxQueueDepth is the default maximum number of outstanding requests per peer
+	DefaultMaxQueueDepth = 256
+	// DefaultReadTimeout is the default maximum time to wait for a peer message
+	DefaultReadTimeout = 2 * time.Second
+)
+
 // Client interface defines a peer Client
 type Client interface {
-	GetPiece(peerAddr gorrent.PeerAddr, chunkRequest *ChunkRequest, chunkSize int) ([]byte, error)
+	GetBlock(peerAddr gorrent.PeerAddr, chunkRequest *ChunkRequest) ([]byte, error)
+	SendPeers(peerAddr gorrent.PeerAddr, infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr) error
+	Close() error
+}
+
+// PeersHandler is called when a remote peer shares its known peers of a gorrent
+type PeersHandler func(infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr)
+
+// ClientConfig defines the peer client options
+type ClientConfig struct {
+	ReadTimeout   time.Duration
+	MinQueueDepth int
+	MaxQueueDepth int
+	Security      Security
+	Secrets       SwarmSecrets
+	OnPeers       PeersHandler
 }
 
 type client struct {
-	readTimeout time.Duration
+	cfg ClientConfig
+
+	mutex    sync.Mutex
+	sessions map[gorrent.PeerAddr]*session
+	dials    map[gorrent.PeerAddr]*dial
+	closed   bool
+}
+
+// dial is a session being opened to a peer, shared by all the callers waiting for it
+type dial struct {
+	done    chan struct{}
+	session *session
+	err     error
 }
 
 var _ Client = &client{}
 
 // NewClient creates a new peer Client
-func NewClient(readTimeout time.Duration) Client {
+func NewClient(cfg ClientConfig) Client {
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = DefaultReadTimeout
+	}
+
+	if cfg.MinQueueDepth <= 0 {
+		cfg.MinQueueDepth = DefaultMinQueueDepth
+	}
+
+	if cfg.MaxQueueDepth <= 0 {
+		cfg.MaxQueueDepth = DefaultMaxQueueDepth
+	}
+
+	if cfg.MaxQueueDepth < cfg.MinQueueDepth {
+		cfg.MaxQueueDepth = cfg.MinQueueDepth
+	}
+
+	if cfg.Security == nil {
+		cfg.Security = NewPlainSecurity()
+	}
+
+	if cfg.Secrets == nil {
+		cfg.Secrets = &noSwarmSecrets{}
+	}
+
 	return &client{
-		readTimeout: readTimeout,
+		cfg:      cfg,
+		sessions: make(map[gorrent.PeerAddr]*session),
+		dials:    make(map[gorrent.PeerAddr]*dial),
 	}
 }
 
-// GetPiece fetch a gorrent piece from given peer or return an error on failure
-func (c *client) GetPiece(peerAddr gorrent.PeerAddr, chunkRequest *ChunkRequest, chunkSize int) ([]byte, error) {
-	conn, err := net.Dial("udp", peerAddr.String())
+// GetBlock fetch a block of a gorrent piece from given peer or return an error on failure
+// Requests to the same peer are pipelined over a persistent session.
+func (c *client) GetBlock(peerAddr gorrent.PeerAddr, chunkRequest *ChunkRequest) ([]byte, error) {
+	s, err := c.session(peerAddr)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
-	buf := bytes.NewBuffer(nil)
-	if err := binary.Write(buf, binary.BigEndian, chunkRequest); err != nil {
+	return s.request(chunkRequest)
+}
+
+// SendPeers shares addrs with the given peer, as known peers of the gorrent.
+// The remote peer answers with its own known peers, handed to the configured PeersHandler.
+func (c *client) SendPeers(peerAddr gorrent.PeerAddr, infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr) error {
+	s, err := c.session(peerAddr)
+	if err != nil {
+		return err
+	}
+
+	return s.sendPeers(infoHash, addrs)
+}
+
+// Close terminates all the opened sessions
+func (c *client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	for addr, s := range c.sessions {
+		s.close(ErrSessionClosed)
+		delete(c.sessions, addr)
+	}
+
+	return nil
+}
+
+// session returns the opened session to given peer, or dial a new one.
+// Dialing and securing the connection happen outside the lock, so a slow peer
+// does not hold back sessions to the others, while concurrent callers for the
+// same peer wait for the pending dial instead of opening their own.
+func (c *client) session(peerAddr gorrent.PeerAddr) (*session, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+
+		return nil, ErrSessionClosed
+	}
+
+	if s, ok := c.sessions[peerAddr]; ok && !s.isClosed() {
+		c.mutex.Unlock()
+
+		return s, nil
+	}
+
+	if d, ok := c.dials[peerAddr]; ok {
+		c.mutex.Unlock()
+		<-d.done
+
+		return d.session, d.err
+	}
+
+	d := &dial{done: make(chan struct{})}
+	c.dials[peerAddr] = d
+	c.mutex.Unlock()
+
+	d.session, d.err = c.dial(peerAddr)
+
+	c.mutex.Lock()
+	delete(c.dials, peerAddr)
+	if d.err == nil {
+		if c.closed {
+			d.session.close(ErrSessionClosed)
+			d.session, d.err = nil, ErrSessionClosed
+		} else {
+			c.sessions[peerAddr] = d.session
+		}
+	}
+	c.mutex.Unlock()
+	close(d.done)
+
+	return d.session, d.err
+}
+
+// dial opens and secures a new session to given peer
+func (c *client) dial(peerAddr gorrent.PeerAddr) (*session, error) {
+	rawConn, err := net.DialTimeout("tcp", peerAddr.String(), c.cfg.ReadTimeout)
+	if err != nil {
 		return nil, err
 	}
 
-	_, err = conn.Write(buf.Bytes())
+	conn, err := c.cfg.Security.Client(rawConn)
 	if err != nil {
+		rawConn.Close()
+
 		return nil, err
 	}
 
-	resp := make([]byte, 0, chunkSize)
+	return newSession(peerAddr, conn, c.cfg), nil
+}
+
+// DummyClient provides a configurable Client
+type DummyClient struct {
+	GetBlockFunc  func(peerAddr gorrent.PeerAddr, chunkRequest *ChunkRequest) ([]byte, error)
+	SendPeersFunc func(peerAddr gorrent.PeerAddr, infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr) error
+	CloseFunc     func() error
+}
 
-	for len(resp) < cap(resp) {
-		data := make([]byte, MaxUDPPacketSize)
+var _ Client = &DummyClient{}
 
-		deadLine := time.Now().Add(c.readTimeout)
-		conn.SetReadDeadline(deadLine)
-		n, err := conn.Read(data)
-		if err != nil {
-			return nil, err
-		}
-		resp = append(resp, data[:n]...)
-	}
+// GetBlock calls GetBlockFunc
+func (d *DummyClient) GetBlock(peerAddr gorrent.PeerAddr, chunkRequest *ChunkRequest) ([]byte, error) {
+	return d.GetBlockFunc(peerAddr, chunkRequest)
+}
+
+// SendPeers calls SendPeersFunc
+func (d *DummyClient) SendPeers(peerAddr gorrent.PeerAddr, infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr) error {
+	return d.SendPeersFunc(peerAddr, infoHash, addrs)
+}
 
-	return resp, nil
+// Close calls CloseFunc
+func (d *DummyClient) Close() error {
+	return d.CloseFunc()
 }
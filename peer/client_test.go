@@ -0,0 +1,389 @@
+package peer
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestWireMessage(t *testing.T) {
+	t.Run("ReadMessage reads what WriteMessage wrote", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+		if err := WriteMessage(buf, MessageBlock, []byte("abcd")); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		msg, err := ReadMessage(buf)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if msg.ID != MessageBlock || string(msg.Payload) != "abcd" {
+			t.Fatalf("Expected block message with abcd payload, got %#v", msg)
+		}
+	})
+
+	t.Run("ReadMessage fails on too large messages", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte{0xff, 0xff, 0xff, 0xff, 0x1})
+		if _, err := ReadMessage(buf); err != ErrMessageTooLarge {
+			t.Fatalf("Expected err to be %s, got %v", ErrMessageTooLarge, err)
+		}
+	})
+}
+
+func TestClientPipelining(t *testing.T) {
+	t.Run("GetBlock pipelines requests over a single connection", func(t *testing.T) {
+		const depth = 4
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		var connCount int32
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				atomic.AddInt32(&connCount, 1)
+
+				go func(conn net.Conn) {
+					defer conn.Close()
+					// Only answer once depth requests are outstanding, in reverse order
+					var requests []*ChunkRequest
+					for {
+						msg, err := ReadMessage(conn)
+						if err != nil {
+							return
+						}
+
+						r, _, err := DecodeChunkRequest(msg.Payload)
+						if err != nil {
+							return
+						}
+						requests = append(requests, r)
+
+						if len(requests) == depth {
+							for i := len(requests) - 1; i >= 0; i-- {
+								payload := append(EncodeChunkRequest(requests[i]), bytes.Repeat([]byte{byte(requests[i].Offset)}, int(requests[i].Length))...)
+								WriteMessage(conn, MessageBlock, payload)
+							}
+							requests = nil
+						}
+					}
+				}(conn)
+			}
+		}()
+
+		tcpAddr := listener.Addr().(*net.TCPAddr)
+		peerAddr := gorrent.NewPeer("test", tcpAddr.IP, uint16(tcpAddr.Port)).PeerAddr
+
+		c := NewClient(ClientConfig{
+			ReadTimeout:   time.Second,
+			MinQueueDepth: depth,
+			MaxQueueDepth: depth,
+		})
+		defer c.Close()
+
+		requests := NewBlockRequests(gorrent.RandomSha1Hash(), 0, depth*4, 4)
+
+		var wg sync.WaitGroup
+		for _, r := range requests {
+			wg.Add(1)
+			go func(r *ChunkRequest) {
+				defer wg.Done()
+
+				data, err := c.GetBlock(peerAddr, r)
+				if err != nil {
+					t.Errorf("Expected no error, got %s", err)
+
+					return
+				}
+
+				expected := bytes.Repeat([]byte{byte(r.Offset)}, int(r.Length))
+				if !bytes.Equal(data, expected) {
+					t.Errorf("Expected data to be %v, got %v", expected, data)
+				}
+			}(r)
+		}
+		wg.Wait()
+
+		if connCount := atomic.LoadInt32(&connCount); connCount != 1 {
+			t.Fatalf("Expected a single connection, got %d", connCount)
+		}
+	})
+
+	t.Run("GetBlock returns ErrBlockRejected when the peer rejects the request", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			msg, err := ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			WriteMessage(conn, MessageReject, msg.Payload)
+			ReadMessage(conn)
+		}()
+
+		tcpAddr := listener.Addr().(*net.TCPAddr)
+		peerAddr := gorrent.NewPeer("test", tcpAddr.IP, uint16(tcpAddr.Port)).PeerAddr
+
+		c := NewClient(ClientConfig{ReadTimeout: time.Second})
+		defer c.Close()
+
+		_, err = c.GetBlock(peerAddr, &ChunkRequest{Length: 4})
+		if err != ErrBlockRejected {
+			t.Fatalf("Expected err to be %s, got %v", ErrBlockRejected, err)
+		}
+	})
+	t.Run("GetBlock proves the swarm secret knowledge before requesting private gorrents", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		secret := []byte("secret")
+		challenge := bytes.Repeat([]byte{0x42}, ChallengeSize)
+
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			WriteMessage(conn, MessageChallenge, challenge)
+
+			msg, err := ReadMessage(conn)
+			if err != nil || msg.ID != MessageAuth {
+				return
+			}
+
+			auth, err := DecodeAuth(msg.Payload)
+			if err != nil || !gorrent.VerifyProof(auth.Proof, secret, auth.InfoHash, challenge) {
+				return
+			}
+
+			msg, err = ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			WriteMessage(conn, MessageBlock, append(msg.Payload, []byte("data")...))
+			ReadMessage(conn)
+		}()
+
+		tcpAddr := listener.Addr().(*net.TCPAddr)
+		peerAddr := gorrent.NewPeer("test", tcpAddr.IP, uint16(tcpAddr.Port)).PeerAddr
+
+		c := NewClient(ClientConfig{
+			ReadTimeout: time.Second,
+			Secrets: &dummySwarmSecrets{
+				secret: secret,
+			},
+		})
+		defer c.Close()
+
+		data, err := c.GetBlock(peerAddr, &ChunkRequest{Length: 4})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if string(data) != "data" {
+			t.Fatalf("Expected data to be %v, got %v", []byte("data"), data)
+		}
+	})
+}
+
+type dummySwarmSecrets struct {
+	secret []byte
+}
+
+func (d *dummySwarmSecrets) Secret(infoHash gorrent.Sha1Hash) ([]byte, error) {
+	return d.secret, nil
+}
+
+func TestClientSendPeers(t *testing.T) {
+	t.Run("SendPeers shares the peers and hands the answered ones to OnPeers", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		remotePeers := []gorrent.PeerAddr{
+			gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1234),
+			gorrent.NewPeerAddr(net.ParseIP("2001:db8::1"), 1234),
+		}
+
+		received := make(chan *Pex, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			WriteMessage(conn, MessageChallenge, bytes.Repeat([]byte{0x42}, ChallengeSize))
+
+			msg, err := ReadMessage(conn)
+			if err != nil || msg.ID != MessagePex {
+				return
+			}
+
+			pex, err := DecodePex(msg.Payload)
+			if err != nil {
+				return
+			}
+			received <- pex
+
+			WriteMessage(conn, MessagePex, EncodePex(&Pex{InfoHash: pex.InfoHash, PeerAddrs: remotePeers}))
+			ReadMessage(conn)
+		}()
+
+		tcpAddr := listener.Addr().(*net.TCPAddr)
+		peerAddr := gorrent.NewPeer("test", tcpAddr.IP, uint16(tcpAddr.Port)).PeerAddr
+
+		answered := make(chan []gorrent.PeerAddr, 1)
+		c := NewClient(ClientConfig{
+			ReadTimeout: time.Second,
+			OnPeers: func(infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr) {
+				answered <- addrs
+			},
+		})
+		defer c.Close()
+
+		infoHash := gorrent.Sha1Hash{0x1}
+		localPeers := []gorrent.PeerAddr{gorrent.NewPeerAddr(net.ParseIP("10.0.0.2"), 4321)}
+
+		if err := c.SendPeers(peerAddr, infoHash, localPeers); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		select {
+		case pex := <-received:
+			if pex.InfoHash != infoHash || len(pex.PeerAddrs) != 1 || pex.PeerAddrs[0] != localPeers[0] {
+				t.Fatalf("Expected server to receive %v, got %v", localPeers, pex.PeerAddrs)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected server to receive the peers")
+		}
+
+		select {
+		case addrs := <-answered:
+			if len(addrs) != len(remotePeers) || addrs[0] != remotePeers[0] || addrs[1] != remotePeers[1] {
+				t.Fatalf("Expected peers to be %v, got %v", remotePeers, addrs)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected OnPeers to be called")
+		}
+	})
+}
+
+type blockingSecurity struct {
+	slowPort  int
+	release   chan struct{}
+	slowDials int32
+}
+
+func (b *blockingSecurity) Client(conn net.Conn) (net.Conn, error) {
+	if conn.RemoteAddr().(*net.TCPAddr).Port == b.slowPort {
+		atomic.AddInt32(&b.slowDials, 1)
+		<-b.release
+	}
+
+	return conn, nil
+}
+
+func (b *blockingSecurity) Server(conn net.Conn) (net.Conn, error) {
+	return conn, nil
+}
+
+func TestClientSession(t *testing.T) {
+	t.Run("session does not wait for a slow handshake to another peer, nor dial a peer twice", func(t *testing.T) {
+		var addrs []gorrent.PeerAddr
+		var ports []int
+		for i := 0; i < 2; i++ {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+				}
+			}()
+
+			tcpAddr := listener.Addr().(*net.TCPAddr)
+			addrs = append(addrs, gorrent.NewPeer("test", tcpAddr.IP, uint16(tcpAddr.Port)).PeerAddr)
+			ports = append(ports, tcpAddr.Port)
+		}
+
+		security := &blockingSecurity{slowPort: ports[0], release: make(chan struct{})}
+		c := NewClient(ClientConfig{ReadTimeout: time.Second, Security: security}).(*client)
+		defer c.Close()
+
+		slow := make(chan *session, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				s, err := c.session(addrs[0])
+				if err != nil {
+					t.Errorf("Expected no error, got %s", err)
+				}
+				slow <- s
+			}()
+		}
+
+		for atomic.LoadInt32(&security.slowDials) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		fast := make(chan error, 1)
+		go func() {
+			_, err := c.session(addrs[1])
+			fast <- err
+		}()
+
+		select {
+		case err := <-fast:
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected session to the fast peer to open while the slow one handshakes")
+		}
+
+		close(security.release)
+		if s1, s2 := <-slow, <-slow; s1 == nil || s1 != s2 {
+			t.Fatalf("Expected both callers to share the same session, got %p and %p", s1, s2)
+		}
+
+		if slowDials := atomic.LoadInt32(&security.slowDials); slowDials != 1 {
+			t.Fatalf("Expected a single dial to the slow peer, got %d", slowDials)
+		}
+	})
+}
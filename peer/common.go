@@ -1,14 +1,59 @@
 package peer
 
-import "github.com/daeMOn63/gorrent/gorrent"
+import (
+	"errors"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
 
 const (
-	// MaxUDPPacketSize defines the maximum size of udp packets
-	MaxUDPPacketSize = 1024
+	// BlockSize defines the number of bytes requested at once from a peer
+	BlockSize = 16 * 1024
+)
+
+var (
+	// ErrInvalidBlock is returned when a ChunkRequest offset and length does not fit in the chunk
+	ErrInvalidBlock = errors.New("invalid block")
+	// ErrUnknownGorrent is returned when a peer requests a gorrent which is not in the store
+	ErrUnknownGorrent = errors.New("unknown gorrent")
 )
 
 // ChunkRequest defines the data transfered on a chunkRequest
+// Offset and Length select a block inside the chunk, so a chunk can be fetched
+// as multiple blocks, possibly from different peers.
 type ChunkRequest struct {
 	InfoHash gorrent.Sha1Hash
 	ChunkID  int64
+	Offset   int64
+	Length   int64
+}
+
+// Validate returns ErrInvalidBlock when the requested block does not fit in a chunk of pieceLength bytes
+func (c *ChunkRequest) Validate(pieceLength int) error {
+	if c.Offset < 0 || c.Length <= 0 || c.Offset+c.Length > int64(pieceLength) {
+		return ErrInvalidBlock
+	}
+
+	return nil
+}
+
+// NewBlockRequests splits the given chunk in requests of at most blockSize bytes
+func NewBlockRequests(infoHash gorrent.Sha1Hash, chunkID int64, pieceLength int, blockSize int) []*ChunkRequest {
+	var requests []*ChunkRequest
+
+	for offset := int64(0); offset < int64(pieceLength); offset += int64(blockSize) {
+		length := int64(blockSize)
+		if offset+length > int64(pieceLength) {
+			length = int64(pieceLength) - offset
+		}
+
+		requests = append(requests, &ChunkRequest{
+			InfoHash: infoHash,
+			ChunkID:  chunkID,
+			Offset:   offset,
+			Length:   length,
+		})
+	}
+
+	return requests
 }
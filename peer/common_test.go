@@ -0,0 +1,53 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestNewBlockRequests(t *testing.T) {
+	t.Run("NewBlockRequests splits the chunk in blocks", func(t *testing.T) {
+		infoHash := gorrent.RandomSha1Hash()
+
+		requests := NewBlockRequests(infoHash, 3, 40, 16)
+		if len(requests) != 3 {
+			t.Fatalf("Expected 3 requests, got %d", len(requests))
+		}
+
+		expected := []ChunkRequest{
+			{InfoHash: infoHash, ChunkID: 3, Offset: 0, Length: 16},
+			{InfoHash: infoHash, ChunkID: 3, Offset: 16, Length: 16},
+			{InfoHash: infoHash, ChunkID: 3, Offset: 32, Length: 8},
+		}
+
+		for i, r := range requests {
+			if *r != expected[i] {
+				t.Fatalf("Expected request %d to be %#v, got %#v", i, expected[i], *r)
+			}
+		}
+	})
+}
+
+func TestChunkRequestValidate(t *testing.T) {
+	t.Run("Validate accepts blocks inside the chunk", func(t *testing.T) {
+		r := &ChunkRequest{Offset: 16, Length: 16}
+		if err := r.Validate(32); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+	})
+
+	t.Run("Validate rejects blocks outside the chunk", func(t *testing.T) {
+		invalids := []*ChunkRequest{
+			{Offset: -1, Length: 16},
+			{Offset: 0, Length: 0},
+			{Offset: 16, Length: 17},
+		}
+
+		for _, r := range invalids {
+			if err := r.Validate(32); err != ErrInvalidBlock {
+				t.Fatalf("Expected err to be %s, got %v", ErrInvalidBlock, err)
+			}
+		}
+	})
+}
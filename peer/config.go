@@ -19,6 +19,41 @@ type Config struct {
 	TmpPath         string `json:"tmpPath"`
 	TrackerProtocol string `json:"trackerProtocol"`
 	AnnounceDelay   int    `json:"announceDelay"`
+	MinQueueDepth   int    `json:"minQueueDepth"`
+	MaxQueueDepth   int    `json:"maxQueueDepth"`
+	ParallelChunks  int    `json:"parallelChunks"`
+	PexInterval     int    `json:"pexInterval"`
+	PeerMaxAge      int    `json:"peerMaxAge"`
+	ReadTimeout     int    `json:"readTimeout"`
+
+	Security       *SecurityConfig       `json:"security"`
+	LocalDiscovery *LocalDiscoveryConfig `json:"localDiscovery"`
+	DHT            *DHTConfig            `json:"dht"`
+}
+
+// SecurityConfig list the options to secure peer connections.
+// Either a NetworkKey shared by all the peers, or a certificate signed by a CA trusted by all peers must be set.
+type SecurityConfig struct {
+	NetworkKey string `json:"networkKey"`
+	CertFile   string `json:"certFile"`
+	KeyFile    string `json:"keyFile"`
+	CAFile     string `json:"caFile"`
+}
+
+// LocalDiscoveryConfig list the options to discover peers on the local network through UDP multicast.
+// Group defaults to DefaultDiscoveryGroup, Interface to the system default one, and Interval (in ms) to DefaultDiscoveryInterval.
+type LocalDiscoveryConfig struct {
+	Group     string `json:"group"`
+	Interface string `json:"interface"`
+	Interval  int    `json:"interval"`
+}
+
+// DHTConfig list the options of the DHT node, allowing to find peers of trackerless gorrents.
+// Interval (in ms) defaults to DefaultDHTInterval.
+type DHTConfig struct {
+	Addr      string   `json:"addr"`
+	Bootstrap []string `json:"bootstrap"`
+	Interval  int      `json:"interval"`
 }
 
 // Configurator allow to load a configuration
@@ -83,12 +118,23 @@ func NewConfigValidator() ConfigValidator {
 
 // Validation errors
 var (
-	ErrConfigIDRequired        = errors.New("config: id is required")
-	ErrConfigSockPathRequired  = errors.New("config: socketPath is required")
-	ErrConfigDbPathRequired    = errors.New("config: dbPath is required")
-	ErrTmpPathRequired         = errors.New("config: tmpPath is required")
-	ErrTrackerProtocolRequired = errors.New("config: trackerProcotol is required")
-	ErrAnnounceDelayRequired   = errors.New("config: announceDelay is required")
+	ErrConfigIDRequired         = errors.New("config: id is required")
+	ErrConfigSockPathRequired   = errors.New("config: socketPath is required")
+	ErrConfigDbPathRequired     = errors.New("config: dbPath is required")
+	ErrTmpPathRequired          = errors.New("config: tmpPath is required")
+	ErrTrackerProtocolRequired  = errors.New("config: trackerProcotol is required")
+	ErrAnnounceDelayRequired    = errors.New("config: announceDelay is required")
+	ErrQueueDepthInvalid        = errors.New("config: minQueueDepth must be lower or equal to maxQueueDepth")
+	ErrParallelChunksInvalid    = errors.New("config: parallelChunks must not be negative")
+	ErrPexIntervalInvalid       = errors.New("config: pexInterval must not be negative")
+	ErrPeerMaxAgeInvalid        = errors.New("config: peerMaxAge must not be negative")
+	ErrReadTimeoutInvalid       = errors.New("config: readTimeout must not be negative")
+	ErrSecurityRequired         = errors.New("config: security requires either networkKey, or certFile, keyFile and caFile")
+	ErrSecurityConflict         = errors.New("config: security networkKey cannot be used along certificates")
+	ErrDiscoveryGroupInvalid    = errors.New("config: localDiscovery group must be a multicast ip:port")
+	ErrDiscoveryIntervalInvalid = errors.New("config: localDiscovery interval must be positive")
+	ErrDHTAddrRequired          = errors.New("config: dht addr is required")
+	ErrDHTIntervalInvalid       = errors.New("config: dht interval must be positive")
 )
 
 // Validate check given configuration and returns errors when any fields has invalid value
@@ -118,5 +164,60 @@ func (c *configValidator) Validate(cfg *Config) error {
 		return ErrAnnounceDelayRequired
 	}
 
+	if cfg.MinQueueDepth < 0 || cfg.MaxQueueDepth < 0 || (cfg.MaxQueueDepth > 0 && cfg.MinQueueDepth > cfg.MaxQueueDepth) {
+		return ErrQueueDepthInvalid
+	}
+
+	if cfg.ParallelChunks < 0 {
+		return ErrParallelChunksInvalid
+	}
+
+	if cfg.PexInterval < 0 {
+		return ErrPexIntervalInvalid
+	}
+
+	if cfg.PeerMaxAge < 0 {
+		return ErrPeerMaxAgeInvalid
+	}
+
+	if cfg.ReadTimeout < 0 {
+		return ErrReadTimeoutInvalid
+	}
+
+	if cfg.Security != nil {
+		hasCert := len(cfg.Security.CertFile) > 0 || len(cfg.Security.KeyFile) > 0 || len(cfg.Security.CAFile) > 0
+
+		if len(cfg.Security.NetworkKey) > 0 && hasCert {
+			return ErrSecurityConflict
+		}
+
+		if len(cfg.Security.NetworkKey) == 0 && (len(cfg.Security.CertFile) == 0 || len(cfg.Security.KeyFile) == 0 || len(cfg.Security.CAFile) == 0) {
+			return ErrSecurityRequired
+		}
+	}
+
+	if cfg.LocalDiscovery != nil {
+		if len(cfg.LocalDiscovery.Group) > 0 {
+			group, err := net.ResolveUDPAddr("udp", cfg.LocalDiscovery.Group)
+			if err != nil || !group.IP.IsMulticast() {
+				return ErrDiscoveryGroupInvalid
+			}
+		}
+
+		if cfg.LocalDiscovery.Interval < 0 {
+			return ErrDiscoveryIntervalInvalid
+		}
+	}
+
+	if cfg.DHT != nil {
+		if len(cfg.DHT.Addr) == 0 {
+			return ErrDHTAddrRequired
+		}
+
+		if cfg.DHT.Interval < 0 {
+			return ErrDHTIntervalInvalid
+		}
+	}
+
 	return nil
 }
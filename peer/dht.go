@@ -0,0 +1,95 @@
+package peer
+
+import (
+	"log"
+	"time"
+
+	"github.com/daeMOn63/gorrent/dht"
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+// DefaultDHTInterval defines how often held gorrents are announced on the DHT
+const DefaultDHTInterval = 5 * time.Minute
+
+// DHTBootstrapRetryInterval defines how long to wait before retrying a failed DHT bootstrap
+const DHTBootstrapRetryInterval = 30 * time.Second
+
+type dhtAnnouncer struct {
+	store        GorrentStore
+	node         dht.Node
+	self         gorrent.PeerAddr
+	bootstrap    []string
+	bootstrapped bool
+	interval     time.Duration
+}
+
+var _ Announcer = &dhtAnnouncer{}
+
+// NewDHTAnnouncer creates a new Announcer, announcing self on the DHT and merging the peers found there.
+// Private gorrents are never announced on the DHT, their swarm being restricted to the holders of their secret.
+// The node joins the DHT through the bootstrap nodes before announcing, retrying until one of them answers.
+func NewDHTAnnouncer(store GorrentStore, node dht.Node, self gorrent.PeerAddr, bootstrap []string, interval time.Duration) Announcer {
+	return &dhtAnnouncer{
+		store:     store,
+		node:      node,
+		self:      self,
+		bootstrap: bootstrap,
+		interval:  interval,
+	}
+}
+
+func (a *dhtAnnouncer) AnnounceForever() error {
+	log.Printf("Starting DHT announcer")
+	for {
+		if !a.bootstrapped {
+			if err := a.node.Bootstrap(a.bootstrap); err != nil {
+				log.Printf("DHT bootstrap error, retrying in %s: %s", DHTBootstrapRetryInterval, err)
+				time.Sleep(DHTBootstrapRetryInterval)
+
+				continue
+			}
+			a.bootstrapped = true
+		}
+
+		if err := a.Announce(); err != nil {
+			log.Printf("DHT announce error: %s", err)
+		}
+
+		time.Sleep(a.interval)
+	}
+}
+
+// Stop does nothing, as peers stored on the DHT expire on their own
+func (a *dhtAnnouncer) Stop() error {
+	return nil
+}
+
+func (a *dhtAnnouncer) Announce() error {
+	entries, err := a.store.All()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Status == StatusNew || entry.Gorrent.IsPrivate() {
+			continue
+		}
+
+		infoHash := entry.Gorrent.InfoHash()
+		peers, err := a.node.Announce(infoHash, a.self.Port)
+		if err != nil {
+			log.Printf("DHT announce error for %s (%s): %s", entry.Name, infoHash.HexString(), err)
+
+			continue
+		}
+
+		if err := MergePeers(a.store, a.self, infoHash, peers); err != nil {
+			log.Printf("Failed merging DHT peers for %s (%s): %s", entry.Name, infoHash.HexString(), err)
+
+			continue
+		}
+		log.Printf("Got %d peers from DHT for %s (%s)", len(peers), entry.Name, infoHash.HexString())
+	}
+
+	return nil
+}
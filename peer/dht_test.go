@@ -0,0 +1,55 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/dht"
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestDHTAnnouncer(t *testing.T) {
+	t.Run("Announce continues with the next gorrents when one fails", func(t *testing.T) {
+		failing := &gorrent.Gorrent{CreationDate: time.Unix(1, 0)}
+		working := &gorrent.Gorrent{CreationDate: time.Unix(2, 0)}
+
+		store := newTestStore(t,
+			&GorrentEntry{Name: "failing", Gorrent: failing, Status: StatusDownloading},
+			&GorrentEntry{Name: "working", Gorrent: working, Status: StatusDownloading},
+		)
+
+		found := gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1)
+		announced := 0
+		node := &dht.DummyNode{
+			AnnounceFunc: func(infoHash gorrent.Sha1Hash, port uint16) ([]gorrent.PeerAddr, error) {
+				announced++
+				if infoHash == failing.InfoHash() {
+					return nil, errors.New("announce failed")
+				}
+
+				return []gorrent.PeerAddr{found}, nil
+			},
+		}
+
+		self := gorrent.NewPeerAddr(net.ParseIP("10.0.0.2"), 2)
+		a := NewDHTAnnouncer(store, node, self, nil, time.Minute).(*dhtAnnouncer)
+		if err := a.Announce(); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if announced != 2 {
+			t.Fatalf("Expected 2 announces, got %d", announced)
+		}
+
+		entry, err := store.Get(working.InfoHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entry.PeerAddrs) != 1 || entry.PeerAddrs[0] != found {
+			t.Fatalf("Expected peers to be %v, got %v", []gorrent.PeerAddr{found}, entry.PeerAddrs)
+		}
+	})
+}
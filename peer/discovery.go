@@ -0,0 +1,248 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+const (
+	// DefaultDiscoveryGroup is the multicast group used for local peer discovery
+	DefaultDiscoveryGroup = "239.192.152.143:6771"
+	// DefaultDiscoveryInterval defines how often held gorrents are announced on the local network
+	DefaultDiscoveryInterval = 10 * time.Second
+
+	// maxDiscoveryHashes defines the maximum number of info hashes sent per datagram, to keep them under the usual MTU
+	maxDiscoveryHashes = 64
+	// maxDatagramSize defines the maximum size of a received datagram
+	maxDatagramSize = 8192
+)
+
+var (
+	discoveryMagic = [4]byte{'G', 'R', 'L', 'D'}
+
+	// ErrInvalidDiscoveryMessage is returned when a received datagram is not a local discovery announce
+	ErrInvalidDiscoveryMessage = errors.New("invalid local discovery message")
+	// ErrNotMulticast is returned when the discovery group is not a multicast address
+	ErrNotMulticast = errors.New("discovery group is not a multicast address")
+)
+
+// LocalDiscovery announces the held gorrents on the local network through UDP multicast,
+// and adds the peers announcing the same gorrents to their swarm, without any tracker.
+type LocalDiscovery interface {
+	Listen() error
+	AnnounceForever() error
+	Announce() error
+}
+
+// DiscoveryAnnounce is the multicast message sent by peers, listing the gorrents they hold
+// On the wire, it is the discoveryMagic, the peer ID, its port as a big endian uint16, the number of info hashes
+// as a big endian uint16, and the info hashes.
+type DiscoveryAnnounce struct {
+	PeerID     gorrent.PeerID
+	Port       uint16
+	InfoHashes []gorrent.Sha1Hash
+}
+
+// EncodeDiscoveryAnnounce returns the binary representation of the DiscoveryAnnounce
+func EncodeDiscoveryAnnounce(a *DiscoveryAnnounce) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(discoveryMagic[:])
+	buf.Write(a.PeerID[:])
+	binary.Write(buf, binary.BigEndian, a.Port)
+	binary.Write(buf, binary.BigEndian, uint16(len(a.InfoHashes)))
+	for _, h := range a.InfoHashes {
+		buf.Write(h[:])
+	}
+
+	return buf.Bytes()
+}
+
+// DecodeDiscoveryAnnounce reads a DiscoveryAnnounce from b
+func DecodeDiscoveryAnnounce(b []byte) (*DiscoveryAnnounce, error) {
+	r := bytes.NewReader(b)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || magic != discoveryMagic {
+		return nil, ErrInvalidDiscoveryMessage
+	}
+
+	a := &DiscoveryAnnounce{}
+	if _, err := io.ReadFull(r, a.PeerID[:]); err != nil {
+		return nil, ErrInvalidDiscoveryMessage
+	}
+
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &a.Port); err != nil {
+		return nil, ErrInvalidDiscoveryMessage
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, ErrInvalidDiscoveryMessage
+	}
+
+	a.InfoHashes = make([]gorrent.Sha1Hash, count)
+	for i := range a.InfoHashes {
+		if _, err := io.ReadFull(r, a.InfoHashes[i][:]); err != nil {
+			return nil, ErrInvalidDiscoveryMessage
+		}
+	}
+
+	return a, nil
+}
+
+type localDiscovery struct {
+	store    GorrentStore
+	self     gorrent.Peer
+	group    *net.UDPAddr
+	iface    *net.Interface
+	interval time.Duration
+}
+
+var _ LocalDiscovery = &localDiscovery{}
+
+// NewLocalDiscovery creates a new LocalDiscovery on the given multicast group.
+// When iface is nil, the system default interface is used.
+func NewLocalDiscovery(store GorrentStore, self gorrent.Peer, group *net.UDPAddr, iface *net.Interface, interval time.Duration) (LocalDiscovery, error) {
+	if !group.IP.IsMulticast() {
+		return nil, ErrNotMulticast
+	}
+
+	return &localDiscovery{
+		store:    store,
+		self:     self,
+		group:    group,
+		iface:    iface,
+		interval: interval,
+	}, nil
+}
+
+// Listen joins the multicast group and merges the announced peers in the swarm of the gorrents they share with us
+func (d *localDiscovery) Listen() error {
+	conn, err := net.ListenMulticastUDP("udp", d.iface, d.group)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	log.Printf("Local discovery listening on %s", d.group)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+
+		announce, err := DecodeDiscoveryAnnounce(buf[:n])
+		if err != nil {
+			log.Printf("Local discovery: ignoring datagram from %s: %s", src, err)
+
+			continue
+		}
+
+		if announce.PeerID == d.self.ID {
+			continue
+		}
+
+		addr := gorrent.NewPeerAddr(src.IP, announce.Port)
+		for _, infoHash := range announce.InfoHashes {
+			err := MergePeers(d.store, d.self.PeerAddr, infoHash, []gorrent.PeerAddr{addr})
+			if err != nil && err != ErrEntryNotFound {
+				log.Printf("Local discovery: failed adding %s to %s: %s", addr, infoHash.HexString(), err)
+			}
+		}
+	}
+}
+
+func (d *localDiscovery) AnnounceForever() error {
+	ticker := time.NewTicker(d.interval)
+	log.Printf("Starting local discovery announcer")
+	for range ticker.C {
+		if err := d.Announce(); err != nil {
+			log.Printf("Local discovery announce error: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Announce sends the info hashes of the held gorrents to the multicast group
+func (d *localDiscovery) Announce() error {
+	entries, err := d.store.All()
+	if err != nil {
+		return err
+	}
+
+	var infoHashes []gorrent.Sha1Hash
+	for _, entry := range entries {
+		if entry.Status == StatusNew {
+			continue
+		}
+
+		infoHashes = append(infoHashes, entry.Gorrent.InfoHash())
+	}
+
+	if len(infoHashes) == 0 {
+		return nil
+	}
+
+	laddr, err := d.localAddr()
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp", laddr, d.group)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for len(infoHashes) > 0 {
+		n := len(infoHashes)
+		if n > maxDiscoveryHashes {
+			n = maxDiscoveryHashes
+		}
+
+		msg := EncodeDiscoveryAnnounce(&DiscoveryAnnounce{
+			PeerID:     d.self.ID,
+			Port:       d.self.Port,
+			InfoHashes: infoHashes[:n],
+		})
+
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+
+		infoHashes = infoHashes[n:]
+	}
+
+	return nil
+}
+
+// localAddr returns an address of the configured interface, so the datagrams are sent on it
+func (d *localDiscovery) localAddr() (*net.UDPAddr, error) {
+	if d.iface == nil {
+		return nil, nil
+	}
+
+	addrs, err := d.iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	isIPv4 := d.group.IP.To4() != nil
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && (ipNet.IP.To4() != nil) == isIPv4 {
+			return &net.UDPAddr{IP: ipNet.IP}, nil
+		}
+	}
+
+	return nil, nil
+}
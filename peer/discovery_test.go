@@ -0,0 +1,113 @@
+package peer
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestDiscoveryAnnounce(t *testing.T) {
+	t.Run("DecodeDiscoveryAnnounce reads what EncodeDiscoveryAnnounce wrote", func(t *testing.T) {
+		a := &DiscoveryAnnounce{
+			Port:       1234,
+			InfoHashes: []gorrent.Sha1Hash{{0x1}, {0x2}},
+		}
+		a.PeerID.SetString("peer")
+
+		decoded, err := DecodeDiscoveryAnnounce(EncodeDiscoveryAnnounce(a))
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if decoded.PeerID != a.PeerID || decoded.Port != a.Port || len(decoded.InfoHashes) != 2 || decoded.InfoHashes[1] != a.InfoHashes[1] {
+			t.Fatalf("Expected announce to be %#v, got %#v", a, decoded)
+		}
+	})
+
+	t.Run("DecodeDiscoveryAnnounce fails on foreign datagrams", func(t *testing.T) {
+		if _, err := DecodeDiscoveryAnnounce([]byte("hello")); err != ErrInvalidDiscoveryMessage {
+			t.Fatalf("Expected err to be %s, got %v", ErrInvalidDiscoveryMessage, err)
+		}
+	})
+}
+
+func newTestStore(t *testing.T, entries ...*GorrentEntry) GorrentStore {
+	store, err := NewStore(filepath.Join(t.TempDir(), "peer.db"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if err := store.Save(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return store
+}
+
+func TestLocalDiscovery(t *testing.T) {
+	t.Run("Peers holding the same gorrent discover each other", func(t *testing.T) {
+		g := &gorrent.Gorrent{Announce: "127.0.0.1:6969"}
+		group := &net.UDPAddr{IP: net.IPv4(239, 192, 152, 143), Port: 16771}
+
+		announcer := gorrent.NewPeer("announcer", net.ParseIP("127.0.0.1"), 1111)
+		listener := gorrent.NewPeer("listener", net.ParseIP("127.0.0.1"), 2222)
+
+		announcerStore := newTestStore(t, &GorrentEntry{Name: "test", Gorrent: g, Status: StatusCompleted})
+		listenerStore := newTestStore(t, &GorrentEntry{Name: "test", Gorrent: g, Status: StatusDownloading})
+
+		a, err := NewLocalDiscovery(announcerStore, *announcer, group, nil, time.Second)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		l, err := NewLocalDiscovery(listenerStore, *listener, group, nil, time.Second)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		listenErr := make(chan error, 1)
+		go func() {
+			listenErr <- l.Listen()
+		}()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			select {
+			case err := <-listenErr:
+				t.Skipf("Multicast is not available: %s", err)
+			default:
+			}
+
+			if err := a.Announce(); err != nil {
+				t.Skipf("Multicast is not available: %s", err)
+			}
+
+			entry, err := listenerStore.Get(g.InfoHash())
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			for _, addr := range entry.PeerAddrs {
+				if addr.Port == announcer.Port {
+					return
+				}
+			}
+
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		t.Fatalf("Expected announcer to be discovered")
+	})
+
+	t.Run("NewLocalDiscovery requires a multicast group", func(t *testing.T) {
+		_, err := NewLocalDiscovery(nil, gorrent.Peer{}, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1}, nil, time.Second)
+		if err != ErrNotMulticast {
+			t.Fatalf("Expected err to be %s, got %v", ErrNotMulticast, err)
+		}
+	})
+}
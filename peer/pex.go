@@ -0,0 +1,133 @@
+package peer
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+const (
+	// DefaultPeerMaxAge defines how long a peer is kept once it stopped being announced or exchanged
+	DefaultPeerMaxAge = 10 * time.Minute
+	// MaxPexPeers defines the maximum number of peers shared in a single peer exchange
+	MaxPexPeers = 50
+	// PexFanout defines the number of peers contacted per gorrent on each peer exchange round
+	PexFanout = 10
+)
+
+// PeerExchanger periodically exchanges the known peers of each gorrent with other peers,
+// so the swarm keeps growing even when the tracker is unavailable.
+type PeerExchanger interface {
+	ExchangeForever() error
+}
+
+type peerExchanger struct {
+	store    GorrentStore
+	client   Client
+	self     gorrent.PeerAddr
+	interval time.Duration
+	maxAge   time.Duration
+}
+
+var _ PeerExchanger = &peerExchanger{}
+
+// NewPeerExchanger creates a new PeerExchanger, sharing self along the known peers
+func NewPeerExchanger(store GorrentStore, client Client, self gorrent.PeerAddr, interval time.Duration, maxAge time.Duration) PeerExchanger {
+	return &peerExchanger{
+		store:    store,
+		client:   client,
+		self:     self,
+		interval: interval,
+		maxAge:   maxAge,
+	}
+}
+
+func (p *peerExchanger) ExchangeForever() error {
+	ticker := time.NewTicker(p.interval)
+	log.Printf("Starting peer exchange")
+	for range ticker.C {
+		if err := p.Exchange(); err != nil {
+			log.Printf("Peer exchange error: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Exchange expires the old peers of each gorrent, and sends the remaining ones to a random subset of them
+func (p *peerExchanger) Exchange() error {
+	entries, err := p.store.All()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, entry := range entries {
+		if entry.Status == StatusNew {
+			continue
+		}
+
+		infoHash := entry.Gorrent.InfoHash()
+
+		var addrs []gorrent.PeerAddr
+		err := p.store.Update(infoHash, func(g *GorrentEntry) error {
+			g.ExpirePeerAddrs(p.maxAge, now)
+			addrs = g.PeerAddrs
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		targets := excludePeerAddr(addrs, p.self)
+		rand.Shuffle(len(targets), func(i, j int) {
+			targets[i], targets[j] = targets[j], targets[i]
+		})
+
+		shared := append([]gorrent.PeerAddr{p.self}, targets...)
+		if len(shared) > MaxPexPeers {
+			shared = shared[:MaxPexPeers]
+		}
+
+		if len(targets) > PexFanout {
+			targets = targets[:PexFanout]
+		}
+
+		for _, target := range targets {
+			if err := p.client.SendPeers(target, infoHash, shared); err != nil {
+				log.Printf("Peer exchange with %s for %s failed: %s", target, infoHash.HexString(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// MergePeers adds addrs to the known peers of the gorrent, ignoring self
+func MergePeers(store GorrentStore, self gorrent.PeerAddr, infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr) error {
+	addrs = excludePeerAddr(addrs, self)
+	if len(addrs) > MaxPexPeers {
+		addrs = addrs[:MaxPexPeers]
+	}
+
+	return store.Update(infoHash, func(g *GorrentEntry) error {
+		g.MergePeerAddrs(addrs, time.Now())
+
+		return nil
+	})
+}
+
+func excludePeerAddr(addrs []gorrent.PeerAddr, excluded gorrent.PeerAddr) []gorrent.PeerAddr {
+	out := make([]gorrent.PeerAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr != excluded {
+			out = append(out, addr)
+		}
+	}
+
+	return out
+}
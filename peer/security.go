@@ -0,0 +1,349 @@
+package peer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// HandshakeTimeout defines the maximum time allowed to establish a secured connection
+	HandshakeTimeout = 5 * time.Second
+
+	// maxRecordSize defines the maximum plaintext size of a network key record
+	maxRecordSize      = 16 * 1024
+	handshakeNonceSize = 32
+)
+
+var (
+	// ErrAuthenticationFailed is returned when the remote peer failed to prove it belongs to the network
+	ErrAuthenticationFailed = errors.New("peer authentication failed")
+	// ErrRecordTooLarge is returned when a received record exceeds the maximum record size
+	ErrRecordTooLarge = errors.New("record too large")
+	// ErrInvalidCA is returned when no certificate could be loaded from the CA file
+	ErrInvalidCA = errors.New("no valid certificate in CA file")
+)
+
+// Security wraps raw peer connections, to authenticate the remote peer and encrypt the traffic
+type Security interface {
+	// Client secures a connection initiated by this peer
+	Client(conn net.Conn) (net.Conn, error)
+	// Server secures a connection accepted by this peer
+	Server(conn net.Conn) (net.Conn, error)
+}
+
+// NewSecurity returns the Security matching the configuration, or a plain one when cfg is nil
+func NewSecurity(cfg *SecurityConfig) (Security, error) {
+	if cfg == nil {
+		return NewPlainSecurity(), nil
+	}
+
+	if cfg.NetworkKey != "" {
+		return NewNetworkKeySecurity([]byte(cfg.NetworkKey)), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caPEM, err := ioutil.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, ErrInvalidCA
+	}
+
+	return NewTLSSecurity(cert, pool), nil
+}
+
+type plainSecurity struct{}
+
+var _ Security = &plainSecurity{}
+
+// NewPlainSecurity returns a Security leaving connections untouched
+func NewPlainSecurity() Security {
+	return &plainSecurity{}
+}
+
+func (s *plainSecurity) Client(conn net.Conn) (net.Conn, error) {
+	return conn, nil
+}
+
+func (s *plainSecurity) Server(conn net.Conn) (net.Conn, error) {
+	return conn, nil
+}
+
+type tlsSecurity struct {
+	cfg *tls.Config
+}
+
+var _ Security = &tlsSecurity{}
+
+// NewTLSSecurity returns a Security using mutual TLS. Both peers must present a certificate
+// signed by the given CA. As peers are addressed by ip, certificates hostnames are not verified.
+func NewTLSSecurity(cert tls.Certificate, ca *x509.CertPool) Security {
+	verify := func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return ErrAuthenticationFailed
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, c := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         ca,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+
+		return err
+	}
+
+	return &tlsSecurity{
+		cfg: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    ca,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+			// Hostname verification is replaced by VerifyConnection
+			InsecureSkipVerify: true,
+			VerifyConnection:   verify,
+		},
+	}
+}
+
+func (s *tlsSecurity) Client(conn net.Conn) (net.Conn, error) {
+	return s.handshake(tls.Client(conn, s.cfg))
+}
+
+func (s *tlsSecurity) Server(conn net.Conn) (net.Conn, error) {
+	return s.handshake(tls.Server(conn, s.cfg))
+}
+
+func (s *tlsSecurity) handshake(conn *tls.Conn) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+type networkKeySecurity struct {
+	key [sha256.Size]byte
+}
+
+var _ Security = &networkKeySecurity{}
+
+// NewNetworkKeySecurity returns a Security where peers authenticate each other by proving
+// knowledge of a shared network key. Session keys are derived from the network key and random
+// nonces exchanged by both sides, and traffic is encrypted with AES-GCM.
+func NewNetworkKeySecurity(networkKey []byte) Security {
+	return &networkKeySecurity{
+		key: sha256.Sum256(networkKey),
+	}
+}
+
+func (s *networkKeySecurity) Client(conn net.Conn) (net.Conn, error) {
+	return s.handshake(conn, true)
+}
+
+func (s *networkKeySecurity) Server(conn net.Conn) (net.Conn, error) {
+	return s.handshake(conn, false)
+}
+
+// handshake exchanges nonces, derive session keys and verify the remote peer proof
+func (s *networkKeySecurity) handshake(conn net.Conn, isClient bool) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+
+	sc, err := s.doHandshake(conn, isClient)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return sc, nil
+}
+
+func (s *networkKeySecurity) doHandshake(conn net.Conn, isClient bool) (net.Conn, error) {
+	localNonce := make([]byte, handshakeNonceSize)
+	if _, err := rand.Read(localNonce); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(localNonce); err != nil {
+		return nil, err
+	}
+
+	remoteNonce := make([]byte, handshakeNonceSize)
+	if _, err := io.ReadFull(conn, remoteNonce); err != nil {
+		return nil, err
+	}
+
+	clientNonce, serverNonce := localNonce, remoteNonce
+	if !isClient {
+		clientNonce, serverNonce = remoteNonce, localNonce
+	}
+
+	master := s.mac([]byte("gorrent"), clientNonce, serverNonce)
+
+	localLabel, remoteLabel := "client", "server"
+	if !isClient {
+		localLabel, remoteLabel = remoteLabel, localLabel
+	}
+
+	if _, err := conn.Write(hmacSum(master, []byte(localLabel+" proof"))); err != nil {
+		return nil, err
+	}
+
+	remoteProof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, remoteProof); err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(remoteProof, hmacSum(master, []byte(remoteLabel+" proof"))) {
+		return nil, ErrAuthenticationFailed
+	}
+
+	writeAEAD, err := newAEAD(hmacSum(master, []byte(localLabel+" key")))
+	if err != nil {
+		return nil, err
+	}
+
+	readAEAD, err := newAEAD(hmacSum(master, []byte(remoteLabel+" key")))
+	if err != nil {
+		return nil, err
+	}
+
+	return &secureConn{
+		Conn:      conn,
+		writeAEAD: writeAEAD,
+		readAEAD:  readAEAD,
+	}, nil
+}
+
+func (s *networkKeySecurity) mac(parts ...[]byte) []byte {
+	return hmacSum(s.key[:], parts...)
+}
+
+func hmacSum(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		h.Write(p)
+	}
+
+	return h.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// secureConn encrypts data in records, each prefixed by its sealed length as a big endian uint32.
+// Records nonces are sequence numbers, so replayed or reordered records fail to decrypt.
+type secureConn struct {
+	net.Conn
+
+	writeMutex sync.Mutex
+	writeAEAD  cipher.AEAD
+	writeSeq   uint64
+
+	readMutex sync.Mutex
+	readAEAD  cipher.AEAD
+	readSeq   uint64
+	readBuf   bytes.Buffer
+}
+
+func (c *secureConn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	var written int
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxRecordSize {
+			n = maxRecordSize
+		}
+
+		sealed := c.writeAEAD.Seal(nil, seqNonce(c.writeAEAD, c.writeSeq), b[:n], nil)
+		c.writeSeq++
+
+		record := make([]byte, 4, 4+len(sealed))
+		binary.BigEndian.PutUint32(record, uint32(len(sealed)))
+		record = append(record, sealed...)
+
+		if _, err := c.Conn.Write(record); err != nil {
+			return written, err
+		}
+
+		written += n
+		b = b[n:]
+	}
+
+	return written, nil
+}
+
+func (c *secureConn) Read(b []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	if c.readBuf.Len() == 0 {
+		var length uint32
+		if err := binary.Read(c.Conn, binary.BigEndian, &length); err != nil {
+			return 0, err
+		}
+
+		if int(length) > maxRecordSize+c.readAEAD.Overhead() {
+			return 0, ErrRecordTooLarge
+		}
+
+		sealed := make([]byte, length)
+		if _, err := io.ReadFull(c.Conn, sealed); err != nil {
+			return 0, err
+		}
+
+		plain, err := c.readAEAD.Open(nil, seqNonce(c.readAEAD, c.readSeq), sealed, nil)
+		if err != nil {
+			return 0, err
+		}
+		c.readSeq++
+
+		c.readBuf.Write(plain)
+	}
+
+	return c.readBuf.Read(b)
+}
+
+func seqNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+
+	return nonce
+}
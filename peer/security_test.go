@@ -0,0 +1,171 @@
+package peer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// securedPair connects a client and a server through given securities and returns both ends
+func securedPair(t *testing.T, client Security, server Security) (net.Conn, net.Conn, error, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+
+		sconn, err := server.Server(conn)
+		ch <- result{conn: sconn, err: err}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cconn, cerr := client.Client(conn)
+	r := <-ch
+
+	return cconn, r.conn, cerr, r.err
+}
+
+func assertExchange(t *testing.T, a net.Conn, b net.Conn) {
+	data := make([]byte, 3*maxRecordSize+10)
+	rand.Read(data)
+
+	go a.Write(data)
+
+	received := make([]byte, len(data))
+	if _, err := io.ReadFull(b, received); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if string(received) != string(data) {
+		t.Fatalf("Expected received data to match sent data")
+	}
+}
+
+func TestNetworkKeySecurity(t *testing.T) {
+	t.Run("Peers sharing the network key can exchange data", func(t *testing.T) {
+		s := NewNetworkKeySecurity([]byte("secret"))
+
+		c, srv, cerr, serr := securedPair(t, s, s)
+		if cerr != nil || serr != nil {
+			t.Fatalf("Expected no error, got %v / %v", cerr, serr)
+		}
+		defer c.Close()
+		defer srv.Close()
+
+		assertExchange(t, c, srv)
+		assertExchange(t, srv, c)
+	})
+
+	t.Run("Peers with different network keys are rejected", func(t *testing.T) {
+		_, _, cerr, serr := securedPair(t, NewNetworkKeySecurity([]byte("secret")), NewNetworkKeySecurity([]byte("other")))
+		if cerr != ErrAuthenticationFailed {
+			t.Fatalf("Expected client err to be %s, got %v", ErrAuthenticationFailed, cerr)
+		}
+		if serr != ErrAuthenticationFailed {
+			t.Fatalf("Expected server err to be %s, got %v", ErrAuthenticationFailed, serr)
+		}
+	})
+}
+
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gorrent test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func newTestCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "peer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSSecurity(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	t.Run("Peers with certificates signed by the CA can exchange data", func(t *testing.T) {
+		c, srv, cerr, serr := securedPair(t, NewTLSSecurity(newTestCert(t, ca, caKey), pool), NewTLSSecurity(newTestCert(t, ca, caKey), pool))
+		if cerr != nil || serr != nil {
+			t.Fatalf("Expected no error, got %v / %v", cerr, serr)
+		}
+		defer c.Close()
+		defer srv.Close()
+
+		assertExchange(t, c, srv)
+	})
+
+	t.Run("Peers with certificates from another CA are rejected", func(t *testing.T) {
+		otherCA, otherKey := newTestCA(t)
+
+		_, _, cerr, serr := securedPair(t, NewTLSSecurity(newTestCert(t, otherCA, otherKey), pool), NewTLSSecurity(newTestCert(t, ca, caKey), pool))
+		if cerr == nil && serr == nil {
+			t.Fatalf("An error was expected")
+		}
+	})
+}
@@ -1,12 +1,13 @@
 package server
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
+	"bufio"
+	"crypto/rand"
 	"io"
 	"log"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/daeMOn63/gorrent/buffer"
 	"github.com/daeMOn63/gorrent/fs"
@@ -16,92 +17,310 @@ import (
 
 // PublicServer defines a gorrent peer public server, used to handle connections from other peers.
 type PublicServer struct {
-	peer  gorrent.Peer
-	fs    fs.FileSystem
-	store peer.GorrentStore
+	peer        gorrent.Peer
+	fs          fs.FileSystem
+	store       peer.GorrentStore
+	security    peer.Security
+	readTimeout time.Duration
 }
 
-// NewPublicServer creates a new peer public server
-func NewPublicServer(peer gorrent.Peer, fs fs.FileSystem, store peer.GorrentStore) *PublicServer {
+// NewPublicServer creates a new peer public server.
+// Connections are closed when no message is received within readTimeout.
+func NewPublicServer(peer gorrent.Peer, fs fs.FileSystem, store peer.GorrentStore, security peer.Security, readTimeout time.Duration) *PublicServer {
 	return &PublicServer{
-		fs:    fs,
-		store: store,
+		peer:        peer,
+		fs:          fs,
+		store:       store,
+		security:    security,
+		readTimeout: readTimeout,
 	}
 }
 
 // Listen start listening for peer requests
+// The server binds the configured public ip, or all interfaces for both IPv4 and IPv6 when none is set.
 func (s *PublicServer) Listen() error {
-	pieceReader := buffer.NewPieceReader(s.fs)
+	host := ""
+	if ip := s.peer.IP(); !ip.IsUnspecified() {
+		host = ip.String()
+	}
 
-	addr := fmt.Sprintf("%s:%d", s.peer.PeerAddr, s.peer.Port)
-	link, err := net.ListenPacket("udp", addr)
+	addr := net.JoinHostPort(host, strconv.Itoa(int(s.peer.Port)))
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
-	defer link.Close()
+	defer listener.Close()
 
 	log.Printf("Peer server listening on %s", addr)
 
 	for {
-		buf := make([]byte, peer.MaxUDPPacketSize)
-		n, client, err := link.ReadFrom(buf)
+		conn, err := listener.Accept()
 		if err != nil {
-			log.Println("Error while reading from link: ", err)
+			log.Println("Error while accepting connection: ", err)
 
 			continue
 		}
 
-		r := bytes.NewReader(buf[:n])
-		chunkRequest, err := readChunkRequest(r)
+		go s.serve(conn)
+	}
+}
+
+// uploadFlushInterval defines how often the uploaded bytes of a connection are saved in the store
+const uploadFlushInterval = 5 * time.Second
+
+// cachedPiece holds the last piece read on a connection, as consecutive block requests
+// usually target the same piece.
+type cachedPiece struct {
+	infoHash gorrent.Sha1Hash
+	chunkID  int64
+	data     []byte
+}
+
+// serve handles requests from a single peer connection until it gets closed.
+// Requests are processed in order, the remote peer pipelines them to keep the link busy.
+func (s *PublicServer) serve(rawConn net.Conn) {
+	client := rawConn.RemoteAddr()
+
+	conn, err := s.security.Server(rawConn)
+	if err != nil {
+		log.Printf("%s handshake failed: %s", client, err)
+
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("%s connected", client)
+
+	pieceReader := buffer.NewPieceReader(s.fs)
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	var cache *cachedPiece
+
+	// Uploaded bytes are accumulated per gorrent and saved periodically, to avoid a store write per block
+	uploaded := make(map[gorrent.Sha1Hash]uint64)
+	lastFlush := time.Now()
+	defer s.saveUploaded(uploaded)
+
+	// Private gorrents are only served once the client proved it knows their swarm secret
+	challenge := make([]byte, peer.ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		log.Printf("%s: %s", client, err)
+
+		return
+	}
+
+	if err := peer.WriteMessage(conn, peer.MessageChallenge, challenge); err != nil {
+		log.Printf("%s write error: %s", client, err)
+
+		return
+	}
+
+	allowed := make(map[gorrent.Sha1Hash]bool)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		msg, err := peer.ReadMessage(reader)
 		if err != nil {
-			log.Println(err)
+			log.Printf("%s disconnected: %s", client, err)
 
-			continue
+			return
 		}
 
-		log.Printf("%s requested chunk %d from %s", client, chunkRequest.ChunkID, chunkRequest.InfoHash.HexString())
+		switch msg.ID {
+		case peer.MessageRequest:
+		case peer.MessageAuth:
+			if err := s.authenticate(msg.Payload, challenge, allowed); err != nil {
+				log.Printf("%s authentication error: %s", client, err)
+			}
 
-		entry, err := s.store.Get(chunkRequest.InfoHash)
-		if err != nil {
-			log.Println(err)
+			continue
+		case peer.MessagePex:
+			if err := s.exchangePeers(writer, msg.Payload, allowed); err != nil {
+				log.Printf("%s peer exchange error: %s", client, err)
+			}
+
+			if err := writer.Flush(); err != nil {
+				log.Printf("%s write error: %s", client, err)
+
+				return
+			}
+
+			continue
+		default:
+			log.Printf("%s sent unexpected message %#x", client, msg.ID)
 
 			continue
 		}
 
-		data, err := pieceReader.ReadPiece(entry.Path, entry.Gorrent.Files, chunkRequest.ChunkID, entry.Gorrent.PieceLength)
+		chunkRequest, _, err := peer.DecodeChunkRequest(msg.Payload)
 		if err != nil {
-			log.Println(err)
+			log.Printf("%s: %s", client, err)
 
-			continue
+			return
 		}
 
-		log.Printf("Sending %s (%s) chunk %d to %s", chunkRequest.InfoHash.HexString(), entry.Name, chunkRequest.ChunkID, client)
+		var data []byte
+		err = s.checkAllowed(chunkRequest.InfoHash, allowed)
+		if err == nil {
+			data, err = s.readBlock(pieceReader, chunkRequest, &cache)
+		}
 
-		data = data[:entry.Gorrent.PieceLength]
+		if err != nil {
+			log.Printf("%s requested chunk %d (offset %d) from %s: %s", client, chunkRequest.ChunkID, chunkRequest.Offset, chunkRequest.InfoHash.HexString(), err)
+			err = peer.WriteMessage(writer, peer.MessageReject, peer.EncodeChunkRequest(chunkRequest))
+		} else {
+			err = peer.WriteMessage(writer, peer.MessageBlock, append(peer.EncodeChunkRequest(chunkRequest), data...))
+			uploaded[chunkRequest.InfoHash] += uint64(len(data))
+		}
 
-		var current int
-		for current < len(data) {
-			packet := data[current:(current + peer.MaxUDPPacketSize)]
-			_, err = link.WriteTo(packet, client)
-			if err != nil {
-				log.Printf("write error: %s", err)
+		if err != nil {
+			log.Printf("%s write error: %s", client, err)
+
+			return
+		}
+
+		// Only flush when no more requests are already waiting, to batch responses
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				log.Printf("%s write error: %s", client, err)
 
-				break
+				return
 			}
+		}
 
-			current += peer.MaxUDPPacketSize
+		if time.Since(lastFlush) > uploadFlushInterval {
+			s.saveUploaded(uploaded)
+			lastFlush = time.Now()
 		}
+	}
+}
 
+// saveUploaded adds the uploaded bytes to their gorrent entries, and resets them
+func (s *PublicServer) saveUploaded(uploaded map[gorrent.Sha1Hash]uint64) {
+	for infoHash, n := range uploaded {
+		err := s.store.Update(infoHash, func(g *peer.GorrentEntry) error {
+			g.Uploaded += n
+
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed saving uploaded bytes of %s: %s", infoHash.HexString(), err)
+		}
+
+		delete(uploaded, infoHash)
 	}
 }
 
-// ReadChunkRequest reads a ChunkRequest struct from given Reader
-func readChunkRequest(r io.Reader) (*peer.ChunkRequest, error) {
-	cr := &peer.ChunkRequest{}
+// authenticate verifies the client proof of knowledge of a gorrent swarm secret, and allows it on success
+func (s *PublicServer) authenticate(payload []byte, challenge []byte, allowed map[gorrent.Sha1Hash]bool) error {
+	auth, err := peer.DecodeAuth(payload)
+	if err != nil {
+		return err
+	}
+
+	entry, err := s.store.Get(auth.InfoHash)
+	if err != nil {
+		return err
+	}
+
+	if entry.Gorrent == nil {
+		return peer.ErrUnknownGorrent
+	}
+
+	if !gorrent.VerifyProof(auth.Proof, entry.Gorrent.Secret, auth.InfoHash, challenge) {
+		return peer.ErrAuthenticationFailed
+	}
+
+	allowed[auth.InfoHash] = true
+
+	return nil
+}
+
+// exchangePeers merges the peers sent by the client in the store, and answers with the known peers of the gorrent
+func (s *PublicServer) exchangePeers(w io.Writer, payload []byte, allowed map[gorrent.Sha1Hash]bool) error {
+	pex, err := peer.DecodePex(payload)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkAllowed(pex.InfoHash, allowed); err != nil {
+		return err
+	}
+
+	if err := peer.MergePeers(s.store, s.peer.PeerAddr, pex.InfoHash, pex.PeerAddrs); err != nil {
+		return err
+	}
+
+	entry, err := s.store.Get(pex.InfoHash)
+	if err != nil {
+		return err
+	}
+
+	addrs := append([]gorrent.PeerAddr{s.peer.PeerAddr}, entry.PeerAddrs...)
+	if len(addrs) > peer.MaxPexPeers {
+		addrs = addrs[:peer.MaxPexPeers]
+	}
+
+	return peer.WriteMessage(w, peer.MessagePex, peer.EncodePex(&peer.Pex{
+		InfoHash:  pex.InfoHash,
+		PeerAddrs: addrs,
+	}))
+}
+
+// checkAllowed returns ErrAuthenticationFailed when the gorrent is private and the client did not authenticate for it
+func (s *PublicServer) checkAllowed(infoHash gorrent.Sha1Hash, allowed map[gorrent.Sha1Hash]bool) error {
+	isAllowed, ok := allowed[infoHash]
+	if !ok {
+		entry, err := s.store.Get(infoHash)
+		if err != nil {
+			return err
+		}
+
+		if entry.Gorrent == nil {
+			return peer.ErrUnknownGorrent
+		}
+
+		isAllowed = !entry.Gorrent.IsPrivate()
+		allowed[infoHash] = isAllowed
+	}
+
+	if !isAllowed {
+		return peer.ErrAuthenticationFailed
+	}
+
+	return nil
+}
+
+// readBlock returns the data of the requested block
+func (s *PublicServer) readBlock(pieceReader buffer.PieceReader, chunkRequest *peer.ChunkRequest, cache **cachedPiece) ([]byte, error) {
+	c := *cache
+	if c == nil || c.infoHash != chunkRequest.InfoHash || c.chunkID != chunkRequest.ChunkID {
+		entry, err := s.store.Get(chunkRequest.InfoHash)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Gorrent == nil {
+			return nil, peer.ErrUnknownGorrent
+		}
+
+		data, err := pieceReader.ReadPiece(entry.Path, entry.Gorrent.Files, chunkRequest.ChunkID, entry.Gorrent.PieceLength)
+		if err != nil {
+			return nil, err
+		}
+
+		c = &cachedPiece{
+			infoHash: chunkRequest.InfoHash,
+			chunkID:  chunkRequest.ChunkID,
+			data:     data[:entry.Gorrent.PieceLength],
+		}
+		*cache = c
+	}
 
-	if err := binary.Read(r, binary.BigEndian, cr); err != nil {
+	if err := chunkRequest.Validate(len(c.data)); err != nil {
 		return nil, err
 	}
 
-	return cr, nil
+	return c.data[chunkRequest.Offset:(chunkRequest.Offset + chunkRequest.Length)], nil
 }
@@ -0,0 +1,360 @@
+package peer
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+var (
+	// ErrSessionClosed is returned when a request is made on a closed session
+	ErrSessionClosed = errors.New("session closed")
+	// ErrBlockRejected is returned when the remote peer refused to serve a block
+	ErrBlockRejected = errors.New("block rejected")
+)
+
+const (
+	// rateWindow defines how often the session bandwidth estimation is refreshed
+	rateWindow = 250 * time.Millisecond
+	// rateSmoothing is the weight given to the latest bandwidth measure
+	rateSmoothing = 0.3
+)
+
+type blockResult struct {
+	data []byte
+	err  error
+}
+
+// session is a persistent connection to a peer, multiplexing block requests over it.
+// The number of outstanding requests is bounded by queueDepth, which is adapted
+// from the measured bandwidth-delay product of the link.
+type session struct {
+	addr        gorrent.PeerAddr
+	conn        net.Conn
+	readTimeout time.Duration
+	secrets     SwarmSecrets
+	onPeers     PeersHandler
+
+	minQueueDepth int
+	maxQueueDepth int
+
+	writeMutex sync.Mutex
+
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	pending    map[ChunkRequest]chan blockResult
+	sentAt     map[ChunkRequest]time.Time
+	inflight   int
+	queueDepth int
+	closed     bool
+	err        error
+	challenge  []byte
+	authorized map[gorrent.Sha1Hash]bool
+
+	minRTT      time.Duration
+	rate        float64
+	windowStart time.Time
+	windowBytes int
+}
+
+func newSession(addr gorrent.PeerAddr, conn net.Conn, cfg ClientConfig) *session {
+	s := &session{
+		addr:          addr,
+		conn:          conn,
+		readTimeout:   cfg.ReadTimeout,
+		secrets:       cfg.Secrets,
+		onPeers:       cfg.OnPeers,
+		minQueueDepth: cfg.MinQueueDepth,
+		maxQueueDepth: cfg.MaxQueueDepth,
+		pending:       make(map[ChunkRequest]chan blockResult),
+		sentAt:        make(map[ChunkRequest]time.Time),
+		authorized:    make(map[gorrent.Sha1Hash]bool),
+		queueDepth:    cfg.MinQueueDepth,
+		windowStart:   time.Now(),
+	}
+	s.cond = sync.NewCond(&s.mutex)
+
+	// The remote peer must send its challenge right away
+	conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+	go s.readLoop()
+
+	return s
+}
+
+// request sends the chunk request to the peer and waits for the block data
+func (s *session) request(r *ChunkRequest) ([]byte, error) {
+	if err := s.authorize(r.InfoHash); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan blockResult, 1)
+
+	s.mutex.Lock()
+	for !s.closed && s.inflight >= s.queueDepth {
+		s.cond.Wait()
+	}
+
+	if s.closed {
+		s.mutex.Unlock()
+		return nil, s.closeError()
+	}
+
+	s.inflight++
+	s.pending[*r] = ch
+	s.sentAt[*r] = time.Now()
+	s.mutex.Unlock()
+
+	if err := s.write(MessageRequest, EncodeChunkRequest(r)); err != nil {
+		s.close(err)
+	}
+
+	result := <-ch
+
+	return result.data, result.err
+}
+
+// authorize proves the knowledge of the swarm secret to the remote peer, once per session,
+// when the gorrent is private.
+func (s *session) authorize(infoHash gorrent.Sha1Hash) error {
+	secret, err := s.secrets.Secret(infoHash)
+	if err != nil {
+		return err
+	}
+
+	if len(secret) == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	for !s.closed && s.challenge == nil {
+		s.cond.Wait()
+	}
+
+	if s.closed {
+		defer s.mutex.Unlock()
+		return s.closeError()
+	}
+
+	if s.authorized[infoHash] {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	s.authorized[infoHash] = true
+	challenge := s.challenge
+	s.mutex.Unlock()
+
+	auth := &Auth{
+		InfoHash: infoHash,
+		Proof:    gorrent.NewProof(secret, infoHash, challenge),
+	}
+
+	if err := s.write(MessageAuth, EncodeAuth(auth)); err != nil {
+		s.close(err)
+
+		return err
+	}
+
+	return nil
+}
+
+// sendPeers shares addrs as known peers of the gorrent with the remote peer
+func (s *session) sendPeers(infoHash gorrent.Sha1Hash, addrs []gorrent.PeerAddr) error {
+	if err := s.authorize(infoHash); err != nil {
+		return err
+	}
+
+	pex := &Pex{
+		InfoHash:  infoHash,
+		PeerAddrs: addrs,
+	}
+
+	if err := s.write(MessagePex, EncodePex(pex)); err != nil {
+		s.close(err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *session) write(id MessageID, payload []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.readTimeout))
+	if err := WriteMessage(s.conn, id, payload); err != nil {
+		return err
+	}
+
+	// Only expect an answer in time when there is something to answer
+	s.conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+
+	return nil
+}
+
+func (s *session) readLoop() {
+	for {
+		msg, err := ReadMessage(s.conn)
+		if err != nil {
+			s.close(err)
+
+			return
+		}
+
+		switch msg.ID {
+		case MessageBlock, MessageReject:
+			r, data, err := DecodeChunkRequest(msg.Payload)
+			if err != nil {
+				s.close(err)
+
+				return
+			}
+
+			result := blockResult{data: data}
+			if msg.ID == MessageReject {
+				result = blockResult{err: ErrBlockRejected}
+			}
+
+			s.resolve(*r, result)
+		case MessageChallenge:
+			s.mutex.Lock()
+			s.challenge = msg.Payload
+			s.clearReadDeadline()
+			s.cond.Broadcast()
+			s.mutex.Unlock()
+		case MessagePex:
+			pex, err := DecodePex(msg.Payload)
+			if err != nil {
+				s.close(err)
+
+				return
+			}
+
+			s.mutex.Lock()
+			s.clearReadDeadline()
+			s.mutex.Unlock()
+
+			if s.onPeers != nil {
+				s.onPeers(pex.InfoHash, pex.PeerAddrs)
+			}
+		default:
+			log.Printf("session %s: ignoring unknown message %#x", s.addr, msg.ID)
+		}
+	}
+}
+
+// resolve hands the result to the pending request and updates the queue depth
+func (s *session) resolve(r ChunkRequest, result blockResult) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ch, ok := s.pending[r]
+	if !ok {
+		return
+	}
+
+	s.measure(time.Since(s.sentAt[r]), len(result.data))
+
+	delete(s.pending, r)
+	delete(s.sentAt, r)
+	s.inflight--
+
+	if s.inflight > 0 {
+		s.conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+	} else {
+		s.clearReadDeadline()
+	}
+
+	ch <- result
+	s.cond.Broadcast()
+}
+
+// clearReadDeadline removes the read deadline when no more answer is expected. Must be called with the mutex held.
+func (s *session) clearReadDeadline() {
+	if s.inflight == 0 {
+		s.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// measure updates the bandwidth and latency estimations, and resize the queue
+// to keep enough requests in flight to fill the bandwidth-delay product.
+// Must be called with the mutex held.
+func (s *session) measure(rtt time.Duration, n int) {
+	if s.minRTT == 0 || rtt < s.minRTT {
+		s.minRTT = rtt
+	}
+
+	s.windowBytes += n
+	elapsed := time.Since(s.windowStart)
+	if elapsed < rateWindow {
+		return
+	}
+
+	sample := float64(s.windowBytes) / elapsed.Seconds()
+	if s.rate == 0 {
+		s.rate = sample
+	} else {
+		s.rate = rateSmoothing*sample + (1-rateSmoothing)*s.rate
+	}
+
+	s.windowStart = time.Now()
+	s.windowBytes = 0
+
+	// Target twice the bandwidth-delay product so the queue can grow while the link is not saturated
+	bdp := s.rate * s.minRTT.Seconds()
+	depth := int(math.Ceil(2 * bdp / BlockSize))
+
+	if depth < s.minQueueDepth {
+		depth = s.minQueueDepth
+	}
+	if depth > s.maxQueueDepth {
+		depth = s.maxQueueDepth
+	}
+
+	s.queueDepth = depth
+}
+
+// close terminates the session, failing all the pending requests with err
+func (s *session) close(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.err = err
+	s.conn.Close()
+
+	for r, ch := range s.pending {
+		ch <- blockResult{err: err}
+		delete(s.pending, r)
+		delete(s.sentAt, r)
+	}
+	s.inflight = 0
+
+	s.cond.Broadcast()
+}
+
+func (s *session) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closed
+}
+
+// closeError returns the error which caused the session to close. Must be called with the mutex held.
+func (s *session) closeError() error {
+	if s.err != nil {
+		return s.err
+	}
+
+	return ErrSessionClosed
+}
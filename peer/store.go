@@ -3,6 +3,7 @@ package peer
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -14,6 +15,9 @@ import (
 
 var (
 	gorrentBucket = []byte("gorrent")
+
+	// ErrEntryNotFound is returned when updating a gorrent which is not in the store
+	ErrEntryNotFound = errors.New("gorrent entry not found")
 )
 
 const (
@@ -40,6 +44,7 @@ type GorrentStore interface {
 	Save(g *GorrentEntry) error
 	All() ([]*GorrentEntry, error)
 	Get(gorrent.Sha1Hash) (*GorrentEntry, error)
+	Update(infoHash gorrent.Sha1Hash, fn func(g *GorrentEntry) error) error
 }
 
 type gorrentStore struct {
@@ -58,6 +63,7 @@ type GorrentEntry struct {
 	Downloaded      uint64
 	Status          Status
 	PeerAddrs       []gorrent.PeerAddr
+	PeerAddrsSeen   map[string]time.Time
 	CompletedChunks []int64
 }
 
@@ -66,6 +72,46 @@ func (g *GorrentEntry) TmpFileName() string {
 	return fmt.Sprintf("%s.dat", g.Gorrent.InfoHash().HexString())
 }
 
+// Left returns the number of bytes remaining to download
+func (g *GorrentEntry) Left() uint64 {
+	total := g.Gorrent.TotalFileSize()
+	if g.Status == StatusCompleted || g.Downloaded >= total {
+		return 0
+	}
+
+	return total - g.Downloaded
+}
+
+// MergePeerAddrs adds addrs to the entry peers, and refresh the time they were last seen
+func (g *GorrentEntry) MergePeerAddrs(addrs []gorrent.PeerAddr, now time.Time) {
+	if g.PeerAddrsSeen == nil {
+		g.PeerAddrsSeen = make(map[string]time.Time)
+	}
+
+	for _, addr := range addrs {
+		if _, ok := g.PeerAddrsSeen[addr.String()]; !ok {
+			g.PeerAddrs = append(g.PeerAddrs, addr)
+		}
+
+		g.PeerAddrsSeen[addr.String()] = now
+	}
+}
+
+// ExpirePeerAddrs removes the peers which have not been seen for more than maxAge
+func (g *GorrentEntry) ExpirePeerAddrs(maxAge time.Duration, now time.Time) {
+	var alive []gorrent.PeerAddr
+
+	for _, addr := range g.PeerAddrs {
+		if now.Sub(g.PeerAddrsSeen[addr.String()]) <= maxAge {
+			alive = append(alive, addr)
+		} else {
+			delete(g.PeerAddrsSeen, addr.String())
+		}
+	}
+
+	g.PeerAddrs = alive
+}
+
 // NewStore creates a new peer store
 func NewStore(path string, mode os.FileMode) (GorrentStore, error) {
 	db, err := bolt.Open(path, mode, &bolt.Options{Timeout: 1 * time.Second})
@@ -100,6 +146,38 @@ func (s *gorrentStore) Save(g *GorrentEntry) error {
 	})
 }
 
+// Update atomically applies fn on the stored entry of infoHash, and saves it.
+// It returns ErrEntryNotFound when no entry exists for infoHash.
+func (s *gorrentStore) Update(infoHash gorrent.Sha1Hash, fn func(g *GorrentEntry) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(gorrentBucket)
+		if err != nil {
+			return err
+		}
+
+		v := bucket.Get(infoHash.Bytes())
+		if v == nil {
+			return ErrEntryNotFound
+		}
+
+		entry, err := s.decode(v)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+
+		data, err := s.encode(entry)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(infoHash.Bytes(), data)
+	})
+}
+
 func (s *gorrentStore) Get(infoHash gorrent.Sha1Hash) (*GorrentEntry, error) {
 	entry := &GorrentEntry{}
 
@@ -159,6 +237,45 @@ func (s *gorrentStore) All() ([]*GorrentEntry, error) {
 	return list, nil
 }
 
+// SwarmSecrets gives access to the gorrents swarm secrets
+type SwarmSecrets interface {
+	// Secret returns the swarm secret of the gorrent, or nil when its swarm is public
+	Secret(infoHash gorrent.Sha1Hash) ([]byte, error)
+}
+
+type storeSwarmSecrets struct {
+	store GorrentStore
+}
+
+var _ SwarmSecrets = &storeSwarmSecrets{}
+var _ SwarmSecrets = &noSwarmSecrets{}
+
+// NewStoreSwarmSecrets returns SwarmSecrets reading secrets from the gorrents in the store
+func NewStoreSwarmSecrets(store GorrentStore) SwarmSecrets {
+	return &storeSwarmSecrets{
+		store: store,
+	}
+}
+
+func (s *storeSwarmSecrets) Secret(infoHash gorrent.Sha1Hash) ([]byte, error) {
+	entry, err := s.store.Get(infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Gorrent == nil {
+		return nil, nil
+	}
+
+	return entry.Gorrent.Secret, nil
+}
+
+type noSwarmSecrets struct{}
+
+func (s *noSwarmSecrets) Secret(infoHash gorrent.Sha1Hash) ([]byte, error) {
+	return nil, nil
+}
+
 // Close close the store
 func (s *gorrentStore) Close() error {
 	return s.db.Close()
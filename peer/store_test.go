@@ -0,0 +1,51 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestGorrentEntryPeerAddrs(t *testing.T) {
+	now := time.Now()
+	p1 := gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1234)
+	p2 := gorrent.NewPeerAddr(net.ParseIP("10.0.0.2"), 1234)
+
+	t.Run("MergePeerAddrs adds new peers only once", func(t *testing.T) {
+		entry := &GorrentEntry{}
+		entry.MergePeerAddrs([]gorrent.PeerAddr{p1}, now)
+		entry.MergePeerAddrs([]gorrent.PeerAddr{p1, p2}, now)
+
+		if len(entry.PeerAddrs) != 2 || entry.PeerAddrs[0] != p1 || entry.PeerAddrs[1] != p2 {
+			t.Fatalf("Expected peers to be %v, got %v", []gorrent.PeerAddr{p1, p2}, entry.PeerAddrs)
+		}
+	})
+
+	t.Run("ExpirePeerAddrs removes peers not seen for too long", func(t *testing.T) {
+		entry := &GorrentEntry{}
+		entry.MergePeerAddrs([]gorrent.PeerAddr{p1, p2}, now.Add(-time.Hour))
+		entry.MergePeerAddrs([]gorrent.PeerAddr{p2}, now)
+
+		entry.ExpirePeerAddrs(time.Minute, now)
+
+		if len(entry.PeerAddrs) != 1 || entry.PeerAddrs[0] != p2 {
+			t.Fatalf("Expected peers to be %v, got %v", []gorrent.PeerAddr{p2}, entry.PeerAddrs)
+		}
+
+		if _, ok := entry.PeerAddrsSeen[p1.String()]; ok {
+			t.Fatalf("Expected %s to be forgotten", p1)
+		}
+	})
+
+	t.Run("ExpirePeerAddrs removes peers without a last seen time", func(t *testing.T) {
+		entry := &GorrentEntry{PeerAddrs: []gorrent.PeerAddr{p1}}
+
+		entry.ExpirePeerAddrs(time.Minute, now)
+
+		if len(entry.PeerAddrs) != 0 {
+			t.Fatalf("Expected no peers, got %v", entry.PeerAddrs)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package peer
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/daeMOn63/gorrent/buffer"
@@ -16,6 +18,13 @@ import (
 	"github.com/daeMOn63/gorrent/tracker"
 )
 
+const (
+	// DefaultParallelChunks is the default number of chunks downloaded at once
+	DefaultParallelChunks = 4
+	// BadPeerTimeout defines how long a peer sending corrupt blocks is left out of the downloads
+	BadPeerTimeout = 30 * time.Minute
+)
+
 var (
 	// ErrIntegrityCheckFailed is returned when the watcher fail to validate a file integrity
 	ErrIntegrityCheckFailed = errors.New("integrity check failed")
@@ -30,23 +39,29 @@ type Watcher interface {
 }
 
 type watcher struct {
-	store      GorrentStore
-	fs         fs.FileSystem
-	fileBuffer buffer.File
-	tracker    tracker.Client
-	peerClient Client
+	store          GorrentStore
+	fs             fs.FileSystem
+	fileBuffer     buffer.File
+	tracker        tracker.Client
+	peerClient     Client
+	parallelChunks int
+
+	mutex    sync.Mutex
+	badPeers map[gorrent.PeerAddr]time.Time
 }
 
 var _ Watcher = &watcher{}
 
-// NewWatcher creates a new gorrent watcher
-func NewWatcher(store GorrentStore, fs fs.FileSystem, fileBuffer buffer.File, tracker tracker.Client, peerClient Client) Watcher {
+// NewWatcher creates a new gorrent watcher, downloading up to parallelChunks chunks at once
+func NewWatcher(store GorrentStore, fs fs.FileSystem, fileBuffer buffer.File, tracker tracker.Client, peerClient Client, parallelChunks int) Watcher {
 	return &watcher{
-		store:      store,
-		fs:         fs,
-		fileBuffer: fileBuffer,
-		tracker:    tracker,
-		peerClient: peerClient,
+		store:          store,
+		fs:             fs,
+		fileBuffer:     fileBuffer,
+		tracker:        tracker,
+		peerClient:     peerClient,
+		parallelChunks: parallelChunks,
+		badPeers:       make(map[gorrent.PeerAddr]time.Time),
 	}
 }
 
@@ -168,21 +183,36 @@ func (w *watcher) processCheck(entry *GorrentEntry) error {
 		currentOffset += file.Length
 	}
 
-	entry.Status = StatusCompleted
-	return w.store.Save(entry)
+	return w.store.Update(entry.Gorrent.InfoHash(), func(g *GorrentEntry) error {
+		g.Status = StatusCompleted
+
+		return nil
+	})
 }
 
-func getMissingChunkID(entry *GorrentEntry) (int64, error) {
-	completedChunks := entry.CompletedChunks
-	if len(completedChunks) == 0 {
-		return 0, nil
+// getMissingChunkIDs returns up to max chunk IDs which are not downloaded yet
+func getMissingChunkIDs(entry *GorrentEntry, max int) ([]int64, error) {
+	if len(entry.CompletedChunks) >= len(entry.Gorrent.Pieces) {
+		return nil, ErrNoMoreChunk
+	}
+
+	completed := make(map[int64]bool, len(entry.CompletedChunks))
+	for _, chunkID := range entry.CompletedChunks {
+		completed[chunkID] = true
+	}
+
+	var missing []int64
+	for chunkID := int64(0); chunkID < int64(len(entry.Gorrent.Pieces)) && len(missing) < max; chunkID++ {
+		if !completed[chunkID] {
+			missing = append(missing, chunkID)
+		}
 	}
 
-	if len(completedChunks) == len(entry.Gorrent.Pieces) {
-		return 0, ErrNoMoreChunk
+	if len(missing) == 0 {
+		return nil, ErrNoMoreChunk
 	}
 
-	return completedChunks[len(completedChunks)-1] + 1, nil
+	return missing, nil
 }
 
 func (w *watcher) processDownloading(entry *GorrentEntry) error {
@@ -197,7 +227,7 @@ func (w *watcher) processDownloading(entry *GorrentEntry) error {
 	}
 	defer chunkedFile.Close()
 
-	chunkID, err := getMissingChunkID(entry)
+	chunkIDs, err := getMissingChunkIDs(entry, w.parallelChunks)
 	if err == ErrNoMoreChunk {
 		log.Printf("No more chunk to download for %s (%s)", entry.Name, entry.Gorrent.InfoHash().HexString())
 		entry.Status = StatusCheck
@@ -209,49 +239,222 @@ func (w *watcher) processDownloading(entry *GorrentEntry) error {
 		return err
 	}
 
-	log.Printf("Downloading chunk %d from %s (%s)", chunkID, entry.Name, entry.Gorrent.InfoHash().HexString())
+	log.Printf("Downloading chunks %v from %s (%s)", chunkIDs, entry.Name, entry.Gorrent.InfoHash().HexString())
+
+	// Download chunks concurrently, so requests to the peers can be pipelined
+	errs := make([]error, len(chunkIDs))
+	var wg sync.WaitGroup
+	for i, chunkID := range chunkIDs {
+		wg.Add(1)
+		go func(i int, chunkID int64) {
+			defer wg.Done()
+			errs[i] = w.downloadChunk(entry, chunkedFile, chunkID)
+		}(i, chunkID)
+	}
+	wg.Wait()
+
+	// Only update the download progress, as peers may have been merged in the meantime
+	return w.store.Update(entry.Gorrent.InfoHash(), func(g *GorrentEntry) error {
+		for i, chunkID := range chunkIDs {
+			if errs[i] != nil {
+				log.Printf("Failed downloading chunk %d: %s", chunkID, errs[i])
 
-	for _, peerAddr := range entry.PeerAddrs {
+				continue
+			}
 
-		request := &ChunkRequest{
-			InfoHash: entry.Gorrent.InfoHash(),
-			ChunkID:  chunkID,
+			g.Downloaded += chunkLength(g.Gorrent, chunkID)
+			g.CompletedChunks = append(g.CompletedChunks, chunkID)
+			log.Printf("Completed downloading chunk %d", chunkID)
 		}
 
-		log.Printf("Contacting peer %s", peerAddr.String())
-		data, err := w.peerClient.GetPiece(peerAddr, request, entry.Gorrent.PieceLength)
+		return nil
+	})
+}
+
+// chunkLength returns the number of bytes of file data held by given chunk, ignoring the last chunk padding
+func chunkLength(g *gorrent.Gorrent, chunkID int64) uint64 {
+	start := uint64(chunkID) * uint64(g.PieceLength)
+	if start+uint64(g.PieceLength) > g.TotalFileSize() {
+		return g.TotalFileSize() - start
+	}
+
+	return uint64(g.PieceLength)
+}
+
+// downloadChunk fetches, verifies and writes given chunk to the buffer file
+// When the chunk is corrupt, it is fetched again from each peer which served some of its blocks alone, until a valid
+// copy tells which peers sent corrupt blocks. Those are left out of the downloads for BadPeerTimeout.
+func (w *watcher) downloadChunk(entry *GorrentEntry, chunkedFile buffer.ChunkedFile, chunkID int64) error {
+	for {
+		data, served, err := w.fetchChunk(entry, chunkID, w.goodPeers(entry.PeerAddrs))
 		if err != nil {
-			log.Printf("Peer:GetPiece (%v) error: %s", peerAddr, err)
+			return err
+		}
 
-			continue
+		if w.validChunk(entry, chunkID, data) {
+			return chunkedFile.WriteChunk(chunkID, data)
 		}
 
-		hash := sha1.Sum(data)
-		if hash != entry.Gorrent.Pieces[chunkID] {
-			log.Printf("Integrity check failed for chunk %d, expected %v, got %v", chunkID, entry.Gorrent.Pieces[chunkID], hash)
+		sources := servingPeers(served)
+		if len(sources) == 1 {
+			w.markBadPeer(sources[0])
 
 			continue
 		}
 
-		if err := chunkedFile.WriteChunk(chunkID, data); err != nil {
-			log.Printf("Peer:WriteChunk (%v) error: %s", peerAddr, err)
+		var valid []byte
+		marked := false
+		for _, peerAddr := range sources {
+			alone, _, err := w.fetchChunk(entry, chunkID, []gorrent.PeerAddr{peerAddr})
+			if err != nil {
+				continue
+			}
+
+			if w.validChunk(entry, chunkID, alone) {
+				valid = alone
+				break
+			}
+
+			w.markBadPeer(peerAddr)
+			marked = true
+		}
+
+		if valid == nil {
+			// Each retry leaves at least one more peer out, unless none of them could be told corrupt
+			if !marked {
+				return ErrIntegrityCheckFailed
+			}
 
 			continue
 		}
 
-		entry.Downloaded += uint64(len(data))
-		entry.CompletedChunks = append(entry.CompletedChunks, chunkID)
+		for request, peerAddr := range served {
+			block := data[request.Offset : request.Offset+request.Length]
+			if !bytes.Equal(block, valid[request.Offset:request.Offset+request.Length]) {
+				w.markBadPeer(peerAddr)
+			}
+		}
 
-		if err := w.store.Save(entry); err != nil {
-			log.Printf("Store:Save error: %s", err)
+		return chunkedFile.WriteChunk(chunkID, valid)
+	}
+}
 
-			continue
+// servingPeers returns the distinct peers of served
+func servingPeers(served map[*ChunkRequest]gorrent.PeerAddr) []gorrent.PeerAddr {
+	seen := make(map[gorrent.PeerAddr]bool)
+	var peers []gorrent.PeerAddr
+	for _, peerAddr := range served {
+		if !seen[peerAddr] {
+			seen[peerAddr] = true
+			peers = append(peers, peerAddr)
 		}
+	}
+
+	return peers
+}
 
-		log.Printf("Completed downloading chunk %d", chunkID)
+// validChunk returns true when data matches the chunk hash
+func (w *watcher) validChunk(entry *GorrentEntry, chunkID int64, data []byte) bool {
+	hash := sha1.Sum(data)
+	if hash != entry.Gorrent.Pieces[chunkID] {
+		log.Printf("Integrity check failed for chunk %d, expected %v, got %v", chunkID, entry.Gorrent.Pieces[chunkID], hash)
+
+		return false
 	}
 
-	return nil
+	return true
+}
+
+// markBadPeer leaves peerAddr out of the downloads for BadPeerTimeout
+func (w *watcher) markBadPeer(peerAddr gorrent.PeerAddr) {
+	log.Printf("Peer %s sent corrupt blocks, ignoring it for %s", peerAddr, BadPeerTimeout)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.badPeers[peerAddr] = time.Now().Add(BadPeerTimeout)
+}
+
+// goodPeers returns the peers of addrs which did not send corrupt blocks recently
+func (w *watcher) goodPeers(addrs []gorrent.PeerAddr) []gorrent.PeerAddr {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := time.Now()
+	good := make([]gorrent.PeerAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if until, ok := w.badPeers[addr]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(w.badPeers, addr)
+		}
+
+		good = append(good, addr)
+	}
+
+	return good
+}
+
+// fetchChunk downloads all the blocks of given chunk, spreading them over peers, and returns them
+// along the peer which served each block request.
+// Each peer has its own workers pulling blocks from a shared queue, the peer client limits
+// how many of them are really in flight. A block failing on a peer is requeued for the
+// other ones, and the failing worker stops receiving new blocks.
+func (w *watcher) fetchChunk(entry *GorrentEntry, chunkID int64, peers []gorrent.PeerAddr) ([]byte, map[*ChunkRequest]gorrent.PeerAddr, error) {
+	requests := NewBlockRequests(entry.Gorrent.InfoHash(), chunkID, entry.Gorrent.PieceLength, BlockSize)
+
+	queue := make(chan *ChunkRequest, len(requests))
+	for _, r := range requests {
+		queue <- r
+	}
+
+	data := make([]byte, entry.Gorrent.PieceLength)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	remaining := len(requests)
+	served := make(map[*ChunkRequest]gorrent.PeerAddr)
+
+	for _, peerAddr := range peers {
+		for i := 0; i < len(requests); i++ {
+			wg.Add(1)
+			go func(peerAddr gorrent.PeerAddr) {
+				defer wg.Done()
+
+				for request := range queue {
+					block, err := w.peerClient.GetBlock(peerAddr, request)
+					if err == nil && int64(len(block)) != request.Length {
+						err = ErrInvalidBlock
+					}
+
+					if err != nil {
+						log.Printf("Peer:GetBlock (%v) error: %s", peerAddr, err)
+						queue <- request
+
+						return
+					}
+
+					mutex.Lock()
+					copy(data[request.Offset:], block)
+					served[request] = peerAddr
+					remaining--
+					if remaining == 0 {
+						close(queue)
+					}
+					mutex.Unlock()
+				}
+			}(peerAddr)
+		}
+	}
+
+	wg.Wait()
+
+	if remaining > 0 {
+		return nil, nil, fmt.Errorf("failed to download %d blocks of chunk %d from %s (%s)", remaining, chunkID, entry.Name, entry.Gorrent.InfoHash().HexString())
+	}
+
+	return data, served, nil
 }
 
 // processReady creates the temp file buffer for storing downloaded data
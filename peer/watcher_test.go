@@ -0,0 +1,77 @@
+package peer
+
+import (
+	"crypto/sha1"
+	"errors"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+// memoryChunkedFile keeps the written chunks in memory
+type memoryChunkedFile struct {
+	chunks map[int64][]byte
+}
+
+func (f *memoryChunkedFile) Size() int64 { return 0 }
+
+func (f *memoryChunkedFile) WriteChunk(chunkID int64, data []byte) error {
+	f.chunks[chunkID] = data
+	return nil
+}
+
+func (f *memoryChunkedFile) Read(size int64, offset int64) ([]byte, error) { return nil, nil }
+
+func (f *memoryChunkedFile) Close() error { return nil }
+
+func TestWatcherDownloadChunk(t *testing.T) {
+	t.Run("downloadChunk finds the peers sending corrupt blocks and leaves them out", func(t *testing.T) {
+		data := make([]byte, 2*BlockSize)
+		for i := range data {
+			data[i] = byte(i)
+		}
+
+		g := &gorrent.Gorrent{PieceLength: len(data), Pieces: []gorrent.Sha1Hash{sha1.Sum(data)}}
+		honest := gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1)
+		corrupt := gorrent.NewPeerAddr(net.ParseIP("10.0.0.2"), 2)
+		entry := &GorrentEntry{Name: "test", Gorrent: g, PeerAddrs: []gorrent.PeerAddr{honest, corrupt}}
+
+		// The honest peer leaves the last block to the corrupt one, until it served a block
+		corruptServed := int32(0)
+		client := &DummyClient{
+			GetBlockFunc: func(peerAddr gorrent.PeerAddr, r *ChunkRequest) ([]byte, error) {
+				if peerAddr == corrupt {
+					atomic.StoreInt32(&corruptServed, 1)
+					return make([]byte, r.Length), nil
+				}
+
+				if r.Offset > 0 && atomic.LoadInt32(&corruptServed) == 0 {
+					return nil, errors.New("busy")
+				}
+
+				return data[r.Offset : r.Offset+r.Length], nil
+			},
+		}
+
+		w := NewWatcher(nil, nil, nil, nil, client, 1).(*watcher)
+		file := &memoryChunkedFile{chunks: make(map[int64][]byte)}
+
+		for i := 0; i < 3; i++ {
+			file.chunks = make(map[int64][]byte)
+			if err := w.downloadChunk(entry, file, 0); err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			if reflect.DeepEqual(file.chunks[0], data) == false {
+				t.Fatalf("Expected chunk to be downloaded")
+			}
+		}
+
+		if peers := w.goodPeers(entry.PeerAddrs); reflect.DeepEqual(peers, []gorrent.PeerAddr{honest}) == false {
+			t.Fatalf("Expected good peers to be %v, got %v", []gorrent.PeerAddr{honest}, peers)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+const (
+	// MessageRequest is sent to request a block
+	MessageRequest MessageID = 0x1
+	// MessageBlock is sent in response to a MessageRequest, with the block data
+	MessageBlock MessageID = 0x2
+	// MessageReject is sent in response to a MessageRequest when the block cannot be served
+	MessageReject MessageID = 0x3
+	// MessageChallenge is sent by the server when a connection is opened, with a random challenge
+	MessageChallenge MessageID = 0x4
+	// MessageAuth is sent by the client to prove its knowledge of a gorrent swarm secret
+	MessageAuth MessageID = 0x5
+	// MessagePex is sent by the client with its known peers of a gorrent, the server answers with its own
+	MessagePex MessageID = 0x6
+
+	// ChallengeSize defines the number of bytes of a MessageChallenge payload
+	ChallengeSize = 32
+
+	// MaxMessageSize defines the maximum size of a peer message
+	MaxMessageSize = 1024 * 1024
+)
+
+var (
+	// ErrMessageTooLarge is returned when a message exceeds MaxMessageSize
+	ErrMessageTooLarge = errors.New("message too large")
+	// ErrEmptyMessage is returned when a message does not even contain its ID
+	ErrEmptyMessage = errors.New("empty message")
+
+	chunkRequestSize = binary.Size(ChunkRequest{})
+)
+
+// MessageID identifies a peer protocol message
+type MessageID uint8
+
+// Message is a peer protocol message
+// On the wire, messages are prefixed by their length as a big endian uint32, followed by the ID byte and the payload.
+type Message struct {
+	ID      MessageID
+	Payload []byte
+}
+
+// WriteMessage writes a framed message on w
+func WriteMessage(w io.Writer, id MessageID, payload []byte) error {
+	if len(payload)+1 > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 5+len(payload)))
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)+1))
+	buf.WriteByte(byte(id))
+	buf.Write(payload)
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// ReadMessage reads a framed message from r
+func ReadMessage(r io.Reader) (*Message, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	if length == 0 {
+		return nil, ErrEmptyMessage
+	}
+
+	if length > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		ID:      MessageID(buf[0]),
+		Payload: buf[1:],
+	}, nil
+}
+
+// Auth is the payload of a MessageAuth, proving the knowledge of the InfoHash swarm secret
+type Auth struct {
+	InfoHash gorrent.Sha1Hash
+	Proof    gorrent.Proof
+}
+
+// EncodeAuth returns the binary representation of the Auth
+func EncodeAuth(a *Auth) []byte {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, a)
+
+	return buf.Bytes()
+}
+
+// DecodeAuth reads an Auth from b
+func DecodeAuth(b []byte) (*Auth, error) {
+	a := &Auth{}
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, a); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Pex is the payload of a MessagePex, listing known peers of a gorrent
+type Pex struct {
+	InfoHash  gorrent.Sha1Hash
+	PeerAddrs []gorrent.PeerAddr
+}
+
+// EncodePex returns the binary representation of the Pex
+func EncodePex(p *Pex) []byte {
+	return append(p.InfoHash[:], gorrent.EncodePeerAddrs(p.PeerAddrs)...)
+}
+
+// DecodePex reads a Pex from b
+func DecodePex(b []byte) (*Pex, error) {
+	p := &Pex{}
+	if len(b) < len(p.InfoHash) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	copy(p.InfoHash[:], b)
+
+	addrs, _, err := gorrent.DecodePeerAddrs(b[len(p.InfoHash):])
+	if err != nil {
+		return nil, err
+	}
+	p.PeerAddrs = addrs
+
+	return p, nil
+}
+
+// EncodeChunkRequest returns the binary representation of the ChunkRequest
+func EncodeChunkRequest(r *ChunkRequest) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, chunkRequestSize))
+	binary.Write(buf, binary.BigEndian, r)
+
+	return buf.Bytes()
+}
+
+// DecodeChunkRequest reads a ChunkRequest from the beginning of b and returns it along the remaining bytes
+func DecodeChunkRequest(b []byte) (*ChunkRequest, []byte, error) {
+	if len(b) < chunkRequestSize {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	r := &ChunkRequest{}
+	if err := binary.Read(bytes.NewReader(b[:chunkRequestSize]), binary.BigEndian, r); err != nil {
+		return nil, nil, err
+	}
+
+	return r, b[chunkRequestSize:], nil
+}
@@ -1,10 +1,46 @@
 package actions
 
+import (
+	"encoding"
+	"errors"
+)
+
+var (
+	// ErrInvalidPayload is returned when an action or response payload cannot be decoded
+	ErrInvalidPayload = errors.New("invalid payload")
+)
+
+const (
+	// MaxPayloadSize defines the maximum size of framed actions and responses, so they always fit a single safe UDP datagram
+	MaxPayloadSize = 1024
+	// MaxStreamResponseSize defines the maximum size of responses sent over stream transports, like TCP or HTTP
+	MaxStreamResponseSize = 64 * 1024
+)
+
 // Actions
 const (
 	AnnounceID ID = 0x1
+	ScrapeID   ID = 0x2
+	ConnectID  ID = 0x3
+	ErrorID    ID = 0x4
 )
 
+// NewResponse returns an empty response of the action with given ID, or nil when unknown
+func NewResponse(id ID) encoding.BinaryUnmarshaler {
+	switch id {
+	case AnnounceID:
+		return &AnnounceResponse{}
+	case ScrapeID:
+		return &ScrapeResponse{}
+	case ConnectID:
+		return &ConnectResponse{}
+	case ErrorID:
+		return &Error{}
+	}
+
+	return nil
+}
+
 // ID define type for holding action ids
 type ID uint8
 
@@ -1,10 +1,20 @@
 package actions
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
 	"github.com/daeMOn63/gorrent/gorrent"
 )
 
 const (
+	// MaxProofAge defines how long an announce proof stays valid after its timestamp
+	MaxProofAge = 5 * time.Minute
+
+	// AnnounceEventNone is sent on regular announces, when the client state did not change
+	AnnounceEventNone AnnounceEvent = 0x0
 	// AnnounceEventStarted is sent when the client is starting downloading the gorrent
 	AnnounceEventStarted AnnounceEvent = 0x1
 	// AnnounceEventStopped is sent when the client cancel or pause the gorrent download
@@ -15,6 +25,7 @@ const (
 
 var (
 	eventNamesMap = map[AnnounceEvent]string{
+		AnnounceEventNone:      "none",
 		AnnounceEventStarted:   "started",
 		AnnounceEventStopped:   "stopped",
 		AnnounceEventCompleted: "completed",
@@ -30,20 +41,157 @@ func (e AnnounceEvent) Name() string {
 }
 
 // AnnounceStatus holds information about the current download state
+// Clients with nothing Left to download are seeders, the others leechers.
 type AnnounceStatus struct {
 	Downloaded uint64
 	Uploaded   uint64
+	Left       uint64
 }
 
 // Announce holds announce action data
+// ConnectionID must have been obtained from a Connect action, from the same address.
+// Timestamp and Proof are only required on private gorrents, to prove the peer knows the swarm secret.
+// NumWant is the number of peers the peer wants in the response, 0 letting the tracker choose.
 type Announce struct {
-	InfoHash gorrent.Sha1Hash
-	Peer     gorrent.Peer
-	Status   AnnounceStatus
-	Event    AnnounceEvent
+	ConnectionID uint64
+	InfoHash     gorrent.Sha1Hash
+	Peer         gorrent.Peer
+	Status       AnnounceStatus
+	Event        AnnounceEvent
+	Timestamp    int64
+	Proof        gorrent.Proof
+	NumWant      uint16
 }
 
 // ID contains the action identifier
 func (a *Announce) ID() ID {
 	return AnnounceID
 }
+
+// Sign sets the announce timestamp and the proof of knowledge of the swarm secret
+func (a *Announce) Sign(secret []byte, now time.Time) {
+	a.Timestamp = now.Unix()
+	a.Proof = gorrent.NewProof(secret, a.InfoHash, a.proofChallenge())
+}
+
+// VerifyProof returns true when the announce proof is valid for given secret and not expired
+func (a *Announce) VerifyProof(secret []byte, now time.Time) bool {
+	age := now.Sub(time.Unix(a.Timestamp, 0))
+	if age > MaxProofAge || age < -MaxProofAge {
+		return false
+	}
+
+	return gorrent.VerifyProof(a.Proof, secret, a.InfoHash, a.proofChallenge())
+}
+
+// proofChallenge binds the proof to the connection, the announcing peer and address, its status, event and the timestamp
+// so none of them can be altered without invalidating the proof.
+func (a *Announce) proofChallenge() []byte {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, a.ConnectionID)
+	buf.Write(a.Peer.ID[:])
+	buf.Write(a.Peer.IPAddr[:])
+	binary.Write(buf, binary.BigEndian, a.Peer.Port)
+	binary.Write(buf, binary.BigEndian, a.Status)
+	binary.Write(buf, binary.BigEndian, a.Event)
+	binary.Write(buf, binary.BigEndian, a.Timestamp)
+
+	return buf.Bytes()
+}
+
+// AnnounceResponse holds the peers returned on an announce, along the announce intervals chosen by the tracker
+// On the wire, Interval and MinInterval are big endian uint32 milliseconds, followed by the peers
+// encoded with gorrent.EncodePeerAddrs, separating IPv4 and IPv6 addresses.
+type AnnounceResponse struct {
+	// Interval is the delay peers should wait before their next announce
+	Interval time.Duration
+	// MinInterval is the minimum delay peers must wait before their next announce
+	MinInterval time.Duration
+	Peers       []gorrent.PeerAddr
+
+	// peersSize is the encoded size of the first sizedPeers peers, so growing responses are not measured over and over
+	peersSize  int
+	sizedPeers int
+}
+
+// MarshalBinary returns the binary representation of the response
+func (r *AnnounceResponse) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, uint32(r.Interval/time.Millisecond))
+	binary.Write(buf, binary.BigEndian, uint32(r.MinInterval/time.Millisecond))
+	buf.Write(gorrent.EncodePeerAddrs(r.Peers))
+
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON returns the JSON representation of the response, with intervals in milliseconds and ip:port peers
+func (r *AnnounceResponse) MarshalJSON() ([]byte, error) {
+	peers := make([]string, 0, len(r.Peers))
+	for _, addr := range r.Peers {
+		peers = append(peers, addr.String())
+	}
+
+	return json.Marshal(struct {
+		Interval    int64    `json:"interval"`
+		MinInterval int64    `json:"minInterval"`
+		Peers       []string `json:"peers"`
+	}{
+		Interval:    int64(r.Interval / time.Millisecond),
+		MinInterval: int64(r.MinInterval / time.Millisecond),
+		Peers:       peers,
+	})
+}
+
+// Size returns the length of the binary representation of the response
+// Only the peers appended since the previous call are measured, Peers being expected to only grow meanwhile.
+func (r *AnnounceResponse) Size() int {
+	if r.sizedPeers > len(r.Peers) {
+		r.peersSize, r.sizedPeers = 0, 0
+	}
+
+	for _, addr := range r.Peers[r.sizedPeers:] {
+		r.peersSize += peerAddrSize(addr)
+	}
+	r.sizedPeers = len(r.Peers)
+
+	return 8 + 2 + 2 + r.peersSize
+}
+
+// AddPeer appends addr to the peers, unless the response would grow over maxSize, and reports whether it was added
+func (r *AnnounceResponse) AddPeer(addr gorrent.PeerAddr, maxSize int) bool {
+	if r.Size()+peerAddrSize(addr) > maxSize {
+		return false
+	}
+
+	r.Peers = append(r.Peers, addr)
+
+	return true
+}
+
+// peerAddrSize returns the size of the compact representation of addr
+func peerAddrSize(addr gorrent.PeerAddr) int {
+	if addr.IsIPv4() {
+		return gorrent.CompactIPv4Size
+	}
+
+	return gorrent.CompactIPv6Size
+}
+
+// UnmarshalBinary reads the response from its binary representation
+func (r *AnnounceResponse) UnmarshalBinary(b []byte) error {
+	if len(b) < 8 {
+		return ErrInvalidPayload
+	}
+
+	peers, rest, err := gorrent.DecodePeerAddrs(b[8:])
+	if err != nil || len(rest) != 0 {
+		return ErrInvalidPayload
+	}
+
+	r.Interval = time.Duration(binary.BigEndian.Uint32(b)) * time.Millisecond
+	r.MinInterval = time.Duration(binary.BigEndian.Uint32(b[4:])) * time.Millisecond
+	r.Peers = peers
+	r.peersSize, r.sizedPeers = 0, 0
+
+	return nil
+}
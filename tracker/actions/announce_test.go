@@ -0,0 +1,148 @@
+package actions
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestAnnounceResponse(t *testing.T) {
+	t.Run("AnnounceResponse encodes the intervals and both IPv4 and IPv6 peers", func(t *testing.T) {
+		r := &AnnounceResponse{
+			Interval:    30 * time.Second,
+			MinInterval: 10 * time.Second,
+			Peers: []gorrent.PeerAddr{
+				gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1),
+				gorrent.NewPeerAddr(net.ParseIP("2001:db8::2"), 2),
+				gorrent.NewPeerAddr(net.ParseIP("10.0.0.3"), 3),
+			},
+		}
+
+		b, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedLen := 8 + 2 + 2*gorrent.CompactIPv4Size + 2 + gorrent.CompactIPv6Size
+		if len(b) != expectedLen {
+			t.Fatalf("Expected len to be %d, got %d", expectedLen, len(b))
+		}
+
+		decoded := &AnnounceResponse{}
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.Interval != r.Interval || decoded.MinInterval != r.MinInterval {
+			t.Fatalf("Expected intervals to be %s / %s, got %s / %s", r.Interval, r.MinInterval, decoded.Interval, decoded.MinInterval)
+		}
+
+		expectedPeers := []gorrent.PeerAddr{r.Peers[0], r.Peers[2], r.Peers[1]}
+		if reflect.DeepEqual(decoded.Peers, expectedPeers) == false {
+			t.Fatalf("Expected peers to be %v, got %v", expectedPeers, decoded.Peers)
+		}
+	})
+
+	t.Run("AnnounceResponse fails on truncated payload", func(t *testing.T) {
+		decoded := &AnnounceResponse{}
+		if err := decoded.UnmarshalBinary([]byte{0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 10, 0}); err != ErrInvalidPayload {
+			t.Fatalf("Expected err to be %s, got %v", ErrInvalidPayload, err)
+		}
+	})
+}
+
+func TestAnnounceResponseAddPeer(t *testing.T) {
+	t.Run("AddPeer never grows the response over maxSize", func(t *testing.T) {
+		r := &AnnounceResponse{}
+		maxSize := 12 + 2*gorrent.CompactIPv4Size + 4
+
+		if !r.AddPeer(gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1), maxSize) {
+			t.Fatalf("Expected first IPv4 peer to be added")
+		}
+
+		if r.AddPeer(gorrent.NewPeerAddr(net.ParseIP("2001:db8::2"), 2), maxSize) {
+			t.Fatalf("Expected IPv6 peer not to be added")
+		}
+
+		if !r.AddPeer(gorrent.NewPeerAddr(net.ParseIP("10.0.0.3"), 3), maxSize) {
+			t.Fatalf("Expected second IPv4 peer to be added")
+		}
+
+		b, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b) != r.Size() || len(b) > maxSize {
+			t.Fatalf("Expected len to be %d and below %d, got %d", r.Size(), maxSize, len(b))
+		}
+	})
+
+	t.Run("Size accounts for the peers appended or removed outside AddPeer", func(t *testing.T) {
+		r := &AnnounceResponse{}
+		r.AddPeer(gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 1), MaxPayloadSize)
+		r.Peers = append(r.Peers, gorrent.NewPeerAddr(net.ParseIP("2001:db8::2"), 2))
+
+		expectedSize := 12 + gorrent.CompactIPv4Size + gorrent.CompactIPv6Size
+		if size := r.Size(); size != expectedSize {
+			t.Fatalf("Expected size to be %d, got %d", expectedSize, size)
+		}
+
+		r.Peers = r.Peers[:1]
+
+		expectedSize = 12 + gorrent.CompactIPv4Size
+		if size := r.Size(); size != expectedSize {
+			t.Fatalf("Expected size to be %d, got %d", expectedSize, size)
+		}
+	})
+}
+
+func TestAnnounceProof(t *testing.T) {
+	t.Run("VerifyProof accepts fresh proofs from the same secret", func(t *testing.T) {
+		a := &Announce{InfoHash: gorrent.RandomSha1Hash()}
+		now := time.Now()
+		a.Sign([]byte("secret"), now)
+
+		if !a.VerifyProof([]byte("secret"), now) {
+			t.Fatalf("Expected proof to be valid")
+		}
+
+		if a.VerifyProof([]byte("other"), now) {
+			t.Fatalf("Expected proof to be invalid with another secret")
+		}
+
+		if a.VerifyProof([]byte("secret"), now.Add(2*MaxProofAge)) {
+			t.Fatalf("Expected proof to be expired")
+		}
+	})
+
+	t.Run("VerifyProof rejects proofs when signed fields are altered", func(t *testing.T) {
+		alterations := map[string]func(a *Announce){
+			"ConnectionID": func(a *Announce) { a.ConnectionID++ },
+			"PeerAddr":     func(a *Announce) { a.Peer.IPAddr[15]++ },
+			"Port":         func(a *Announce) { a.Peer.Port++ },
+			"Event":        func(a *Announce) { a.Event = AnnounceEventStopped },
+			"Status":       func(a *Announce) { a.Status.Left = 0 },
+		}
+
+		now := time.Now()
+		for field, alter := range alterations {
+			a := &Announce{
+				ConnectionID: 42,
+				InfoHash:     gorrent.RandomSha1Hash(),
+				Peer:         *gorrent.NewPeer("peer", net.ParseIP("10.0.0.1"), 6881),
+				Status:       AnnounceStatus{Left: 100},
+				Event:        AnnounceEventStarted,
+			}
+			a.Sign([]byte("secret"), now)
+			alter(a)
+
+			if a.VerifyProof([]byte("secret"), now) {
+				t.Fatalf("Expected proof to be invalid when %s is altered", field)
+			}
+		}
+	})
+}
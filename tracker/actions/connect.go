@@ -0,0 +1,45 @@
+package actions
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	// ConnectionIDLifetime defines how long a connection ID stays valid, at least, after it was issued
+	ConnectionIDLifetime = 2 * time.Minute
+)
+
+// Connect asks the tracker for a connection ID, required on announces
+// It has no payload, the connection ID being bound to the address the action is received from.
+type Connect struct{}
+
+// ID contains the action identifier
+func (c *Connect) ID() ID {
+	return ConnectID
+}
+
+// ConnectResponse holds the connection ID issued by the tracker
+// On the wire, it is the connection ID as a big endian uint64.
+type ConnectResponse struct {
+	ConnectionID uint64 `json:"connectionID"`
+}
+
+// MarshalBinary returns the binary representation of the response
+func (r *ConnectResponse) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, r.ConnectionID)
+
+	return b, nil
+}
+
+// UnmarshalBinary reads the response from its binary representation
+func (r *ConnectResponse) UnmarshalBinary(b []byte) error {
+	if len(b) != 8 {
+		return ErrInvalidPayload
+	}
+
+	r.ConnectionID = binary.BigEndian.Uint64(b)
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package actions
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrorCode tells why the tracker failed to handle an action
+type ErrorCode uint16
+
+// Error codes
+const (
+	ErrorCodeInternal ErrorCode = iota + 1
+	ErrorCodeUnsupportedVersion
+	ErrorCodeMalformedAction
+	ErrorCodeUnknownAction
+	ErrorCodeInvalidConnectionID
+	ErrorCodeInvalidProof
+	ErrorCodeUnregisteredInfoHash
+	ErrorCodeTooManyInfoHashes
+	ErrorCodeRateLimited
+)
+
+// maxErrorMessageSize keeps error responses in a single datagram
+const maxErrorMessageSize = MaxPayloadSize - FrameOverhead - 2
+
+// Error is both an error returned by handlers, and the response the tracker sends on failures
+// On the wire, it is the code as a big endian uint16 followed by the message.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// NewError returns an Error with the given code and message
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// ErrorOf returns err as an Error, handler failures without one being internal errors
+func ErrorOf(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	return NewError(ErrorCodeInternal, err.Error())
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches the errors with the same code and message, so errors received from a tracker match the sentinel ones
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+
+	return ok && *t == *e
+}
+
+// ID contains the action identifier
+func (e *Error) ID() ID {
+	return ErrorID
+}
+
+// MarshalBinary returns the binary representation of the error, truncating long messages
+func (e *Error) MarshalBinary() ([]byte, error) {
+	message := e.Message
+	if len(message) > maxErrorMessageSize {
+		message = message[:maxErrorMessageSize]
+	}
+
+	b := make([]byte, 2, 2+len(message))
+	binary.BigEndian.PutUint16(b, uint16(e.Code))
+
+	return append(b, message...), nil
+}
+
+// UnmarshalBinary reads the error from its binary representation
+func (e *Error) UnmarshalBinary(b []byte) error {
+	if len(b) < 2 {
+		return ErrInvalidPayload
+	}
+
+	e.Code = ErrorCode(binary.BigEndian.Uint16(b))
+	e.Message = string(b[2:])
+
+	return nil
+}
@@ -1,16 +1,56 @@
 package actions
 
-import "errors"
+import (
+	"net"
+	"sync"
+)
 
 var (
 	// ErrNoHandler is returned when no handler can be found on the router with this
 	// action ID.
-	ErrNoHandler = errors.New("no handler for this action")
+	ErrNoHandler = NewError(ErrorCodeUnknownAction, "no handler for this action")
 )
 
+// Source describes where an action has been received from
+// MaxResponseSize is the maximum size of the framed response the source can receive, 0 meaning MaxPayloadSize.
+type Source struct {
+	Addr            net.Addr
+	MaxResponseSize int
+}
+
+// ResponseSize returns the maximum size of the response payload the source can receive, once framed
+func (s Source) ResponseSize() int {
+	if s.MaxResponseSize <= 0 {
+		return MaxPayloadSize - FrameOverhead
+	}
+
+	return s.MaxResponseSize - FrameOverhead
+}
+
+// IP returns the IP address the action has been received from, or nil when unknown
+func (s Source) IP() net.IP {
+	switch addr := s.Addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
 // Handler is the interface for all action handlers
 type Handler interface {
-	Handle(action Action) ([]byte, error)
+	Handle(src Source, action Action) ([]byte, error)
 }
 
 // Router is a Handler where sub Handlers can be registered
@@ -20,8 +60,9 @@ type Router interface {
 }
 
 // Router defines an action Router, where action handlers
-// can be registered for each action IDs
+// can be registered for each action IDs, even while actions are being handled
 type router struct {
+	mutex  sync.RWMutex
 	routes map[ID]Handler
 }
 
@@ -38,30 +79,34 @@ func NewRouter() Router {
 
 // Register add a new handler for given action ID
 func (r *router) Register(id ID, handler Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	r.routes[id] = handler
 }
 
 // Handle calls the associated action Handler from the given action's ID
-func (r *router) Handle(action Action) ([]byte, error) {
-	var handler Handler
-	var ok bool
+func (r *router) Handle(src Source, action Action) ([]byte, error) {
+	r.mutex.RLock()
+	handler, ok := r.routes[action.ID()]
+	r.mutex.RUnlock()
 
-	if handler, ok = r.routes[action.ID()]; !ok {
+	if !ok {
 		return nil, ErrNoHandler
 	}
 
-	return handler.Handle(action)
+	return handler.Handle(src, action)
 }
 
 // DummyRouter provides a configurable Router
 type DummyRouter struct {
-	HandleFunc   func(action Action) ([]byte, error)
+	HandleFunc   func(src Source, action Action) ([]byte, error)
 	RegisterFunc func(ID, Handler)
 }
 
 // Handle calls HandleFunc
-func (d *DummyRouter) Handle(action Action) ([]byte, error) {
-	return d.HandleFunc(action)
+func (d *DummyRouter) Handle(src Source, action Action) ([]byte, error) {
+	return d.HandleFunc(src, action)
 }
 
 // Register calls RegisterFunc
@@ -71,10 +116,10 @@ func (d *DummyRouter) Register(id ID, handler Handler) {
 
 // DummyHandler provides a configurable Handler
 type DummyHandler struct {
-	HandleFunc func(action Action) ([]byte, error)
+	HandleFunc func(src Source, action Action) ([]byte, error)
 }
 
 // Handle calls HandleFunc
-func (d *DummyHandler) Handle(action Action) ([]byte, error) {
-	return d.HandleFunc(action)
+func (d *DummyHandler) Handle(src Source, action Action) ([]byte, error) {
+	return d.HandleFunc(src, action)
 }
@@ -2,7 +2,9 @@ package actions
 
 import (
 	"errors"
+	"net"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -11,28 +13,33 @@ func TestRouter(t *testing.T) {
 		expectedAction := &DummyAction{IDVar: 1}
 		expectedOut := []byte("abc")
 		expectedErr := errors.New("handlererr")
+		expectedSrc := Source{Addr: &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}}
 
 		r := NewRouter()
 
 		r.Register(ID(1), &DummyHandler{
-			HandleFunc: func(action Action) ([]byte, error) {
+			HandleFunc: func(src Source, action Action) ([]byte, error) {
 				if reflect.DeepEqual(action, expectedAction) == false {
 					t.Fatalf("Expected action to be %#v, got %#v", expectedAction, action)
 				}
 
+				if reflect.DeepEqual(src, expectedSrc) == false {
+					t.Fatalf("Expected src to be %#v, got %#v", expectedSrc, src)
+				}
+
 				return expectedOut, expectedErr
 			},
 		})
 
 		r.Register(ID(2), &DummyHandler{
-			HandleFunc: func(action Action) ([]byte, error) {
+			HandleFunc: func(src Source, action Action) ([]byte, error) {
 				t.Fatalf("Call was not expected")
 
 				return nil, nil
 			},
 		})
 
-		out, err := r.Handle(expectedAction)
+		out, err := r.Handle(expectedSrc, expectedAction)
 		if reflect.DeepEqual(out, expectedOut) == false {
 			t.Fatalf("Expected out to be %v, got %v", expectedOut, out)
 		}
@@ -45,7 +52,7 @@ func TestRouter(t *testing.T) {
 		r := NewRouter()
 		a := &DummyAction{IDVar: 1}
 
-		out, err := r.Handle(a)
+		out, err := r.Handle(Source{}, a)
 		if out != nil {
 			t.Fatalf("Expected out to be nil, got %v", out)
 		}
@@ -54,6 +61,52 @@ func TestRouter(t *testing.T) {
 			t.Fatalf("Expected err to be %s, got %s", ErrNoHandler, err)
 		}
 	})
+
+	t.Run("Router can register handlers while handling actions", func(t *testing.T) {
+		r := NewRouter()
+		h := &DummyHandler{
+			HandleFunc: func(src Source, action Action) ([]byte, error) {
+				return nil, nil
+			},
+		}
+		r.Register(1, h)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func(id ID) {
+				defer wg.Done()
+				r.Register(id, h)
+			}(ID(i + 2))
+			go func() {
+				defer wg.Done()
+				if _, err := r.Handle(Source{}, &DummyAction{IDVar: 1}); err != nil {
+					t.Errorf("Expected no error, got %s", err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestSource(t *testing.T) {
+	t.Run("IP returns the source address IP", func(t *testing.T) {
+		expectedIP := net.ParseIP("2001:db8::1")
+
+		for _, addr := range []net.Addr{
+			&net.UDPAddr{IP: expectedIP, Port: 1},
+			&net.TCPAddr{IP: expectedIP, Port: 2},
+			&net.IPAddr{IP: expectedIP},
+		} {
+			if ip := (Source{Addr: addr}).IP(); !ip.Equal(expectedIP) {
+				t.Fatalf("Expected ip of %s to be %s, got %s", addr, expectedIP, ip)
+			}
+		}
+
+		if ip := (Source{}).IP(); ip != nil {
+			t.Fatalf("Expected ip to be nil, got %s", ip)
+		}
+	})
 }
 
 func TestDummyRouter(t *testing.T) {
@@ -65,7 +118,7 @@ func TestDummyRouter(t *testing.T) {
 		expectedErr := errors.New("handlerr")
 
 		d := &DummyRouter{
-			HandleFunc: func(action Action) ([]byte, error) {
+			HandleFunc: func(src Source, action Action) ([]byte, error) {
 				if reflect.DeepEqual(action, expectedAction) == false {
 					t.Fatalf("Expected action to be %#v, got %#v", expectedAction, action)
 				}
@@ -74,7 +127,7 @@ func TestDummyRouter(t *testing.T) {
 			},
 		}
 
-		out, err := d.Handle(expectedAction)
+		out, err := d.Handle(Source{}, expectedAction)
 		if err != expectedErr {
 			t.Fatalf("Expected err to be %s, got %s", expectedErr, err)
 		}
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// ProtocolVersion is the version of the tracker wire protocol, sent first in every action and response
+	// Its high bit is set, out of the action IDs range, so frames of the unversioned protocol, starting with
+	// their action ID, are told apart as unsupported versions.
+	ProtocolVersion uint8 = 0x81
+	// HeaderSize is the size of the Header, in front of the action ID and its payload
+	HeaderSize = 5
+	// FrameOverhead is the size taken in front of each payload, by the header and the action ID
+	FrameOverhead = HeaderSize + 1
+)
+
+var (
+	// ErrUnsupportedVersion is returned when an action or response is not of the ProtocolVersion
+	ErrUnsupportedVersion = NewError(ErrorCodeUnsupportedVersion, "unsupported protocol version")
+	// ErrInvalidHeader is returned when a frame is too short to hold a header
+	ErrInvalidHeader = NewError(ErrorCodeMalformedAction, "invalid header")
+)
+
+// Header starts every action and response
+// On the wire, it is the version byte followed by the transaction ID as a big endian uint32.
+// The tracker echoes the transaction ID chosen by the client in the response, so responses can be told apart.
+type Header struct {
+	Version       uint8
+	TransactionID uint32
+}
+
+// NewHeader returns a header of the current ProtocolVersion
+func NewHeader(transactionID uint32) Header {
+	return Header{
+		Version:       ProtocolVersion,
+		TransactionID: transactionID,
+	}
+}
+
+// Frame returns the header followed by the action ID and the payload
+func (h Header) Frame(id ID, payload []byte) []byte {
+	frame := make([]byte, FrameOverhead, FrameOverhead+len(payload))
+	frame[0] = h.Version
+	binary.BigEndian.PutUint32(frame[1:HeaderSize], h.TransactionID)
+	frame[HeaderSize] = uint8(id)
+
+	return append(frame, payload...)
+}
+
+// ParseHeader reads the header of a frame, and returns it along the rest of the frame, starting at the action ID
+// The header is returned even when its version is unsupported, so an error response can be sent to the client.
+func ParseHeader(frame []byte) (Header, []byte, error) {
+	if len(frame) < HeaderSize {
+		return Header{}, nil, ErrInvalidHeader
+	}
+
+	h := Header{
+		Version:       frame[0],
+		TransactionID: binary.BigEndian.Uint32(frame[1:HeaderSize]),
+	}
+
+	if h.Version != ProtocolVersion {
+		return h, nil, ErrUnsupportedVersion
+	}
+
+	return h, frame[HeaderSize:], nil
+}
@@ -0,0 +1,90 @@
+package actions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	t.Run("ParseHeader reads the header of a Frame", func(t *testing.T) {
+		frame := NewHeader(42).Frame(ConnectID, []byte{1, 2})
+
+		header, body, err := ParseHeader(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header != NewHeader(42) {
+			t.Fatalf("Expected header to be %#v, got %#v", NewHeader(42), header)
+		}
+
+		expectedBody := []byte{byte(ConnectID), 1, 2}
+		if reflect.DeepEqual(body, expectedBody) == false {
+			t.Fatalf("Expected body to be %v, got %v", expectedBody, body)
+		}
+	})
+
+	t.Run("ParseHeader fails on short frames", func(t *testing.T) {
+		if _, _, err := ParseHeader([]byte{ProtocolVersion, 0}); err != ErrInvalidHeader {
+			t.Fatalf("Expected err to be %s, got %v", ErrInvalidHeader, err)
+		}
+	})
+
+	t.Run("ParseHeader returns the header of unsupported versions", func(t *testing.T) {
+		header, _, err := ParseHeader([]byte{ProtocolVersion + 1, 0, 0, 0, 7, byte(ConnectID)})
+		if err != ErrUnsupportedVersion {
+			t.Fatalf("Expected err to be %s, got %v", ErrUnsupportedVersion, err)
+		}
+
+		if header.TransactionID != 7 {
+			t.Fatalf("Expected transaction ID to be 7, got %d", header.TransactionID)
+		}
+	})
+
+	t.Run("ParseHeader rejects unversioned frames starting with their action ID", func(t *testing.T) {
+		for _, id := range []ID{AnnounceID, ScrapeID, ConnectID, ErrorID} {
+			if _, _, err := ParseHeader([]byte{byte(id), 0, 0, 0, 7, 0}); err != ErrUnsupportedVersion {
+				t.Fatalf("Expected err to be %s for action %#x, got %v", ErrUnsupportedVersion, id, err)
+			}
+		}
+	})
+}
+
+func TestError(t *testing.T) {
+	t.Run("Error encodes and decodes its code and message", func(t *testing.T) {
+		e := NewError(ErrorCodeRateLimited, "slow down")
+
+		b, err := e.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := &Error{}
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+
+		if *decoded != *e {
+			t.Fatalf("Expected error to be %#v, got %#v", e, decoded)
+		}
+	})
+
+	t.Run("Error truncates messages to fit a single datagram", func(t *testing.T) {
+		b, _ := NewError(ErrorCodeInternal, strings.Repeat("a", MaxPayloadSize)).MarshalBinary()
+
+		if len(b)+FrameOverhead != MaxPayloadSize {
+			t.Fatalf("Expected framed error to be %d bytes, got %d", MaxPayloadSize, len(b)+FrameOverhead)
+		}
+	})
+
+	t.Run("ErrorOf keeps coded errors and wraps the others as internal ones", func(t *testing.T) {
+		if ErrorOf(ErrUnknowAction) != ErrUnknowAction {
+			t.Fatalf("Expected coded error to be kept")
+		}
+
+		if e := ErrorOf(ErrInvalidPayload); e.Code != ErrorCodeInternal || e.Message != ErrInvalidPayload.Error() {
+			t.Fatalf("Expected an internal error, got %#v", e)
+		}
+	})
+}
@@ -2,13 +2,13 @@ package actions
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
-	"errors"
 )
 
 var (
 	// ErrUnknowAction is returned when the action is unknown
-	ErrUnknowAction = errors.New("unknow action")
+	ErrUnknowAction = NewError(ErrorCodeUnknownAction, "unknow action")
 )
 
 // Reader allow to retrieve an action from a given payload
@@ -41,10 +41,23 @@ func (ar *reader) Read(buf []byte) (Action, error) {
 	switch ID(actionByte) {
 	case AnnounceID:
 		action = &Announce{}
+	case ScrapeID:
+		action = &Scrape{}
+	case ConnectID:
+		action = &Connect{}
 	default:
 		return nil, ErrUnknowAction
 	}
 
+	// Variable length actions decode themselves
+	if u, ok := action.(encoding.BinaryUnmarshaler); ok {
+		if err := u.UnmarshalBinary(buf[1:]); err != nil {
+			return nil, err
+		}
+
+		return action, nil
+	}
+
 	if err := binary.Read(r, binary.BigEndian, action); err != nil {
 		return nil, err
 	}
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/daeMOn63/gorrent/gorrent"
 )
 
 func TestReader(t *testing.T) {
@@ -53,6 +55,28 @@ func TestReader(t *testing.T) {
 			t.Fatalf("Expected action to be %#v, got %#v", expectedAction, a)
 		}
 	})
+
+	t.Run("Readers properly reads Scrape actions", func(t *testing.T) {
+		r := NewReader()
+
+		expectedAction := &Scrape{
+			InfoHashes: []gorrent.Sha1Hash{sha1.Sum([]byte("foo")), sha1.Sum([]byte("bar"))},
+		}
+
+		payload, err := expectedAction.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, err := r.Read(append([]byte{byte(ScrapeID)}, payload...))
+		if err != nil {
+			t.Fatalf("Expected err to be nil, got %s", err)
+		}
+
+		if reflect.DeepEqual(a, expectedAction) == false {
+			t.Fatalf("Expected action to be %#v, got %#v", expectedAction, a)
+		}
+	})
 }
 
 func TestDummyReader(t *testing.T) {
@@ -0,0 +1,195 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+const (
+	// MaxScrapeInfoHashes defines the maximum number of info hashes of a single scrape, to fit in an udp packet
+	MaxScrapeInfoHashes = 40
+)
+
+var (
+	// ErrTooManyInfoHashes is returned when a scrape exceeds MaxScrapeInfoHashes
+	ErrTooManyInfoHashes = NewError(ErrorCodeMalformedAction, "too many info hashes")
+	// ErrScrapeTooLarge is returned when the info hashes and their proofs do not fit in a single action
+	ErrScrapeTooLarge = NewError(ErrorCodeMalformedAction, "scrape too large")
+)
+
+// Scrape holds the info hashes to get the swarm statistics of
+// On the wire, it is the number of info hashes as an uint8, followed by the info hashes.
+// Signed scrapes follow with the proofs timestamp as a big endian int64, and each proof prefixed by the uint8 index of its info hash.
+// Timestamp and Proofs are only required on private gorrents, whose statistics are zeroed without a valid proof.
+type Scrape struct {
+	InfoHashes []gorrent.Sha1Hash
+	Timestamp  int64
+	Proofs     []ScrapeProof
+}
+
+// ScrapeProof proves the knowledge of the swarm secret of the info hash at Index
+type ScrapeProof struct {
+	Index uint8
+	Proof gorrent.Proof
+}
+
+// scrapeProofSize is the size of an encoded ScrapeProof
+const scrapeProofSize = 1 + len(gorrent.Proof{})
+
+var _ Action = &Scrape{}
+
+// ID returns the action ID
+func (s *Scrape) ID() ID {
+	return ScrapeID
+}
+
+// Sign proves the knowledge of the swarm secrets of the private info hashes, secrets being indexed by info hash
+func (s *Scrape) Sign(secrets map[gorrent.Sha1Hash][]byte, now time.Time) {
+	s.Timestamp = now.Unix()
+	s.Proofs = nil
+
+	for i, infoHash := range s.InfoHashes {
+		if secret, ok := secrets[infoHash]; ok {
+			s.Proofs = append(s.Proofs, ScrapeProof{
+				Index: uint8(i),
+				Proof: gorrent.NewProof(secret, infoHash, s.proofChallenge()),
+			})
+		}
+	}
+}
+
+// VerifyProof returns true when the scrape holds a valid and not expired proof for the info hash at index i
+func (s *Scrape) VerifyProof(i int, secret []byte, now time.Time) bool {
+	age := now.Sub(time.Unix(s.Timestamp, 0))
+	if age > MaxProofAge || age < -MaxProofAge {
+		return false
+	}
+
+	for _, p := range s.Proofs {
+		if int(p.Index) == i {
+			return gorrent.VerifyProof(p.Proof, secret, s.InfoHashes[i], s.proofChallenge())
+		}
+	}
+
+	return false
+}
+
+// proofChallenge returns the scrape fields covered by the proofs.
+// They are tagged, so announce proofs cannot be replayed as scrape ones.
+func (s *Scrape) proofChallenge() []byte {
+	buf := bytes.NewBuffer([]byte("scrape"))
+	binary.Write(buf, binary.BigEndian, s.Timestamp)
+
+	return buf.Bytes()
+}
+
+// MarshalBinary returns the binary representation of the scrape
+func (s *Scrape) MarshalBinary() ([]byte, error) {
+	if len(s.InfoHashes) > MaxScrapeInfoHashes {
+		return nil, ErrTooManyInfoHashes
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(uint8(len(s.InfoHashes)))
+	for _, h := range s.InfoHashes {
+		buf.Write(h[:])
+	}
+
+	if len(s.Proofs) > 0 {
+		binary.Write(buf, binary.BigEndian, s.Timestamp)
+		for _, p := range s.Proofs {
+			buf.WriteByte(p.Index)
+			buf.Write(p.Proof[:])
+		}
+	}
+
+	if buf.Len() > MaxPayloadSize-FrameOverhead {
+		return nil, ErrScrapeTooLarge
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary reads the scrape from its binary representation
+func (s *Scrape) UnmarshalBinary(b []byte) error {
+	if len(b) < 1 {
+		return ErrInvalidPayload
+	}
+
+	count := int(b[0])
+	if count > MaxScrapeInfoHashes {
+		return ErrTooManyInfoHashes
+	}
+
+	b = b[1:]
+	hashesSize := count * len(gorrent.Sha1Hash{})
+	if len(b) < hashesSize {
+		return ErrInvalidPayload
+	}
+
+	s.InfoHashes = make([]gorrent.Sha1Hash, count)
+	for i := range s.InfoHashes {
+		copy(s.InfoHashes[i][:], b[i*len(s.InfoHashes[i]):])
+	}
+
+	b = b[hashesSize:]
+	s.Timestamp = 0
+	s.Proofs = nil
+	if len(b) == 0 {
+		return nil
+	}
+
+	if len(b) < 8 || (len(b)-8)%scrapeProofSize != 0 {
+		return ErrInvalidPayload
+	}
+
+	s.Timestamp = int64(binary.BigEndian.Uint64(b))
+	for b = b[8:]; len(b) > 0; b = b[scrapeProofSize:] {
+		p := ScrapeProof{Index: b[0]}
+		if int(p.Index) >= count {
+			return ErrInvalidPayload
+		}
+		copy(p.Proof[:], b[1:])
+		s.Proofs = append(s.Proofs, p)
+	}
+
+	return nil
+}
+
+// ScrapeStats holds the statistics of a swarm
+type ScrapeStats struct {
+	Seeders   uint32 `json:"seeders"`
+	Leechers  uint32 `json:"leechers"`
+	Completed uint32 `json:"completed"`
+}
+
+// ScrapeResponse holds the swarm statistics of each scraped info hash, in the same order
+// On the wire, it is the statistics of each info hash as big endian uint32s.
+type ScrapeResponse struct {
+	Stats []ScrapeStats `json:"stats"`
+}
+
+// MarshalBinary returns the binary representation of the response
+func (r *ScrapeResponse) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.BigEndian, r.Stats); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary reads the response from its binary representation
+func (r *ScrapeResponse) UnmarshalBinary(b []byte) error {
+	size := binary.Size(ScrapeStats{})
+	if len(b)%size != 0 {
+		return ErrInvalidPayload
+	}
+
+	r.Stats = make([]ScrapeStats, len(b)/size)
+
+	return binary.Read(bytes.NewReader(b), binary.BigEndian, r.Stats)
+}
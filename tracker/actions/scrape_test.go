@@ -0,0 +1,120 @@
+package actions
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestScrape(t *testing.T) {
+	t.Run("Scrape encodes and decodes its info hashes", func(t *testing.T) {
+		s := &Scrape{InfoHashes: []gorrent.Sha1Hash{gorrent.RandomSha1Hash(), gorrent.RandomSha1Hash()}}
+
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := &Scrape{}
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+
+		if reflect.DeepEqual(decoded, s) == false {
+			t.Fatalf("Expected scrape to be %#v, got %#v", s, decoded)
+		}
+	})
+
+	t.Run("Scrape encodes, decodes and verifies the proofs of private info hashes", func(t *testing.T) {
+		public := gorrent.RandomSha1Hash()
+		private := gorrent.RandomSha1Hash()
+		secret := []byte("secret")
+		now := time.Now()
+
+		s := &Scrape{InfoHashes: []gorrent.Sha1Hash{public, private}}
+		s.Sign(map[gorrent.Sha1Hash][]byte{private: secret}, now)
+
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := &Scrape{}
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+
+		if reflect.DeepEqual(decoded, s) == false {
+			t.Fatalf("Expected scrape to be %#v, got %#v", s, decoded)
+		}
+
+		if !decoded.VerifyProof(1, secret, now) {
+			t.Fatalf("Expected the private info hash proof to be valid")
+		}
+
+		if decoded.VerifyProof(0, secret, now) {
+			t.Fatalf("Expected the unsigned info hash to have no valid proof")
+		}
+
+		if decoded.VerifyProof(1, []byte("other"), now) {
+			t.Fatalf("Expected the proof to be invalid for another secret")
+		}
+
+		if decoded.VerifyProof(1, secret, now.Add(MaxProofAge+time.Minute)) {
+			t.Fatalf("Expected the proof to be expired")
+		}
+	})
+
+	t.Run("Scrape fails on proofs of unknown info hashes", func(t *testing.T) {
+		s := &Scrape{InfoHashes: []gorrent.Sha1Hash{gorrent.RandomSha1Hash()}, Proofs: []ScrapeProof{{Index: 1}}}
+
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := (&Scrape{}).UnmarshalBinary(b); err != ErrInvalidPayload {
+			t.Fatalf("Expected err to be %s, got %v", ErrInvalidPayload, err)
+		}
+	})
+
+	t.Run("Scrape fails on too many info hashes", func(t *testing.T) {
+		s := &Scrape{InfoHashes: make([]gorrent.Sha1Hash, MaxScrapeInfoHashes+1)}
+		if _, err := s.MarshalBinary(); err != ErrTooManyInfoHashes {
+			t.Fatalf("Expected err to be %s, got %v", ErrTooManyInfoHashes, err)
+		}
+	})
+
+	t.Run("Scrape fails on truncated payload", func(t *testing.T) {
+		decoded := &Scrape{}
+		if err := decoded.UnmarshalBinary([]byte{2, 1, 2, 3}); err != ErrInvalidPayload {
+			t.Fatalf("Expected err to be %s, got %v", ErrInvalidPayload, err)
+		}
+	})
+}
+
+func TestScrapeResponse(t *testing.T) {
+	t.Run("ScrapeResponse encodes and decodes the stats", func(t *testing.T) {
+		r := &ScrapeResponse{Stats: []ScrapeStats{{Seeders: 1, Leechers: 2, Completed: 3}, {Seeders: 4}}}
+
+		b, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b) != 24 {
+			t.Fatalf("Expected len to be 24, got %d", len(b))
+		}
+
+		decoded := &ScrapeResponse{}
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+
+		if reflect.DeepEqual(decoded, r) == false {
+			t.Fatalf("Expected response to be %#v, got %#v", r, decoded)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/store"
+	"github.com/gorilla/mux"
+)
+
+const maxUploadSize = 1000 * 1024 // 1 MB
+
+// HTTP holds the handlers of the tracker admin API
+// Swarm secrets are registered from uploaded gorrent files. When the registry is overlaid on the tracker configuration,
+// like the trackerd one, the configured gorrents and secrets are never replaced nor removed.
+type HTTP struct {
+	registry   store.Registry
+	readWriter gorrent.ReadWriter
+}
+
+// NewHTTP returns a new HTTP
+func NewHTTP(registry store.Registry, rw gorrent.ReadWriter) *HTTP {
+	return &HTTP{
+		registry:   registry,
+		readWriter: rw,
+	}
+}
+
+// Response describe the generic handler response format
+type Response struct {
+	Status  int         `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+}
+
+type gorrentEntry struct {
+	InfoHash string `json:"infoHash"`
+	Private  bool   `json:"private"`
+}
+
+// List returns the registered gorrents
+func (h *HTTP) List(w http.ResponseWriter, r *http.Request) {
+	entries := []gorrentEntry{}
+	for _, infoHash := range h.registry.All() {
+		entries = append(entries, h.entry(infoHash))
+	}
+
+	writeSuccess(w, entries)
+}
+
+// Register allows the gorrent with the info hash of the url on the tracker, as a public one unless already registered
+func (h *HTTP) Register(w http.ResponseWriter, r *http.Request) {
+	infoHash, err := gorrent.ParseSha1Hash(mux.Vars(r)["hash"])
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !h.registry.Registered(infoHash) {
+		h.registry.Register(infoHash, nil)
+	}
+	log.Printf("registered %s", infoHash.HexString())
+
+	writeSuccess(w, h.entry(infoHash))
+}
+
+// Unregister disallows the gorrent with the info hash of the url on the tracker, forgetting its uploaded swarm secret
+func (h *HTTP) Unregister(w http.ResponseWriter, r *http.Request) {
+	infoHash, err := gorrent.ParseSha1Hash(mux.Vars(r)["hash"])
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	h.registry.Unregister(infoHash)
+	log.Printf("unregistered %s", infoHash.HexString())
+
+	writeSuccess(w, infoHash.HexString())
+}
+
+// Upload registers the gorrent file uploaded as the gorrent form field, along its swarm secret when private
+func (h *HTTP) Upload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("gorrent")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	g, err := h.readWriter.Read(file)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	infoHash := g.InfoHash()
+	h.registry.Register(infoHash, g.Secret)
+	log.Printf("registered %s from gorrent file", infoHash.HexString())
+
+	writeSuccess(w, h.entry(infoHash))
+}
+
+func (h *HTTP) entry(infoHash gorrent.Sha1Hash) gorrentEntry {
+	_, private := h.registry.Secret(infoHash)
+
+	return gorrentEntry{
+		InfoHash: infoHash.HexString(),
+		Private:  private,
+	}
+}
+
+func writeSuccess(w http.ResponseWriter, data interface{}) {
+	r := &Response{
+		Status: http.StatusOK,
+		Data:   data,
+	}
+
+	jsonEncode(w, r)
+}
+
+func writeError(w http.ResponseWriter, e error, status int) {
+	r := &Response{
+		Status:  status,
+		Message: e.Error(),
+	}
+
+	w.WriteHeader(status)
+	jsonEncode(w, r)
+}
+
+func jsonEncode(w http.ResponseWriter, response *Response) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+
+	if err := enc.Encode(response); err != nil {
+		log.Printf("jsonEncode error: %s", err)
+	}
+}
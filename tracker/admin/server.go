@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/store"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// ErrUnauthorized is returned when a request does not hold the admin token
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Server defines the tracker admin server, used for registering the gorrents allowed on the tracker,
+// and inspecting the tracked swarms
+type Server struct {
+	addr   string
+	token  string
+	http   *HTTP
+	swarms *SwarmsHTTP
+}
+
+// NewServer creates a new admin server listening on addr
+// Requests must hold token as a bearer token in their Authorization header, all of them being rejected when it is empty.
+// Swarm peers are counted when they announced within maxPeerAge.
+func NewServer(addr string, token string, registry store.Registry, announces store.Announce, maxPeerAge time.Duration) *Server {
+	return &Server{
+		addr:   addr,
+		token:  token,
+		http:   NewHTTP(registry, gorrent.NewReadWriter()),
+		swarms: NewSwarmsHTTP(announces, maxPeerAge),
+	}
+}
+
+// Handler returns the admin API routes
+func (s *Server) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/gorrents", s.http.List).Methods("GET")
+	router.HandleFunc("/gorrents", s.http.Upload).Methods("POST")
+	router.HandleFunc("/gorrents/{hash}", s.http.Register).Methods("PUT")
+	router.HandleFunc("/gorrents/{hash}", s.http.Unregister).Methods("DELETE")
+	router.HandleFunc("/swarms", s.swarms.List).Methods("GET")
+	router.HandleFunc("/swarms/{hash}", s.swarms.Show).Methods("GET")
+	router.HandleFunc("/swarms/{hash}", s.swarms.Purge).Methods("DELETE")
+	router.HandleFunc("/swarms/{hash}/peers/{peerID}", s.swarms.Kick).Methods("DELETE")
+
+	router.Use(s.authenticate)
+
+	return router
+}
+
+// Listen start listening for admin requests
+func (s *Server) Listen() error {
+	log.Printf("tracker admin listening on http://%s", s.addr)
+
+	return http.ListenAndServe(s.addr, s.Handler())
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := []byte("Bearer " + s.token)
+		if s.token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, ErrUnauthorized, http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("admin %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
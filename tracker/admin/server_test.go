@@ -0,0 +1,160 @@
+package admin
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+func newRequest(method string, url string, token string) *http.Request {
+	r := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return r
+}
+
+// newUploadRequest returns an authenticated upload request of the gorrent file of g
+func newUploadRequest(t *testing.T, g *gorrent.Gorrent) *http.Request {
+	body := bytes.NewBuffer(nil)
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("gorrent", "test.gorrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gorrent.NewReadWriter().Write(part, g); err != nil {
+		t.Fatal(err)
+	}
+	form.Close()
+
+	r := httptest.NewRequest("POST", "/gorrents", body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer token")
+
+	return r
+}
+
+func TestServer(t *testing.T) {
+	t.Run("Requests without the token are rejected", func(t *testing.T) {
+		s := NewServer("", "token", store.NewRegistryMemory(), store.NewAnnounceMemory(), time.Minute)
+
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest("GET", "/gorrents", "other"))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status to be %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+	})
+
+	t.Run("Register and Unregister update the registry", func(t *testing.T) {
+		registry := store.NewRegistryMemory()
+		s := NewServer("", "token", registry, store.NewAnnounceMemory(), time.Minute)
+
+		infoHash := gorrent.RandomSha1Hash()
+
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest("PUT", "/gorrents/"+infoHash.HexString(), "token"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status to be %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		if !registry.Registered(infoHash) {
+			t.Fatalf("Expected info hash to be registered")
+		}
+
+		w = httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest("DELETE", "/gorrents/"+infoHash.HexString(), "token"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status to be %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		if registry.Registered(infoHash) {
+			t.Fatalf("Expected info hash to be unregistered")
+		}
+	})
+
+	t.Run("Configured gorrents and secrets are never replaced nor removed", func(t *testing.T) {
+		configured := store.NewRegistryMemory()
+		s := NewServer("", "token", store.NewRegistryOverlay(configured, store.NewRegistryMemory()), store.NewAnnounceMemory(), time.Minute)
+
+		g := &gorrent.Gorrent{Announce: "127.0.0.1:4444", Secret: []byte("uploaded")}
+		configured.Register(g.InfoHash(), []byte("configured"))
+
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newUploadRequest(t, g))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status to be %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		w = httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest("DELETE", "/gorrents/"+g.InfoHash().HexString(), "token"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status to be %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		if secret, ok := s.http.registry.Secret(g.InfoHash()); !ok || string(secret) != "configured" {
+			t.Fatalf("Expected the configured swarm secret to be kept, got %s", secret)
+		}
+	})
+
+	t.Run("Requests are rejected when no token is set", func(t *testing.T) {
+		s := NewServer("", "", store.NewRegistryMemory(), store.NewAnnounceMemory(), time.Minute)
+
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest("GET", "/gorrents", ""))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status to be %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+	})
+
+	t.Run("Register fails on invalid info hash", func(t *testing.T) {
+		s := NewServer("", "token", store.NewRegistryMemory(), store.NewAnnounceMemory(), time.Minute)
+
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest("PUT", "/gorrents/nothex", "token"))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status to be %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Upload registers the gorrent and its swarm secret", func(t *testing.T) {
+		registry := store.NewRegistryMemory()
+		s := NewServer("", "token", registry, store.NewAnnounceMemory(), time.Minute)
+
+		g := &gorrent.Gorrent{Announce: "127.0.0.1:4444", Secret: []byte("secret")}
+
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newUploadRequest(t, g))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status to be %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		if !registry.Registered(g.InfoHash()) {
+			t.Fatalf("Expected info hash to be registered")
+		}
+
+		secret, ok := registry.Secret(g.InfoHash())
+		if !ok || reflect.DeepEqual(secret, g.Secret) == false {
+			t.Fatalf("Expected secret to be %v, got %v", g.Secret, secret)
+		}
+
+		w = httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest("DELETE", "/gorrents/"+g.InfoHash().HexString(), "token"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status to be %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		if _, ok := registry.Secret(g.InfoHash()); ok {
+			t.Fatalf("Expected uploaded swarm secret to be removed")
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/store"
+	"github.com/gorilla/mux"
+)
+
+// SwarmsHTTP holds the handlers of the tracker admin API inspecting the tracked swarms
+type SwarmsHTTP struct {
+	announces store.Announce
+	maxAge    time.Duration
+}
+
+// NewSwarmsHTTP returns a new SwarmsHTTP, counting the peers which announced within maxAge
+func NewSwarmsHTTP(announces store.Announce, maxAge time.Duration) *SwarmsHTTP {
+	return &SwarmsHTTP{
+		announces: announces,
+		maxAge:    maxAge,
+	}
+}
+
+type swarmEntry struct {
+	InfoHash  string `json:"infoHash"`
+	Seeders   uint32 `json:"seeders"`
+	Leechers  uint32 `json:"leechers"`
+	Completed uint32 `json:"completed"`
+}
+
+type swarmDetails struct {
+	swarmEntry
+	Peers []peerEntry `json:"peers"`
+}
+
+type peerEntry struct {
+	PeerID      string    `json:"peerID"`
+	Addr        string    `json:"addr"`
+	Event       string    `json:"event"`
+	Uploaded    uint64    `json:"uploaded"`
+	Downloaded  uint64    `json:"downloaded"`
+	Left        uint64    `json:"left"`
+	LastUpdated time.Time `json:"lastUpdated"`
+}
+
+// List returns the tracked info hashes with their peer counts
+func (h *SwarmsHTTP) List(w http.ResponseWriter, r *http.Request) {
+	entries := []swarmEntry{}
+	for _, infoHash := range h.announces.InfoHashes() {
+		entries = append(entries, h.entry(infoHash))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].InfoHash < entries[j].InfoHash
+	})
+
+	writeSuccess(w, entries)
+}
+
+// Show returns the stored announce of each peer of the info hash swarm
+func (h *SwarmsHTTP) Show(w http.ResponseWriter, r *http.Request) {
+	infoHash, err := gorrent.ParseSha1Hash(mux.Vars(r)["hash"])
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	details := swarmDetails{
+		swarmEntry: h.entry(infoHash),
+		Peers:      []peerEntry{},
+	}
+
+	for _, sa := range h.announces.Announces(infoHash) {
+		a := sa.Announce
+		details.Peers = append(details.Peers, peerEntry{
+			PeerID:      a.Peer.ID.HexString(),
+			Addr:        a.Peer.PeerAddr.String(),
+			Event:       a.Event.Name(),
+			Uploaded:    a.Status.Uploaded,
+			Downloaded:  a.Status.Downloaded,
+			Left:        a.Status.Left,
+			LastUpdated: sa.LastUpdated,
+		})
+	}
+
+	sort.Slice(details.Peers, func(i, j int) bool {
+		return details.Peers[i].LastUpdated.After(details.Peers[j].LastUpdated)
+	})
+
+	writeSuccess(w, details)
+}
+
+// Kick removes the announce of the peer from the info hash swarm, until it announces again
+func (h *SwarmsHTTP) Kick(w http.ResponseWriter, r *http.Request) {
+	infoHash, err := gorrent.ParseSha1Hash(mux.Vars(r)["hash"])
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	peerID, err := gorrent.ParsePeerID(mux.Vars(r)["peerID"])
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	h.announces.Remove(infoHash, peerID)
+	log.Printf("kicked peer %s from %s", peerID.HexString(), infoHash.HexString())
+
+	writeSuccess(w, peerID.HexString())
+}
+
+// Purge removes all the announces of the info hash swarm, and its completed count
+func (h *SwarmsHTTP) Purge(w http.ResponseWriter, r *http.Request) {
+	infoHash, err := gorrent.ParseSha1Hash(mux.Vars(r)["hash"])
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	h.announces.RemoveSwarm(infoHash)
+	log.Printf("purged swarm %s", infoHash.HexString())
+
+	writeSuccess(w, infoHash.HexString())
+}
+
+func (h *SwarmsHTTP) entry(infoHash gorrent.Sha1Hash) swarmEntry {
+	stats := h.announces.Stats(infoHash, h.maxAge)
+
+	return swarmEntry{
+		InfoHash:  infoHash.HexString(),
+		Seeders:   stats.Seeders,
+		Leechers:  stats.Leechers,
+		Completed: stats.Completed,
+	}
+}
@@ -0,0 +1,106 @@
+package admin
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+func TestSwarms(t *testing.T) {
+	announces := store.NewAnnounceMemory()
+	s := NewServer("", "token", store.NewRegistryMemory(), announces, time.Minute)
+
+	seeder := &actions.Announce{
+		InfoHash: gorrent.RandomSha1Hash(),
+		Peer:     *gorrent.NewPeer("seeder", net.ParseIP("10.0.0.1"), 1),
+		Event:    actions.AnnounceEventCompleted,
+		Status:   actions.AnnounceStatus{Uploaded: 10, Downloaded: 20},
+	}
+	leecher := &actions.Announce{
+		InfoHash: seeder.InfoHash,
+		Peer:     *gorrent.NewPeer("leecher", net.ParseIP("10.0.0.2"), 2),
+		Event:    actions.AnnounceEventStarted,
+		Status:   actions.AnnounceStatus{Left: 5},
+	}
+	announces.Save(seeder)
+	announces.Save(leecher)
+
+	serve := func(method string, url string, data interface{}) {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest(method, url, "token"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status to be %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		if data != nil {
+			if err := json.NewDecoder(w.Body).Decode(&Response{Data: data}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("List returns the tracked info hashes with their peer counts", func(t *testing.T) {
+		var entries []swarmEntry
+		serve("GET", "/swarms", &entries)
+
+		expected := swarmEntry{InfoHash: seeder.InfoHash.HexString(), Seeders: 1, Leechers: 1, Completed: 1}
+		if len(entries) != 1 || entries[0] != expected {
+			t.Fatalf("Expected entries to be [%#v], got %#v", expected, entries)
+		}
+	})
+
+	t.Run("Show returns the swarm peers announces", func(t *testing.T) {
+		var details swarmDetails
+		serve("GET", "/swarms/"+seeder.InfoHash.HexString(), &details)
+
+		if len(details.Peers) != 2 {
+			t.Fatalf("Expected 2 peers, got %#v", details.Peers)
+		}
+
+		for _, p := range details.Peers {
+			if p.PeerID != seeder.Peer.ID.HexString() {
+				continue
+			}
+
+			if p.Addr != "10.0.0.1:1" || p.Event != actions.AnnounceEventCompleted.Name() || p.Uploaded != 10 || p.Downloaded != 20 || p.LastUpdated.IsZero() {
+				t.Fatalf("Unexpected seeder details %#v", p)
+			}
+		}
+	})
+
+	t.Run("Kick removes the peer announce", func(t *testing.T) {
+		serve("DELETE", "/swarms/"+seeder.InfoHash.HexString()+"/peers/"+leecher.Peer.ID.HexString(), nil)
+
+		if announces.Find(leecher.InfoHash, leecher.Peer.ID) != nil {
+			t.Fatalf("Expected leecher to be kicked")
+		}
+
+		if announces.Find(seeder.InfoHash, seeder.Peer.ID) == nil {
+			t.Fatalf("Expected seeder to be kept")
+		}
+	})
+
+	t.Run("Purge removes the whole swarm", func(t *testing.T) {
+		serve("DELETE", "/swarms/"+seeder.InfoHash.HexString(), nil)
+
+		if len(announces.InfoHashes()) != 0 {
+			t.Fatalf("Expected swarm to be purged")
+		}
+	})
+
+	t.Run("Invalid peer IDs are rejected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, newRequest("DELETE", "/swarms/"+seeder.InfoHash.HexString()+"/peers/zz", "token"))
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status to be %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+}
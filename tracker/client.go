@@ -4,94 +4,272 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"math/rand"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/daeMOn63/gorrent/gorrent"
 	"github.com/daeMOn63/gorrent/tracker/actions"
 )
 
+const (
+	// RequestTimeout defines how long the client waits for the tracker response
+	RequestTimeout = 5 * time.Second
+	// DefaultNumWant defines how many peers the client asks for on announces
+	DefaultNumWant = 50
+)
+
 var (
 	// ErrInvalidResponse is returned when the client failed to decode the response
 	ErrInvalidResponse = errors.New("invalid response")
+	// ErrTransactionMismatch is returned when the response does not echo the request transaction ID
+	ErrTransactionMismatch = errors.New("response transaction ID mismatch")
 )
 
 // Client interface list the tracker client methods to interact with the server
+// Failures reported by the tracker are returned as *actions.Error, holding the tracker error code.
 type Client interface {
-	Announce(g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) ([]gorrent.PeerAddr, error)
+	Announce(addr string, g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) (*actions.AnnounceResponse, error)
+	Scrape(addr string, infoHashes []gorrent.Sha1Hash, secrets map[gorrent.Sha1Hash][]byte) (*actions.ScrapeResponse, error)
 }
 
 type client struct {
 	peer     gorrent.Peer
 	protocol string
+
+	mutex       sync.Mutex
+	connections map[string]connection
+}
+
+// connection holds a connection ID obtained from a tracker, reused until expiresAt
+type connection struct {
+	id        uint64
+	expiresAt time.Time
 }
 
 var _ Client = &client{}
 
 // NewClient returns a new tracker client
+// The protocol is ProtocolTCP, ProtocolHTTP, ProtocolHTTPS, or a datagram network like ProtocolUDP.
 func NewClient(peer gorrent.Peer, protocol string) Client {
 	return &client{
-		peer:     peer,
-		protocol: protocol,
+		peer:        peer,
+		protocol:    protocol,
+		connections: make(map[string]connection),
 	}
 }
 
-// Announce reports the client gorrent status to the server
+// Announce reports the client gorrent status to the tracker at addr
 // This will allow the tracker to list (or unlist) the client from the peer list
-func (c *client) Announce(g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) ([]gorrent.PeerAddr, error) {
-	conn, err := net.Dial(c.protocol, g.Announce)
+func (c *client) Announce(addr string, g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) (*actions.AnnounceResponse, error) {
+	response, err := c.announce(addr, g, evt, status)
+
+	// The tracker may have restarted, invalidating the connection ID before it expires
+	var trackerErr *actions.Error
+	if errors.As(err, &trackerErr) && trackerErr.Code == actions.ErrorCodeInvalidConnectionID {
+		c.mutex.Lock()
+		delete(c.connections, addr)
+		c.mutex.Unlock()
+
+		response, err = c.announce(addr, g, evt, status)
+	}
+
+	return response, err
+}
+
+func (c *client) announce(addr string, g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) (*actions.AnnounceResponse, error) {
+	connectionID, err := c.connect(addr)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
 	data := &actions.Announce{
-		InfoHash: g.InfoHash(),
-		Peer:     c.peer,
-		Status:   status,
-		Event:    evt,
+		ConnectionID: connectionID,
+		InfoHash:     g.InfoHash(),
+		Peer:         c.peer,
+		Status:       status,
+		Event:        evt,
+		NumWant:      DefaultNumWant,
+	}
+
+	if g.IsPrivate() {
+		data.Sign(g.Secret, time.Now())
 	}
 
 	buf := bytes.NewBuffer(nil)
-	buf.WriteByte(uint8(actions.AnnounceID))
 	if err := binary.Write(buf, binary.BigEndian, data); err != nil {
 		return nil, err
 	}
 
-	n, err := conn.Write(buf.Bytes())
+	resp, err := c.roundTrip(addr, actions.AnnounceID, buf.Bytes())
 	if err != nil {
 		return nil, err
 	}
 
-	resp := make([]byte, 1024)
+	response := &actions.AnnounceResponse{}
+	if err := response.UnmarshalBinary(resp); err != nil {
+		return nil, ErrInvalidResponse
+	}
+
+	return response, nil
+}
+
+// connect returns a connection ID for the tracker at addr, only asking a new one when the previous may have expired
+func (c *client) connect(addr string) (uint64, error) {
+	c.mutex.Lock()
+	conn, ok := c.connections[addr]
+	c.mutex.Unlock()
+
+	now := time.Now()
+	if ok && now.Before(conn.expiresAt) {
+		return conn.id, nil
+	}
+
+	resp, err := c.roundTrip(addr, actions.ConnectID, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	response := &actions.ConnectResponse{}
+	if err := response.UnmarshalBinary(resp); err != nil {
+		return 0, ErrInvalidResponse
+	}
 
-	n, err = conn.Read(resp)
+	// Half the lifetime leaves a safe margin before the tracker stops accepting it
+	c.mutex.Lock()
+	c.connections[addr] = connection{
+		id:        response.ConnectionID,
+		expiresAt: now.Add(actions.ConnectionIDLifetime / 2),
+	}
+	c.mutex.Unlock()
+
+	return response.ConnectionID, nil
+}
+
+// Scrape retrieves the swarm statistics of the given info hashes from the tracker at addr
+// Statistics are returned in the same order as the info hashes.
+// Private gorrents must have their swarm secret in secrets, or the tracker zeroes their statistics.
+func (c *client) Scrape(addr string, infoHashes []gorrent.Sha1Hash, secrets map[gorrent.Sha1Hash][]byte) (*actions.ScrapeResponse, error) {
+	data := &actions.Scrape{
+		InfoHashes: infoHashes,
+	}
+
+	if len(secrets) > 0 {
+		data.Sign(secrets, time.Now())
+	}
+
+	payload, err := data.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 
-	addrs, err := decodeResponse(resp[:n])
+	resp, err := c.roundTrip(addr, actions.ScrapeID, payload)
 	if err != nil {
 		return nil, err
 	}
 
-	return addrs, nil
+	response := &actions.ScrapeResponse{}
+	if err := response.UnmarshalBinary(resp); err != nil || len(response.Stats) != len(infoHashes) {
+		return nil, ErrInvalidResponse
+	}
+
+	return response, nil
 }
 
-func decodeResponse(b []byte) ([]gorrent.PeerAddr, error) {
-	if len(b)%6 != 0 {
+// roundTrip sends the action with given id and payload to the tracker at addr, and returns the response payload
+// Error responses are returned as *actions.Error.
+func (c *client) roundTrip(addr string, id actions.ID, payload []byte) ([]byte, error) {
+	header := actions.NewHeader(rand.Uint32())
+
+	resp, err := c.send(addr, header.Frame(id, payload))
+	if err != nil {
+		return nil, err
+	}
+
+	respHeader, body, err := actions.ParseHeader(resp)
+	if err == actions.ErrUnsupportedVersion {
+		return nil, err
+	}
+
+	if err != nil || len(body) == 0 {
 		return nil, ErrInvalidResponse
 	}
 
-	var addrs []gorrent.PeerAddr
+	if respHeader.TransactionID != header.TransactionID {
+		return nil, ErrTransactionMismatch
+	}
 
-	for i := 0; i < len(b); i += 6 {
-		buf := bytes.NewBuffer(b[i : i+6])
-		var addr gorrent.PeerAddr
-		if err := binary.Read(buf, binary.BigEndian, &addr); err != nil {
-			return nil, err
+	switch actions.ID(body[0]) {
+	case id:
+		return body[1:], nil
+	case actions.ErrorID:
+		trackerErr := &actions.Error{}
+		if err := trackerErr.UnmarshalBinary(body[1:]); err != nil {
+			return nil, ErrInvalidResponse
 		}
-		addrs = append(addrs, addr)
+
+		return nil, trackerErr
+	}
+
+	return nil, ErrInvalidResponse
+}
+
+// send sends the framed request to the tracker at addr and returns its framed response
+// Trackers given as an http or https url are reached over HTTP, whatever the client protocol.
+func (c *client) send(addr string, request []byte) ([]byte, error) {
+	if isHTTPURL(addr) {
+		return httpRoundTrip(addr, request)
+	}
+
+	switch c.protocol {
+	case ProtocolHTTP, ProtocolHTTPS:
+		return httpRoundTrip(c.protocol+"://"+addr, request)
+	case ProtocolTCP:
+		return streamRoundTrip(c.protocol, addr, request)
+	}
+
+	return c.datagramRoundTrip(addr, request)
+}
+
+// datagramRoundTrip sends the request as a single datagram, and reads the response from the next one
+func (c *client) datagramRoundTrip(addr string, request []byte) ([]byte, error) {
+	conn, err := net.Dial(c.protocol, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(RequestTimeout))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
 	}
 
-	return addrs, nil
+	resp := make([]byte, MaxUDPPacketSize)
+
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp[:n], nil
+}
+
+// DummyClient provides a configurable Client
+type DummyClient struct {
+	AnnounceFunc func(addr string, g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) (*actions.AnnounceResponse, error)
+	ScrapeFunc   func(addr string, infoHashes []gorrent.Sha1Hash, secrets map[gorrent.Sha1Hash][]byte) (*actions.ScrapeResponse, error)
+}
+
+var _ Client = &DummyClient{}
+
+// Announce calls AnnounceFunc
+func (d *DummyClient) Announce(addr string, g *gorrent.Gorrent, evt actions.AnnounceEvent, status actions.AnnounceStatus) (*actions.AnnounceResponse, error) {
+	return d.AnnounceFunc(addr, g, evt, status)
+}
+
+// Scrape calls ScrapeFunc
+func (d *DummyClient) Scrape(addr string, infoHashes []gorrent.Sha1Hash, secrets map[gorrent.Sha1Hash][]byte) (*actions.ScrapeResponse, error) {
+	return d.ScrapeFunc(addr, infoHashes, secrets)
 }
@@ -0,0 +1,142 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/handlers"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+// newTestTracker starts a tracker with the real handlers on a free port, and returns its address
+// along a pointer to the number of connect actions it received
+func newTestTracker(t *testing.T, protocol string) (string, *int32) {
+	announceStore := store.NewAnnounceMemory()
+	connections := handlers.NewConnectionIDs([]byte("secret"), actions.ConnectionIDLifetime)
+	announceConfig := handlers.AnnounceConfig{Interval: time.Minute}
+
+	connects := int32(0)
+	connectHandler := handlers.NewConnect(connections)
+
+	router := actions.NewRouter()
+	router.Register(actions.ConnectID, &actions.DummyHandler{
+		HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+			atomic.AddInt32(&connects, 1)
+			return connectHandler.Handle(src, action)
+		},
+	})
+	router.Register(actions.AnnounceID, handlers.NewAnnounce(announceStore, store.NewSecretsMemory(), connections, announceConfig))
+	router.Register(actions.ScrapeID, handlers.NewScrape(announceStore, store.NewSecretsMemory(), announceConfig.MaxPeerAge()))
+
+	_, cfg := startTestServer(t, ServerConfig{Protocol: protocol}, actions.NewReader(), router)
+
+	return cfg.Addr, &connects
+}
+
+func TestClient(t *testing.T) {
+	for _, protocol := range []string{ProtocolUDP, ProtocolTCP, ProtocolHTTP} {
+		t.Run(fmt.Sprintf("Client connects, announces and scrapes a tracker over %s", protocol), func(t *testing.T) {
+			testClient(t, protocol)
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	connects := int32(0)
+	announces := int32(0)
+
+	router := actions.NewRouter()
+	router.Register(actions.ConnectID, &actions.DummyHandler{
+		HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+			connectionID := atomic.AddInt32(&connects, 1)
+			return (&actions.ConnectResponse{ConnectionID: uint64(connectionID)}).MarshalBinary()
+		},
+	})
+	router.Register(actions.AnnounceID, &actions.DummyHandler{
+		HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+			n := atomic.AddInt32(&announces, 1)
+			if action.(*actions.Announce).ConnectionID != uint64(atomic.LoadInt32(&connects)) || n == 1 {
+				return nil, handlers.ErrInvalidConnectionID
+			}
+
+			return (&actions.AnnounceResponse{}).MarshalBinary()
+		},
+	})
+	router.Register(actions.ScrapeID, &actions.DummyHandler{
+		HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+			return nil, handlers.ErrUnregisteredInfoHash
+		},
+	})
+
+	_, cfg := startTestServer(t, ServerConfig{Protocol: ProtocolUDP}, actions.NewReader(), router)
+	addr := cfg.Addr
+
+	c := NewClient(gorrent.Peer{}, ProtocolUDP)
+
+	t.Run("Client returns tracker failures as typed errors", func(t *testing.T) {
+		_, err := c.Scrape(addr, []gorrent.Sha1Hash{gorrent.RandomSha1Hash()}, nil)
+		if !errors.Is(err, handlers.ErrUnregisteredInfoHash) {
+			t.Fatalf("Expected err to be %s, got %v", handlers.ErrUnregisteredInfoHash, err)
+		}
+
+		var trackerErr *actions.Error
+		if !errors.As(err, &trackerErr) || trackerErr.Code != actions.ErrorCodeUnregisteredInfoHash {
+			t.Fatalf("Expected an unregistered info hash error, got %#v", err)
+		}
+	})
+
+	t.Run("Client asks a new connection ID when the tracker rejects it", func(t *testing.T) {
+		if _, err := c.Announce(addr, &gorrent.Gorrent{}, actions.AnnounceEventStarted, actions.AnnounceStatus{}); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if connects, announces := atomic.LoadInt32(&connects), atomic.LoadInt32(&announces); connects != 2 || announces != 2 {
+			t.Fatalf("Expected 2 connects and announces, got %d and %d", connects, announces)
+		}
+	})
+}
+
+func testClient(t *testing.T, protocol string) {
+	addr, connects := newTestTracker(t, protocol)
+
+	g := &gorrent.Gorrent{Announce: addr}
+	seeder := NewClient(*gorrent.NewPeer("seeder", net.ParseIP("127.0.0.1"), 1), protocol)
+	leecher := NewClient(*gorrent.NewPeer("leecher", net.ParseIP("127.0.0.1"), 2), protocol)
+
+	if _, err := seeder.Announce(addr, g, actions.AnnounceEventStarted, actions.AnnounceStatus{}); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := leecher.Announce(addr, g, actions.AnnounceEventStarted, actions.AnnounceStatus{Left: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Peers) != 1 || response.Peers[0].Port != 1 {
+		t.Fatalf("Expected the seeder to be returned, got %v", response.Peers)
+	}
+
+	if _, err := leecher.Announce(addr, g, actions.AnnounceEventNone, actions.AnnounceStatus{Left: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if connects := atomic.LoadInt32(connects); connects != 2 {
+		t.Fatalf("Expected each client to connect once, got %d connects", connects)
+	}
+
+	scrape, err := leecher.Scrape(addr, []gorrent.Sha1Hash{g.InfoHash()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedStats := []actions.ScrapeStats{{Seeders: 1, Leechers: 1}}
+	if len(scrape.Stats) != 1 || scrape.Stats[0] != expectedStats[0] {
+		t.Fatalf("Expected stats to be %v, got %v", expectedStats, scrape.Stats)
+	}
+}
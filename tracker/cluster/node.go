@@ -0,0 +1,316 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+const (
+	// ReplicatePath is the path nodes post their operations to
+	ReplicatePath = "/replicate"
+	// DefaultFlushInterval defines how often a node sends its pending operations to the other nodes
+	DefaultFlushInterval = 500 * time.Millisecond
+	// MaxPendingOperations defines how many operations a node keeps before dropping the oldest ones
+	MaxPendingOperations = 10000
+	// MaxReplicateSize defines the maximum size of a replication request body, holding up to MaxPendingOperations
+	MaxReplicateSize = 8 * 1024 * 1024
+
+	requestTimeout = 5 * time.Second
+)
+
+var (
+	// ErrUnauthorized is returned when a replication request does not hold the cluster token
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Node is an announce store replicating the announces saved and removed on it to the other trackers of the cluster,
+// so peers can announce to any of them and get the full peer list.
+// Operations are pushed to every other node, each node being configured with all the others. As peers announce
+// periodically, a node joining the cluster, or missing some operations, gets the full state within an announce interval.
+// Gorrents registered on the Registry of a node, along their swarm secret, are replicated the same way. They are not
+// announced again though, so a node missing a registration only gets it when the gorrent is registered again.
+type Node interface {
+	store.Announce
+	Registry(local store.Registry) store.Registry
+	Listen() error
+	ReplicateForever() error
+	Flush()
+}
+
+// Config describes a cluster node
+// Addr is where the node receives the operations of the others, listed in Peers as host:port.
+// Nodes must hold Token as a bearer token to replicate their operations, all of them being rejected when it is empty.
+type Config struct {
+	Addr          string
+	Peers         []string
+	Token         string
+	FlushInterval time.Duration
+}
+
+// OperationType defines what an Operation does on the store
+type OperationType uint8
+
+const (
+	// OperationSave saves the operation announce
+	OperationSave OperationType = iota
+	// OperationRemove removes the announce of the operation peer
+	OperationRemove
+	// OperationRemoveSwarm removes all the announces of the operation info hash
+	OperationRemoveSwarm
+	// OperationRegister registers the operation info hash, along its secret
+	OperationRegister
+	// OperationUnregister unregisters the operation info hash
+	OperationUnregister
+)
+
+// Operation is an announce saved, or removed, on a node, or a gorrent registered or unregistered on it
+// Announce is only set on announce operations, InfoHash and Secret on registry ones.
+type Operation struct {
+	Type     OperationType
+	Announce *actions.Announce
+	InfoHash gorrent.Sha1Hash
+	Secret   []byte
+}
+
+type node struct {
+	store.Announce
+
+	cfg    Config
+	client *http.Client
+
+	mutex    sync.Mutex
+	pending  []Operation
+	registry store.Registry
+}
+
+var _ Node = &node{}
+var _ store.Registry = &registry{}
+
+// NewNode returns a Node storing the announces in local, and replicating them to the cfg peers
+func NewNode(cfg Config, local store.Announce) Node {
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+
+	return &node{
+		Announce: local,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Save stores the announce locally, and queues it for the other nodes
+func (n *node) Save(announce *actions.Announce) {
+	n.Announce.Save(announce)
+	n.queue(Operation{Type: OperationSave, Announce: announce})
+}
+
+// Remove deletes the peer announce locally, and queues its removal for the other nodes
+func (n *node) Remove(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) {
+	n.Announce.Remove(infoHash, peerID)
+	n.queue(Operation{
+		Type:     OperationRemove,
+		Announce: &actions.Announce{InfoHash: infoHash, Peer: gorrent.Peer{ID: peerID}},
+	})
+}
+
+// RemoveSwarm deletes the swarm locally, and queues its removal for the other nodes
+func (n *node) RemoveSwarm(infoHash gorrent.Sha1Hash) {
+	n.Announce.RemoveSwarm(infoHash)
+	n.queue(Operation{
+		Type:     OperationRemoveSwarm,
+		Announce: &actions.Announce{InfoHash: infoHash},
+	})
+}
+
+// Registry returns a Registry storing the registrations in local, and replicating them to the cfg peers.
+// The registrations replicated by the other nodes are stored in local too.
+func (n *node) Registry(local store.Registry) store.Registry {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.registry = local
+
+	return &registry{
+		Registry: local,
+		node:     n,
+	}
+}
+
+// registry replicates the registrations of its node
+type registry struct {
+	store.Registry
+
+	node *node
+}
+
+// Register stores the registration locally, and queues it for the other nodes
+func (r *registry) Register(infoHash gorrent.Sha1Hash, secret []byte) {
+	r.Registry.Register(infoHash, secret)
+	r.node.queue(Operation{Type: OperationRegister, InfoHash: infoHash, Secret: secret})
+}
+
+// Unregister deletes the registration locally, and queues its removal for the other nodes
+func (r *registry) Unregister(infoHash gorrent.Sha1Hash) {
+	r.Registry.Unregister(infoHash)
+	r.node.queue(Operation{Type: OperationUnregister, InfoHash: infoHash})
+}
+
+func (n *node) queue(op Operation) {
+	if len(n.cfg.Peers) == 0 {
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if len(n.pending) >= MaxPendingOperations {
+		log.Printf("cluster: dropping oldest pending operation")
+		n.pending = n.pending[1:]
+	}
+
+	n.pending = append(n.pending, op)
+}
+
+// Listen receives the operations replicated by the other nodes
+func (n *node) Listen() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ReplicatePath, n.handleReplicate)
+
+	log.Printf("cluster node listening on http://%s", n.cfg.Addr)
+
+	return http.ListenAndServe(n.cfg.Addr, mux)
+}
+
+// handleReplicate applies the received operations on the local stores only, so they are not replicated again
+// Registry operations are ignored until the node Registry is set.
+func (n *node) handleReplicate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	expected := []byte("Bearer " + n.cfg.Token)
+	if n.cfg.Token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+		http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxReplicateSize)
+
+	var ops []Operation
+	if err := gob.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n.mutex.Lock()
+	registry := n.registry
+	n.mutex.Unlock()
+
+	for _, op := range ops {
+		switch op.Type {
+		case OperationSave, OperationRemove, OperationRemoveSwarm:
+			n.applyAnnounce(op)
+		case OperationRegister:
+			if registry != nil {
+				registry.Register(op.InfoHash, op.Secret)
+			}
+		case OperationUnregister:
+			if registry != nil {
+				registry.Unregister(op.InfoHash)
+			}
+		}
+	}
+}
+
+// applyAnnounce applies the announce operation on the local store
+func (n *node) applyAnnounce(op Operation) {
+	if op.Announce == nil {
+		return
+	}
+
+	switch op.Type {
+	case OperationSave:
+		n.Announce.Save(op.Announce)
+	case OperationRemove:
+		n.Announce.Remove(op.Announce.InfoHash, op.Announce.Peer.ID)
+	case OperationRemoveSwarm:
+		n.Announce.RemoveSwarm(op.Announce.InfoHash)
+	}
+}
+
+// ReplicateForever sends the pending operations to the other nodes every flush interval
+func (n *node) ReplicateForever() error {
+	ticker := time.NewTicker(n.cfg.FlushInterval)
+	log.Printf("Starting cluster replication to %v", n.cfg.Peers)
+	for range ticker.C {
+		n.Flush()
+	}
+
+	return nil
+}
+
+// Flush sends the pending operations to the other nodes
+// Nodes failing to receive them miss them, until the peers announce again.
+func (n *node) Flush() {
+	n.mutex.Lock()
+	ops := n.pending
+	n.pending = nil
+	n.mutex.Unlock()
+
+	if len(ops) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ops); err != nil {
+		log.Printf("cluster: failed to encode operations: %s", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range n.cfg.Peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+
+			if err := n.send(peer, buf.Bytes()); err != nil {
+				log.Printf("cluster: failed to replicate %d operations to %s: %s", len(ops), peer, err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+func (n *node) send(peer string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, "http://"+peer+ReplicatePath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+n.cfg.Token)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node responded %s", resp.Status)
+	}
+
+	return nil
+}
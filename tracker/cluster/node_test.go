@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/handlers"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// testTracker is a tracker serving UDP announces from a cluster node store
+type testTracker struct {
+	addr string
+	node Node
+}
+
+// newTestCluster starts size trackers on loopback ports, each one replicating its announces to the others
+func newTestCluster(t *testing.T, size int, token string) []*testTracker {
+	nodeAddrs := make([]string, size)
+	for i := range nodeAddrs {
+		nodeAddrs[i] = freeAddr(t)
+	}
+
+	trackers := make([]*testTracker, size)
+	for i := range trackers {
+		var peers []string
+		for j, addr := range nodeAddrs {
+			if j != i {
+				peers = append(peers, addr)
+			}
+		}
+
+		node := NewNode(Config{Addr: nodeAddrs[i], Peers: peers, Token: token}, store.NewAnnounceMemory())
+		go node.Listen()
+
+		connections := handlers.NewConnectionIDs([]byte("secret"), actions.ConnectionIDLifetime)
+		router := actions.NewRouter()
+		router.Register(actions.ConnectID, handlers.NewConnect(connections))
+		router.Register(actions.AnnounceID, handlers.NewAnnounce(node, store.NewSecretsMemory(), connections, handlers.AnnounceConfig{Interval: time.Minute}))
+
+		addr := freeAddr(t)
+		go tracker.NewServer(tracker.ServerConfig{Addr: addr, Protocol: tracker.ProtocolUDP}, actions.NewReader(), router).Listen(context.Background())
+
+		trackers[i] = &testTracker{addr: addr, node: node}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	return trackers
+}
+
+func TestNode(t *testing.T) {
+	g := &gorrent.Gorrent{Files: []gorrent.File{{Name: "f"}}}
+
+	t.Run("Peers announcing to any tracker get the peers announced to the others", func(t *testing.T) {
+		trackers := newTestCluster(t, 3, "token")
+
+		seeder := tracker.NewClient(*gorrent.NewPeer("seeder", net.ParseIP("127.0.0.1"), 1), tracker.ProtocolUDP)
+		leecher := tracker.NewClient(*gorrent.NewPeer("leecher", net.ParseIP("127.0.0.1"), 2), tracker.ProtocolUDP)
+
+		if _, err := seeder.Announce(trackers[0].addr, g, actions.AnnounceEventStarted, actions.AnnounceStatus{}); err != nil {
+			t.Fatal(err)
+		}
+		trackers[0].node.Flush()
+
+		for _, tr := range trackers[1:] {
+			resp, err := leecher.Announce(tr.addr, g, actions.AnnounceEventStarted, actions.AnnounceStatus{Left: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []gorrent.PeerAddr{gorrent.NewPeerAddr(net.ParseIP("127.0.0.1"), 1)}
+			if fmt.Sprint(resp.Peers) != fmt.Sprint(expected) {
+				t.Fatalf("Expected %s peers to be %s, got %s", tr.addr, expected, resp.Peers)
+			}
+		}
+	})
+
+	t.Run("Removed announces are removed from the other nodes", func(t *testing.T) {
+		trackers := newTestCluster(t, 2, "token")
+
+		a := &actions.Announce{InfoHash: gorrent.RandomSha1Hash(), Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}}
+		trackers[0].node.Save(a)
+		trackers[0].node.Flush()
+
+		if trackers[1].node.Find(a.InfoHash, a.Peer.ID) == nil {
+			t.Fatalf("Expected announce to be replicated")
+		}
+
+		trackers[1].node.Remove(a.InfoHash, a.Peer.ID)
+		trackers[1].node.Flush()
+
+		if trackers[0].node.Find(a.InfoHash, a.Peer.ID) != nil {
+			t.Fatalf("Expected announce removal to be replicated")
+		}
+
+		trackers[0].node.Save(a)
+		trackers[0].node.Flush()
+		trackers[1].node.RemoveSwarm(a.InfoHash)
+		trackers[1].node.Flush()
+
+		if len(trackers[0].node.InfoHashes()) != 0 {
+			t.Fatalf("Expected swarm removal to be replicated")
+		}
+	})
+
+	t.Run("Registered gorrents are registered on the other nodes", func(t *testing.T) {
+		trackers := newTestCluster(t, 2, "token")
+
+		local := store.NewRegistryMemory()
+		remote := store.NewRegistryMemory()
+		registry := trackers[0].node.Registry(local)
+		trackers[1].node.Registry(remote)
+
+		infoHash := gorrent.RandomSha1Hash()
+		registry.Register(infoHash, []byte("secret"))
+		trackers[0].node.Flush()
+
+		if !local.Registered(infoHash) {
+			t.Fatalf("Expected gorrent to be registered locally")
+		}
+
+		if secret, ok := remote.Secret(infoHash); !ok || string(secret) != "secret" {
+			t.Fatalf("Expected registration and its secret to be replicated, got %s", secret)
+		}
+
+		registry.Unregister(infoHash)
+		trackers[0].node.Flush()
+
+		if remote.Registered(infoHash) {
+			t.Fatalf("Expected unregistration to be replicated")
+		}
+	})
+
+	t.Run("Nodes reject operations without the cluster token", func(t *testing.T) {
+		trackers := newTestCluster(t, 1, "token")
+
+		intruder := NewNode(Config{Peers: []string{trackers[0].node.(*node).cfg.Addr}, Token: "wrong"}, store.NewAnnounceMemory())
+
+		a := &actions.Announce{InfoHash: gorrent.RandomSha1Hash(), Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}}
+		intruder.Save(a)
+		intruder.Flush()
+
+		if trackers[0].node.Find(a.InfoHash, a.Peer.ID) != nil {
+			t.Fatalf("Expected announce not to be replicated")
+		}
+	})
+
+	t.Run("Nodes without a cluster token reject all operations", func(t *testing.T) {
+		trackers := newTestCluster(t, 1, "")
+
+		intruder := NewNode(Config{Peers: []string{trackers[0].node.(*node).cfg.Addr}}, store.NewAnnounceMemory())
+
+		a := &actions.Announce{InfoHash: gorrent.RandomSha1Hash(), Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}}
+		intruder.Save(a)
+		intruder.Flush()
+
+		if trackers[0].node.Find(a.InfoHash, a.Peer.ID) != nil {
+			t.Fatalf("Expected announce not to be replicated")
+		}
+	})
+}
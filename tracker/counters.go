@@ -0,0 +1,35 @@
+package tracker
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Counters holds how many actions a server received, and how many of them were dropped and why
+type Counters struct {
+	Received    uint64
+	RateLimited uint64
+	Oversized   uint64
+	Malformed   uint64
+	Failed      uint64
+}
+
+func (c Counters) String() string {
+	return fmt.Sprintf("received %d, rate limited %d, oversized %d, malformed %d, failed %d",
+		c.Received, c.RateLimited, c.Oversized, c.Malformed, c.Failed)
+}
+
+// snapshot atomically reads the counters, while they are being updated by the server
+func (c *Counters) snapshot() Counters {
+	return Counters{
+		Received:    atomic.LoadUint64(&c.Received),
+		RateLimited: atomic.LoadUint64(&c.RateLimited),
+		Oversized:   atomic.LoadUint64(&c.Oversized),
+		Malformed:   atomic.LoadUint64(&c.Malformed),
+		Failed:      atomic.LoadUint64(&c.Failed),
+	}
+}
+
+func (c *Counters) inc(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
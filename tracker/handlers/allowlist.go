@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+var (
+	// ErrUnregisteredInfoHash is returned when an action targets an info hash which is not registered on the tracker
+	ErrUnregisteredInfoHash = actions.NewError(actions.ErrorCodeUnregisteredInfoHash, "info hash is not registered")
+)
+
+type allowlist struct {
+	registry store.Registry
+	next     actions.Handler
+}
+
+// NewAllowlist returns a Handler passing the announce and scrape actions to next,
+// only when all their info hashes are registered in registry
+func NewAllowlist(registry store.Registry, next actions.Handler) actions.Handler {
+	return &allowlist{
+		registry: registry,
+		next:     next,
+	}
+}
+
+// Handle rejects the actions on unregistered info hashes
+func (h *allowlist) Handle(src actions.Source, a actions.Action) ([]byte, error) {
+	var infoHashes []gorrent.Sha1Hash
+
+	switch action := a.(type) {
+	case *actions.Announce:
+		infoHashes = []gorrent.Sha1Hash{action.InfoHash}
+	case *actions.Scrape:
+		infoHashes = action.InfoHashes
+	}
+
+	for _, infoHash := range infoHashes {
+		if !h.registry.Registered(infoHash) {
+			return nil, ErrUnregisteredInfoHash
+		}
+	}
+
+	return h.next.Handle(src, a)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+func TestAllowlist(t *testing.T) {
+	registered := gorrent.RandomSha1Hash()
+	registry := store.NewRegistryMemory()
+	registry.Register(registered, nil)
+
+	nextCalled := false
+	next := &actions.DummyHandler{
+		HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+			nextCalled = true
+			return []byte("ok"), nil
+		},
+	}
+
+	h := NewAllowlist(registry, next)
+
+	t.Run("Handle passes actions on registered info hashes", func(t *testing.T) {
+		for _, action := range []actions.Action{
+			&actions.Announce{InfoHash: registered},
+			&actions.Scrape{InfoHashes: []gorrent.Sha1Hash{registered}},
+			&actions.Connect{},
+		} {
+			nextCalled = false
+			if _, err := h.Handle(testSource, action); err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			if !nextCalled {
+				t.Fatalf("Expected next handler to be called for %#v", action)
+			}
+		}
+	})
+
+	t.Run("Handle rejects actions on unregistered info hashes", func(t *testing.T) {
+		for _, action := range []actions.Action{
+			&actions.Announce{InfoHash: gorrent.RandomSha1Hash()},
+			&actions.Scrape{InfoHashes: []gorrent.Sha1Hash{registered, gorrent.RandomSha1Hash()}},
+		} {
+			nextCalled = false
+			if _, err := h.Handle(testSource, action); err != ErrUnregisteredInfoHash {
+				t.Fatalf("Expected err to be %s, got %v", ErrUnregisteredInfoHash, err)
+			}
+
+			if nextCalled {
+				t.Fatalf("Expected next handler not to be called for %#v", action)
+			}
+		}
+	})
+}
@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"errors"
-	"log"
 	"time"
 
+	"github.com/daeMOn63/gorrent/gorrent"
 	"github.com/daeMOn63/gorrent/tracker/actions"
 	"github.com/daeMOn63/gorrent/tracker/store"
 )
@@ -12,45 +12,133 @@ import (
 var (
 	// ErrBadAction is returned when the handler receive an unexpected action
 	ErrBadAction = errors.New("given action is not a valid announce action")
+	// ErrInvalidProof is returned when an announce on a private gorrent does not prove the knowledge of the swarm secret
+	ErrInvalidProof = actions.NewError(actions.ErrorCodeInvalidProof, "invalid swarm secret proof")
 )
 
+const (
+	// DefaultAnnounceInterval is the default delay peers are asked to wait between their announces
+	DefaultAnnounceInterval = 30 * time.Second
+	// DefaultMinAnnounceInterval is the default minimum delay peers must wait between their announces
+	DefaultMinAnnounceInterval = 10 * time.Second
+	// PeerAgeIntervals defines after how many announce intervals without announce a peer is considered dead
+	PeerAgeIntervals = 2
+	// DefaultMaxPeers is the default maximum number of peers returned in an announce response
+	DefaultMaxPeers = 50
+)
+
+// AnnounceConfig holds the announce handler options
+type AnnounceConfig struct {
+	// Interval is sent to the peers as the delay to wait before their next announce
+	Interval time.Duration
+	// MinInterval is sent to the peers as the minimum delay to wait before their next announce
+	MinInterval time.Duration
+	// MaxPeers caps the number of peers returned in a response, whatever the peers ask for. 0 means DefaultMaxPeers
+	MaxPeers int
+	// UseSourceIP replaces the IP reported by the peers by the one their announce is received from
+	UseSourceIP bool
+	// PeerAge overrides the age after which peers are considered dead. 0 means PeerAgeIntervals announce intervals
+	PeerAge time.Duration
+}
+
+// MaxPeerAge returns the age after which peers are considered dead, leaving them time to miss an announce
+func (c AnnounceConfig) MaxPeerAge() time.Duration {
+	if c.PeerAge > 0 {
+		return c.PeerAge
+	}
+
+	return PeerAgeIntervals * c.Interval
+}
+
 type announce struct {
-	store      store.Announce
-	maxPeerAge time.Duration
+	store       store.Announce
+	secrets     store.SwarmSecrets
+	connections ConnectionIDs
+	cfg         AnnounceConfig
 }
 
 // NewAnnounce returns a new Handler for announce actions
-func NewAnnounce(store store.Announce, maxPeerAge time.Duration) actions.Handler {
+// Announces must hold a connection ID issued by connections to their source.
+func NewAnnounce(store store.Announce, secrets store.SwarmSecrets, connections ConnectionIDs, cfg AnnounceConfig) actions.Handler {
 	return &announce{
-		store:      store,
-		maxPeerAge: maxPeerAge,
+		store:       store,
+		secrets:     secrets,
+		connections: connections,
+		cfg:         cfg,
 	}
 }
 
 // Handle process the announce action
-func (h *announce) Handle(a actions.Action) ([]byte, error) {
+func (h *announce) Handle(src actions.Source, a actions.Action) ([]byte, error) {
 	announceAction, ok := a.(*actions.Announce)
 	if !ok {
 		return nil, ErrBadAction
 	}
 
-	log.Printf("announce %s from %s - %#x", announceAction.Event.Name(), announceAction.Peer.ID, announceAction.InfoHash)
+	ip := src.IP()
+	if ip == nil {
+		return nil, ErrUnknownSource
+	}
+
+	if !h.connections.Verify(announceAction.ConnectionID, ip, time.Now()) {
+		return nil, ErrInvalidConnectionID
+	}
+
+	if secret, ok := h.secrets.Secret(announceAction.InfoHash); ok {
+		if !announceAction.VerifyProof(secret, time.Now()) {
+			return nil, ErrInvalidProof
+		}
+	}
+
+	// The proof covers the reported address, so it is only replaced once verified
+	if h.cfg.UseSourceIP {
+		announceAction.Peer.PeerAddr = gorrent.NewPeerAddr(ip, announceAction.Peer.Port)
+	}
+
+	response := &actions.AnnounceResponse{
+		Interval:    h.cfg.Interval,
+		MinInterval: h.cfg.MinInterval,
+	}
+
+	// A stopped peer leaves the swarm right away, and has no use of other peers
+	if announceAction.Event == actions.AnnounceEventStopped {
+		h.store.Remove(announceAction.InfoHash, announceAction.Peer.ID)
+
+		return response.MarshalBinary()
+	}
 
 	h.store.Save(announceAction)
 
-	peers := h.store.FindPeers(announceAction.InfoHash, h.maxPeerAge)
+	numWant := h.numWant(announceAction)
 
-	var out []byte
+	// One more peer is requested, as the announcing peer may be picked.
+	// Leechers get seeders first, as they are the ones holding the most pieces.
+	leecher := announceAction.Status.Left > 0
+	peers := h.store.FindPeers(announceAction.InfoHash, h.cfg.MaxPeerAge(), numWant+1, leecher)
 	for _, p := range peers {
-		if p.ID != announceAction.Peer.ID {
-			b, err := p.Bytes()
-			if err != nil {
-				return nil, err
-			}
+		if len(response.Peers) >= numWant {
+			break
+		}
 
-			out = append(out, b...)
+		if p.ID != announceAction.Peer.ID {
+			// A smaller IPv4 address may still fit after a larger IPv6 one did not
+			response.AddPeer(p.PeerAddr, src.ResponseSize())
 		}
 	}
 
-	return out, nil
+	return response.MarshalBinary()
+}
+
+// numWant returns how many peers to respond with, as wanted by the peer but capped to the configured maximum
+func (h *announce) numWant(a *actions.Announce) int {
+	max := h.cfg.MaxPeers
+	if max <= 0 {
+		max = DefaultMaxPeers
+	}
+
+	if a.NumWant == 0 || int(a.NumWant) > max {
+		return max
+	}
+
+	return int(a.NumWant)
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"net"
 	"reflect"
 	"testing"
 	"time"
@@ -11,13 +12,21 @@ import (
 	"github.com/daeMOn63/gorrent/tracker/store"
 )
 
+var testSource = actions.Source{Addr: &net.UDPAddr{IP: net.ParseIP("10.0.0.9"), Port: 1}}
+
+var acceptAllConnections = &DummyConnectionIDs{
+	VerifyFunc: func(id uint64, ip net.IP, now time.Time) bool {
+		return true
+	},
+}
+
 func TestAnnounce(t *testing.T) {
 	t.Run("Handle fail on invalid action", func(t *testing.T) {
-		store := &store.DummyAnnounce{}
-		h := NewAnnounce(store, 1*time.Second)
+		announceStore := &store.DummyAnnounce{}
+		h := NewAnnounce(announceStore, store.NewSecretsMemory(), acceptAllConnections, AnnounceConfig{Interval: time.Second})
 
 		action := &actions.DummyAction{}
-		out, err := h.Handle(action)
+		out, err := h.Handle(testSource, action)
 		if out != nil {
 			t.Fatalf("Expected out to be nil, got %v", out)
 		}
@@ -34,40 +43,32 @@ func TestAnnounce(t *testing.T) {
 			InfoHash: expectedInfoHash,
 			Event:    actions.AnnounceEventStarted,
 			Peer: gorrent.Peer{
-				ID: gorrent.PeerID(gorrent.RandomSha1Hash()),
-				PeerAddr: gorrent.PeerAddr{
-					IPAddr: 1,
-					Port:   2,
-				},
+				ID:       gorrent.PeerID(gorrent.RandomSha1Hash()),
+				PeerAddr: gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), 2),
 			},
 		}
 
 		expectedPeer1 := gorrent.Peer{
-			ID: gorrent.PeerID(gorrent.RandomSha1Hash()),
-			PeerAddr: gorrent.PeerAddr{
-				IPAddr: 5,
-				Port:   6,
-			},
+			ID:       gorrent.PeerID(gorrent.RandomSha1Hash()),
+			PeerAddr: gorrent.NewPeerAddr(net.ParseIP("10.0.0.5"), 6),
 		}
 
 		expectedPeer2 := gorrent.Peer{
-			ID: gorrent.PeerID(gorrent.RandomSha1Hash()),
-			PeerAddr: gorrent.PeerAddr{
-				IPAddr: 7,
-				Port:   8,
-			},
+			ID:       gorrent.PeerID(gorrent.RandomSha1Hash()),
+			PeerAddr: gorrent.NewPeerAddr(net.ParseIP("2001:db8::7"), 8),
 		}
 
 		expectedPeers := []gorrent.Peer{expectedPeer1, expectedPeer2}
-		expectedMaxAge := 1 * time.Second
+		cfg := AnnounceConfig{Interval: 30 * time.Second, MinInterval: 10 * time.Second}
+		expectedMaxAge := PeerAgeIntervals * cfg.Interval
 
-		store := &store.DummyAnnounce{
+		announceStore := &store.DummyAnnounce{
 			SaveFunc: func(announce *actions.Announce) {
 				if reflect.DeepEqual(announce, expectedAction) == false {
 					t.Fatalf("Expected action to be %#v, got %#v", expectedAction, announce)
 				}
 			},
-			FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration) []gorrent.Peer {
+			FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
 				if reflect.DeepEqual(infoHash, expectedInfoHash) == false {
 					t.Fatalf("Expected infoHash to be %v, got %v", expectedInfoHash, infoHash)
 				}
@@ -76,31 +77,329 @@ func TestAnnounce(t *testing.T) {
 					t.Fatalf("Expected maxAge to be %#v, got %#v", expectedMaxAge, maxAge)
 				}
 
+				if max != DefaultMaxPeers+1 {
+					t.Fatalf("Expected max to be %d, got %d", DefaultMaxPeers+1, max)
+				}
+
+				if preferSeeders {
+					t.Fatalf("Expected seeders not to be preferred for a seeder")
+				}
+
 				return expectedPeers
 			},
 		}
 
-		h := NewAnnounce(store, expectedMaxAge)
+		h := NewAnnounce(announceStore, store.NewSecretsMemory(), acceptAllConnections, cfg)
+
+		out, err := h.Handle(testSource, expectedAction)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		response := &actions.AnnounceResponse{}
+		if err := response.UnmarshalBinary(out); err != nil {
+			t.Fatal(err)
+		}
+
+		if response.Interval != cfg.Interval || response.MinInterval != cfg.MinInterval {
+			t.Fatalf("Expected intervals to be %s / %s, got %s / %s", cfg.Interval, cfg.MinInterval, response.Interval, response.MinInterval)
+		}
+
+		expectedAddrs := []gorrent.PeerAddr{expectedPeer1.PeerAddr, expectedPeer2.PeerAddr}
+		if reflect.DeepEqual(response.Peers, expectedAddrs) == false {
+			t.Fatalf("Expected peers to be %v, got %v", expectedAddrs, response.Peers)
+		}
+	})
+
+	t.Run("Handle honors numWant within the configured maximum, and prefers seeders for leechers", func(t *testing.T) {
+		var peers []gorrent.Peer
+		for i := 0; i < 10; i++ {
+			peers = append(peers, gorrent.Peer{
+				ID:       gorrent.PeerID(gorrent.RandomSha1Hash()),
+				PeerAddr: gorrent.NewPeerAddr(net.ParseIP("10.0.0.1"), uint16(i)),
+			})
+		}
+
+		var expectedMax int
+		announceStore := &store.DummyAnnounce{
+			SaveFunc: func(announce *actions.Announce) {},
+			FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
+				if max != expectedMax+1 {
+					t.Fatalf("Expected max to be %d, got %d", expectedMax+1, max)
+				}
+
+				if !preferSeeders {
+					t.Fatalf("Expected seeders to be preferred for a leecher")
+				}
+
+				return peers
+			},
+		}
+
+		h := NewAnnounce(announceStore, store.NewSecretsMemory(), acceptAllConnections, AnnounceConfig{Interval: time.Second, MaxPeers: 5})
+
+		for numWant, expected := range map[uint16]int{0: 5, 3: 3, 8: 5} {
+			expectedMax = expected
+
+			out, err := h.Handle(testSource, &actions.Announce{
+				InfoHash: gorrent.RandomSha1Hash(),
+				Peer:     peers[0],
+				Status:   actions.AnnounceStatus{Left: 1},
+				NumWant:  numWant,
+			})
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+
+			response := &actions.AnnounceResponse{}
+			if err := response.UnmarshalBinary(out); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(response.Peers) != expected {
+				t.Fatalf("Expected %d peers for numWant %d, got %d", expected, numWant, len(response.Peers))
+			}
+
+			for _, addr := range response.Peers {
+				if addr == peers[0].PeerAddr {
+					t.Fatalf("Expected announcing peer not to be returned")
+				}
+			}
+		}
+	})
+
+	t.Run("Handle never responds over the maximum payload size", func(t *testing.T) {
+		var peers []gorrent.Peer
+		for i := 0; i < 100; i++ {
+			peers = append(peers, gorrent.Peer{
+				ID:       gorrent.PeerID(gorrent.RandomSha1Hash()),
+				PeerAddr: gorrent.NewPeerAddr(net.ParseIP("2001:db8::1"), uint16(i)),
+			})
+		}
+
+		announceStore := &store.DummyAnnounce{
+			SaveFunc: func(announce *actions.Announce) {},
+			FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
+				return peers
+			},
+		}
+
+		h := NewAnnounce(announceStore, store.NewSecretsMemory(), acceptAllConnections, AnnounceConfig{Interval: time.Second, MaxPeers: 100})
 
-		out, err := h.Handle(expectedAction)
+		out, err := h.Handle(testSource, &actions.Announce{InfoHash: gorrent.RandomSha1Hash()})
 		if err != nil {
 			t.Fatalf("Expected no error, got %s", err)
 		}
 
-		addr1, err := expectedPeer1.PeerAddr.Bytes()
+		if len(out)+actions.FrameOverhead > actions.MaxPayloadSize {
+			t.Fatalf("Expected framed response len to be at most %d, got %d", actions.MaxPayloadSize, len(out)+actions.FrameOverhead)
+		}
+	})
+
+	t.Run("Handle fills larger responses to stream sources", func(t *testing.T) {
+		var peers []gorrent.Peer
+		for i := 0; i < 100; i++ {
+			peers = append(peers, gorrent.Peer{
+				ID:       gorrent.PeerID(gorrent.RandomSha1Hash()),
+				PeerAddr: gorrent.NewPeerAddr(net.ParseIP("2001:db8::1"), uint16(i)),
+			})
+		}
+
+		announceStore := &store.DummyAnnounce{
+			SaveFunc: func(announce *actions.Announce) {},
+			FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
+				return peers
+			},
+		}
+
+		h := NewAnnounce(announceStore, store.NewSecretsMemory(), acceptAllConnections, AnnounceConfig{Interval: time.Second, MaxPeers: 100})
+
+		src := actions.Source{Addr: testSource.Addr, MaxResponseSize: actions.MaxStreamResponseSize}
+		out, err := h.Handle(src, &actions.Announce{InfoHash: gorrent.RandomSha1Hash()})
 		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		response := &actions.AnnounceResponse{}
+		if err := response.UnmarshalBinary(out); err != nil {
 			t.Fatal(err)
 		}
-		addr2, err := expectedPeer2.PeerAddr.Bytes()
+
+		if len(response.Peers) != len(peers) {
+			t.Fatalf("Expected %d peers, got %d", len(peers), len(response.Peers))
+		}
+	})
+
+	t.Run("Handle rejects announces without a valid connection ID", func(t *testing.T) {
+		connections := &DummyConnectionIDs{
+			VerifyFunc: func(id uint64, ip net.IP, now time.Time) bool {
+				if id != 42 || !ip.Equal(testSource.IP()) {
+					t.Fatalf("Expected connection ID 42 from %s, got %d from %s", testSource.IP(), id, ip)
+				}
+
+				return false
+			},
+		}
+
+		announceStore := &store.DummyAnnounce{
+			SaveFunc: func(announce *actions.Announce) {
+				t.Fatalf("Save was not expected")
+			},
+		}
+
+		h := NewAnnounce(announceStore, store.NewSecretsMemory(), connections, AnnounceConfig{Interval: time.Second})
+
+		out, err := h.Handle(testSource, &actions.Announce{ConnectionID: 42})
+		if out != nil {
+			t.Fatalf("Expected out to be nil, got %v", out)
+		}
+
+		if err != ErrInvalidConnectionID {
+			t.Fatalf("Expected err to be %v, got %v", ErrInvalidConnectionID, err)
+		}
+	})
+
+	t.Run("Handle replaces the reported IP by the source one with UseSourceIP", func(t *testing.T) {
+		expectedAddr := gorrent.NewPeerAddr(testSource.IP(), 6881)
+
+		saveCalled := false
+		announceStore := &store.DummyAnnounce{
+			SaveFunc: func(announce *actions.Announce) {
+				if announce.Peer.PeerAddr != expectedAddr {
+					t.Fatalf("Expected peer addr to be %s, got %s", expectedAddr, announce.Peer.PeerAddr)
+				}
+				saveCalled = true
+			},
+			FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
+				return nil
+			},
+		}
+
+		h := NewAnnounce(announceStore, store.NewSecretsMemory(), acceptAllConnections, AnnounceConfig{Interval: time.Second, UseSourceIP: true})
+
+		_, err := h.Handle(testSource, &actions.Announce{
+			Peer: gorrent.Peer{PeerAddr: gorrent.NewPeerAddr(net.ParseIP("192.168.1.1"), 6881)},
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if !saveCalled {
+			t.Fatalf("Expected SaveFunc to be called")
+		}
+	})
+
+	t.Run("Handle removes stopped peers without returning peers", func(t *testing.T) {
+		action := &actions.Announce{
+			InfoHash: gorrent.RandomSha1Hash(),
+			Event:    actions.AnnounceEventStopped,
+			Peer:     gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())},
+		}
+
+		removeCalled := false
+		announceStore := &store.DummyAnnounce{
+			SaveFunc: func(announce *actions.Announce) {
+				t.Fatalf("Save was not expected")
+			},
+			RemoveFunc: func(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) {
+				if infoHash != action.InfoHash || peerID != action.Peer.ID {
+					t.Fatalf("Expected %x / %x to be removed, got %x / %x", action.InfoHash, action.Peer.ID, infoHash, peerID)
+				}
+				removeCalled = true
+			},
+		}
+
+		h := NewAnnounce(announceStore, store.NewSecretsMemory(), acceptAllConnections, AnnounceConfig{Interval: time.Second})
+
+		out, err := h.Handle(testSource, action)
 		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if !removeCalled {
+			t.Fatalf("Expected RemoveFunc to be called")
+		}
+
+		response := &actions.AnnounceResponse{}
+		if err := response.UnmarshalBinary(out); err != nil {
 			t.Fatal(err)
 		}
 
-		expectedOut := addr1
-		expectedOut = append(expectedOut, addr2...)
+		if len(response.Peers) != 0 {
+			t.Fatalf("Expected no peers, got %v", response.Peers)
+		}
+	})
+
+	t.Run("Handle rejects announces on private gorrents without a valid proof", func(t *testing.T) {
+		infoHash := gorrent.RandomSha1Hash()
+		secrets := store.NewSecretsMemory()
+		secrets.Add(infoHash, []byte("secret"))
+
+		announceStore := &store.DummyAnnounce{
+			SaveFunc: func(announce *actions.Announce) {
+				t.Fatalf("Save was not expected")
+			},
+		}
+
+		h := NewAnnounce(announceStore, secrets, acceptAllConnections, AnnounceConfig{Interval: time.Second})
+
+		action := &actions.Announce{InfoHash: infoHash}
+		action.Sign([]byte("other"), time.Now())
+
+		out, err := h.Handle(testSource, action)
+		if out != nil {
+			t.Fatalf("Expected out to be nil, got %v", out)
+		}
+
+		if err != ErrInvalidProof {
+			t.Fatalf("Expected err to be %v, got %v", ErrInvalidProof, err)
+		}
+	})
+
+	t.Run("Handle accepts announces on private gorrents with a valid proof", func(t *testing.T) {
+		infoHash := gorrent.RandomSha1Hash()
+		secrets := store.NewSecretsMemory()
+		secrets.Add(infoHash, []byte("secret"))
+
+		saveCalled := false
+		announceStore := &store.DummyAnnounce{
+			SaveFunc: func(announce *actions.Announce) {
+				saveCalled = true
+			},
+			FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
+				return nil
+			},
+		}
+
+		h := NewAnnounce(announceStore, secrets, acceptAllConnections, AnnounceConfig{Interval: time.Second})
+
+		action := &actions.Announce{InfoHash: infoHash}
+		action.Sign([]byte("secret"), time.Now())
+
+		if _, err := h.Handle(testSource, action); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if !saveCalled {
+			t.Fatalf("Expected SaveFunc to be called")
+		}
+	})
+}
+
+func TestAnnounceConfig(t *testing.T) {
+	t.Run("MaxPeerAge defaults to PeerAgeIntervals announce intervals", func(t *testing.T) {
+		cfg := AnnounceConfig{Interval: 30 * time.Second}
+
+		if cfg.MaxPeerAge() != PeerAgeIntervals*cfg.Interval {
+			t.Fatalf("Expected max peer age to be %s, got %s", PeerAgeIntervals*cfg.Interval, cfg.MaxPeerAge())
+		}
+	})
+
+	t.Run("MaxPeerAge returns PeerAge when set", func(t *testing.T) {
+		cfg := AnnounceConfig{Interval: 30 * time.Second, PeerAge: 5 * time.Second}
 
-		if reflect.DeepEqual(out, expectedOut) == false {
-			t.Fatalf("Expected out to be %v, got %v", expectedOut, out)
+		if cfg.MaxPeerAge() != cfg.PeerAge {
+			t.Fatalf("Expected max peer age to be %s, got %s", cfg.PeerAge, cfg.MaxPeerAge())
 		}
 	})
 }
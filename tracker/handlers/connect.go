@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+var (
+	// ErrBadConnectAction is returned when the connect handler receive an unexpected action
+	ErrBadConnectAction = errors.New("given action is not a valid connect action")
+	// ErrInvalidConnectionID is returned when an announce holds a connection ID not issued to its source, or expired
+	ErrInvalidConnectionID = actions.NewError(actions.ErrorCodeInvalidConnectionID, "invalid connection ID")
+	// ErrUnknownSource is returned when the address an action has been received from is unknown
+	ErrUnknownSource = errors.New("unknown action source")
+)
+
+// ConnectionIDs issues and verifies connection IDs bound to an IP address
+type ConnectionIDs interface {
+	Issue(ip net.IP, now time.Time) uint64
+	Verify(id uint64, ip net.IP, now time.Time) bool
+}
+
+type connectionIDs struct {
+	secret   []byte
+	lifetime time.Duration
+}
+
+var _ ConnectionIDs = &connectionIDs{}
+var _ ConnectionIDs = &DummyConnectionIDs{}
+
+// NewConnectionIDs returns ConnectionIDs signed with secret, staying valid between lifetime and twice lifetime
+// Connection IDs are not stored, but derived from the secret, the IP and the current period of lifetime,
+// a connection ID being accepted during the period it was issued in and the next one.
+func NewConnectionIDs(secret []byte, lifetime time.Duration) ConnectionIDs {
+	return &connectionIDs{
+		secret:   secret,
+		lifetime: lifetime,
+	}
+}
+
+func (c *connectionIDs) Issue(ip net.IP, now time.Time) uint64 {
+	return c.id(ip, c.period(now))
+}
+
+func (c *connectionIDs) Verify(id uint64, ip net.IP, now time.Time) bool {
+	period := c.period(now)
+
+	return id == c.id(ip, period) || id == c.id(ip, period-1)
+}
+
+func (c *connectionIDs) period(now time.Time) int64 {
+	return now.UnixNano() / int64(c.lifetime)
+}
+
+func (c *connectionIDs) id(ip net.IP, period int64) uint64 {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(ip.To16())
+	binary.Write(mac, binary.BigEndian, period)
+
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// DummyConnectionIDs provides configurable ConnectionIDs
+type DummyConnectionIDs struct {
+	IssueFunc  func(ip net.IP, now time.Time) uint64
+	VerifyFunc func(id uint64, ip net.IP, now time.Time) bool
+}
+
+// Issue calls IssueFunc
+func (d *DummyConnectionIDs) Issue(ip net.IP, now time.Time) uint64 {
+	return d.IssueFunc(ip, now)
+}
+
+// Verify calls VerifyFunc
+func (d *DummyConnectionIDs) Verify(id uint64, ip net.IP, now time.Time) bool {
+	return d.VerifyFunc(id, ip, now)
+}
+
+type connect struct {
+	connections ConnectionIDs
+}
+
+// NewConnect returns a new Handler for connect actions
+func NewConnect(connections ConnectionIDs) actions.Handler {
+	return &connect{
+		connections: connections,
+	}
+}
+
+// Handle issues a connection ID bound to the action source
+func (h *connect) Handle(src actions.Source, a actions.Action) ([]byte, error) {
+	if _, ok := a.(*actions.Connect); !ok {
+		return nil, ErrBadConnectAction
+	}
+
+	ip := src.IP()
+	if ip == nil {
+		return nil, ErrUnknownSource
+	}
+
+	response := &actions.ConnectResponse{
+		ConnectionID: h.connections.Issue(ip, time.Now()),
+	}
+
+	return response.MarshalBinary()
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+func TestConnectionIDs(t *testing.T) {
+	t.Run("Connection IDs are bound to the IP and expire", func(t *testing.T) {
+		lifetime := time.Minute
+		c := NewConnectionIDs([]byte("secret"), lifetime)
+
+		ip := net.ParseIP("10.0.0.1")
+		now := time.Unix(0, 0).Add(10 * lifetime)
+		id := c.Issue(ip, now)
+
+		if !c.Verify(id, ip, now) {
+			t.Fatalf("Expected connection ID to be valid")
+		}
+
+		if !c.Verify(id, ip, now.Add(lifetime)) {
+			t.Fatalf("Expected connection ID to be valid for lifetime")
+		}
+
+		if c.Verify(id, ip, now.Add(2*lifetime)) {
+			t.Fatalf("Expected connection ID to be expired after twice lifetime")
+		}
+
+		if c.Verify(id, net.ParseIP("10.0.0.2"), now) {
+			t.Fatalf("Expected connection ID to be invalid from another IP")
+		}
+
+		if NewConnectionIDs([]byte("other"), lifetime).Verify(id, ip, now) {
+			t.Fatalf("Expected connection ID to be invalid with another secret")
+		}
+	})
+}
+
+func TestConnect(t *testing.T) {
+	t.Run("Handle fail on invalid action", func(t *testing.T) {
+		h := NewConnect(&DummyConnectionIDs{})
+
+		out, err := h.Handle(testSource, &actions.DummyAction{})
+		if out != nil {
+			t.Fatalf("Expected out to be nil, got %v", out)
+		}
+
+		if err != ErrBadConnectAction {
+			t.Fatalf("Expected err to be %v, got %v", ErrBadConnectAction, err)
+		}
+	})
+
+	t.Run("Handle returns a connection ID issued for the source IP", func(t *testing.T) {
+		h := NewConnect(&DummyConnectionIDs{
+			IssueFunc: func(ip net.IP, now time.Time) uint64 {
+				if !ip.Equal(testSource.IP()) {
+					t.Fatalf("Expected ip to be %s, got %s", testSource.IP(), ip)
+				}
+
+				return 42
+			},
+		})
+
+		out, err := h.Handle(testSource, &actions.Connect{})
+		if err != nil {
+			t.Fatalf("Expected err to be nil, got %s", err)
+		}
+
+		response := &actions.ConnectResponse{}
+		if err := response.UnmarshalBinary(out); err != nil {
+			t.Fatal(err)
+		}
+
+		if response.ConnectionID != 42 {
+			t.Fatalf("Expected connection ID to be 42, got %d", response.ConnectionID)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+var (
+	// ErrTooManyInfoHashes is returned when a source announces more info hashes than allowed
+	ErrTooManyInfoHashes = actions.NewError(actions.ErrorCodeTooManyInfoHashes, "too many info hashes announced")
+)
+
+type infoHashLimit struct {
+	max    int
+	maxAge time.Duration
+	next   actions.Handler
+
+	mutex    sync.Mutex
+	sources  map[string]map[gorrent.Sha1Hash]time.Time
+	prunedAt time.Time
+}
+
+// NewInfoHashLimit returns a Handler passing the announces to next, only when their source
+// announced less than max other info hashes during the last maxAge
+func NewInfoHashLimit(max int, maxAge time.Duration, next actions.Handler) actions.Handler {
+	return &infoHashLimit{
+		max:     max,
+		maxAge:  maxAge,
+		next:    next,
+		sources: make(map[string]map[gorrent.Sha1Hash]time.Time),
+	}
+}
+
+// Handle rejects the announces of a new info hash when their source reached the limit
+// Info hashes are only counted once next accepted the announce, so rejected ones, like
+// announces without a valid connection ID, can't use up the limit of another source.
+func (h *infoHashLimit) Handle(src actions.Source, a actions.Action) ([]byte, error) {
+	announce, ok := a.(*actions.Announce)
+	if !ok {
+		return h.next.Handle(src, a)
+	}
+
+	ip := src.IP()
+	if ip == nil {
+		return nil, ErrUnknownSource
+	}
+
+	source := ip.String()
+	now := time.Now()
+	if !h.allow(source, announce.InfoHash, now) {
+		return nil, ErrTooManyInfoHashes
+	}
+
+	resp, err := h.next.Handle(src, a)
+	if err != nil {
+		return nil, err
+	}
+
+	h.track(source, announce, now)
+
+	return resp, nil
+}
+
+// allow returns true when the source already announced infoHash, or is below the limit
+func (h *infoHashLimit) allow(source string, infoHash gorrent.Sha1Hash, now time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if now.Sub(h.prunedAt) >= h.maxAge {
+		h.prune(now)
+	}
+
+	infoHashes := h.sources[source]
+	if _, ok := infoHashes[infoHash]; ok {
+		return true
+	}
+
+	return len(infoHashes) < h.max
+}
+
+// track records the announce info hash for the source, forgetting it when the peer stopped
+func (h *infoHashLimit) track(source string, announce *actions.Announce, now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	infoHashes, ok := h.sources[source]
+	if !ok {
+		infoHashes = make(map[gorrent.Sha1Hash]time.Time)
+		h.sources[source] = infoHashes
+	}
+
+	if announce.Event == actions.AnnounceEventStopped {
+		delete(infoHashes, announce.InfoHash)
+		return
+	}
+
+	infoHashes[announce.InfoHash] = now
+}
+
+// prune forgets the info hashes not announced during the last maxAge, and the sources left without any
+func (h *infoHashLimit) prune(now time.Time) {
+	limit := now.Add(-h.maxAge)
+	for source, infoHashes := range h.sources {
+		for infoHash, announcedAt := range infoHashes {
+			if !announcedAt.After(limit) {
+				delete(infoHashes, infoHash)
+			}
+		}
+
+		if len(infoHashes) == 0 {
+			delete(h.sources, source)
+		}
+	}
+
+	h.prunedAt = now
+}
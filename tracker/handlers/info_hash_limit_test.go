@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+func TestInfoHashLimit(t *testing.T) {
+	var nextErr error
+	next := &actions.DummyHandler{
+		HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+			return []byte("ok"), nextErr
+		},
+	}
+
+	t.Run("Handle rejects new info hashes once the source reached the limit", func(t *testing.T) {
+		h := NewInfoHashLimit(2, time.Minute, next)
+
+		first := &actions.Announce{InfoHash: gorrent.RandomSha1Hash()}
+		second := &actions.Announce{InfoHash: gorrent.RandomSha1Hash()}
+		for _, a := range []*actions.Announce{first, second, first} {
+			if _, err := h.Handle(testSource, a); err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+		}
+
+		third := &actions.Announce{InfoHash: gorrent.RandomSha1Hash()}
+		if _, err := h.Handle(testSource, third); err != ErrTooManyInfoHashes {
+			t.Fatalf("Expected err to be %s, got %v", ErrTooManyInfoHashes, err)
+		}
+
+		other := actions.Source{Addr: &net.UDPAddr{IP: net.ParseIP("10.0.0.10"), Port: 1}}
+		if _, err := h.Handle(other, third); err != nil {
+			t.Fatalf("Expected other sources not to be limited, got %s", err)
+		}
+
+		stopped := &actions.Announce{InfoHash: first.InfoHash, Event: actions.AnnounceEventStopped}
+		if _, err := h.Handle(testSource, stopped); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		if _, err := h.Handle(testSource, third); err != nil {
+			t.Fatalf("Expected stopped info hash to free the limit, got %s", err)
+		}
+	})
+
+	t.Run("Handle does not count announces rejected by next", func(t *testing.T) {
+		h := NewInfoHashLimit(1, time.Minute, next)
+
+		nextErr = errors.New("rejected")
+		if _, err := h.Handle(testSource, &actions.Announce{InfoHash: gorrent.RandomSha1Hash()}); err != nextErr {
+			t.Fatalf("Expected err to be %s, got %v", nextErr, err)
+		}
+		nextErr = nil
+
+		if _, err := h.Handle(testSource, &actions.Announce{InfoHash: gorrent.RandomSha1Hash()}); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+	})
+
+	t.Run("Handle forgets info hashes older than maxAge", func(t *testing.T) {
+		h := NewInfoHashLimit(1, time.Millisecond, next)
+
+		if _, err := h.Handle(testSource, &actions.Announce{InfoHash: gorrent.RandomSha1Hash()}); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+
+		time.Sleep(2 * time.Millisecond)
+
+		if _, err := h.Handle(testSource, &actions.Announce{InfoHash: gorrent.RandomSha1Hash()}); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+	})
+}
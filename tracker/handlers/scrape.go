@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+var (
+	// ErrBadScrapeAction is returned when the scrape handler receive an unexpected action
+	ErrBadScrapeAction = errors.New("given action is not a valid scrape action")
+)
+
+type scrape struct {
+	store      store.Announce
+	secrets    store.SwarmSecrets
+	maxPeerAge time.Duration
+}
+
+// NewScrape returns a new Handler for scrape actions
+// Peers which did not announce within maxPeerAge are not counted.
+// Private swarms statistics are zeroed unless the scrape proves the knowledge of their secret.
+func NewScrape(store store.Announce, secrets store.SwarmSecrets, maxPeerAge time.Duration) actions.Handler {
+	return &scrape{
+		store:      store,
+		secrets:    secrets,
+		maxPeerAge: maxPeerAge,
+	}
+}
+
+// Handle process the scrape action
+func (h *scrape) Handle(src actions.Source, a actions.Action) ([]byte, error) {
+	scrapeAction, ok := a.(*actions.Scrape)
+	if !ok {
+		return nil, ErrBadScrapeAction
+	}
+
+	now := time.Now()
+	response := &actions.ScrapeResponse{}
+	for i, infoHash := range scrapeAction.InfoHashes {
+		if secret, ok := h.secrets.Secret(infoHash); ok && !scrapeAction.VerifyProof(i, secret, now) {
+			response.Stats = append(response.Stats, actions.ScrapeStats{})
+
+			continue
+		}
+
+		stats := h.store.Stats(infoHash, h.maxPeerAge)
+		response.Stats = append(response.Stats, actions.ScrapeStats{
+			Seeders:   stats.Seeders,
+			Leechers:  stats.Leechers,
+			Completed: stats.Completed,
+		})
+	}
+
+	return response.MarshalBinary()
+}
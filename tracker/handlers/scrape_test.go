@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+	"github.com/daeMOn63/gorrent/tracker/store"
+)
+
+func TestScrape(t *testing.T) {
+	t.Run("Handle fail on invalid action", func(t *testing.T) {
+		h := NewScrape(&store.DummyAnnounce{}, store.NewSecretsMemory(), time.Second)
+
+		out, err := h.Handle(testSource, &actions.DummyAction{})
+		if out != nil {
+			t.Fatalf("Expected out to be nil, got %v", out)
+		}
+
+		if err != ErrBadScrapeAction {
+			t.Fatalf("Expected err to be %v, got %v", ErrBadScrapeAction, err)
+		}
+	})
+
+	t.Run("Handle returns the stats of each info hash in order", func(t *testing.T) {
+		infoHash1 := gorrent.RandomSha1Hash()
+		infoHash2 := gorrent.RandomSha1Hash()
+		expectedMaxAge := 3 * time.Second
+
+		announceStore := &store.DummyAnnounce{
+			StatsFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration) store.Stats {
+				if maxAge != expectedMaxAge {
+					t.Fatalf("Expected maxAge to be %s, got %s", expectedMaxAge, maxAge)
+				}
+
+				if infoHash == infoHash1 {
+					return store.Stats{Seeders: 1, Leechers: 2, Completed: 3}
+				}
+
+				return store.Stats{}
+			},
+		}
+
+		h := NewScrape(announceStore, store.NewSecretsMemory(), expectedMaxAge)
+
+		out, err := h.Handle(testSource, &actions.Scrape{InfoHashes: []gorrent.Sha1Hash{infoHash2, infoHash1}})
+		if err != nil {
+			t.Fatalf("Expected err to be nil, got %s", err)
+		}
+
+		response := &actions.ScrapeResponse{}
+		if err := response.UnmarshalBinary(out); err != nil {
+			t.Fatal(err)
+		}
+
+		expectedStats := []actions.ScrapeStats{{}, {Seeders: 1, Leechers: 2, Completed: 3}}
+		if reflect.DeepEqual(response.Stats, expectedStats) == false {
+			t.Fatalf("Expected stats to be %#v, got %#v", expectedStats, response.Stats)
+		}
+	})
+
+	t.Run("Handle zeroes the private swarms stats without a valid proof", func(t *testing.T) {
+		infoHash := gorrent.RandomSha1Hash()
+		secret := []byte("secret")
+
+		secrets := store.NewSecretsMemory()
+		secrets.Add(infoHash, secret)
+
+		announceStore := &store.DummyAnnounce{
+			StatsFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration) store.Stats {
+				return store.Stats{Seeders: 1, Leechers: 2, Completed: 3}
+			},
+		}
+
+		h := NewScrape(announceStore, secrets, time.Second)
+
+		wrongProof := &actions.Scrape{InfoHashes: []gorrent.Sha1Hash{infoHash}}
+		wrongProof.Sign(map[gorrent.Sha1Hash][]byte{infoHash: []byte("other")}, time.Now())
+
+		validProof := &actions.Scrape{InfoHashes: []gorrent.Sha1Hash{infoHash}}
+		validProof.Sign(map[gorrent.Sha1Hash][]byte{infoHash: secret}, time.Now())
+
+		for _, tc := range []struct {
+			scrape   *actions.Scrape
+			expected actions.ScrapeStats
+		}{
+			{&actions.Scrape{InfoHashes: []gorrent.Sha1Hash{infoHash}}, actions.ScrapeStats{}},
+			{wrongProof, actions.ScrapeStats{}},
+			{validProof, actions.ScrapeStats{Seeders: 1, Leechers: 2, Completed: 3}},
+		} {
+			out, err := h.Handle(testSource, tc.scrape)
+			if err != nil {
+				t.Fatalf("Expected err to be nil, got %s", err)
+			}
+
+			response := &actions.ScrapeResponse{}
+			if err := response.UnmarshalBinary(out); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(response.Stats) != 1 || response.Stats[0] != tc.expected {
+				t.Fatalf("Expected stats to be %v, got %v", tc.expected, response.Stats)
+			}
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+const (
+	// HTTPActionPath is the path actions are posted to on HTTP trackers
+	HTTPActionPath = "/action"
+
+	httpContentType = "application/octet-stream"
+	jsonContentType = "application/json"
+)
+
+var httpClient = &http.Client{Timeout: RequestTimeout}
+
+// listenHTTP serves actions posted to HTTPActionPath, using the same binary framing as datagrams.
+// Responses are compact, unless the request accepts JSON.
+func (t *server) listenHTTP(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HTTPActionPath, t.handleHTTP)
+
+	server := &http.Server{
+		Addr:    t.cfg.Addr,
+		Handler: mux,
+	}
+
+	// Shutdown returns once the requests being handled are responded
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdown <- server.Shutdown(context.Background())
+	}()
+
+	var err error
+	if t.cfg.Protocol == ProtocolHTTPS {
+		err = server.ListenAndServeTLS(t.cfg.TLSCertFile, t.cfg.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	return <-shutdown
+}
+
+func (t *server) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, actions.MaxPayloadSize))
+	if !t.allow(client) {
+		if frame := rateLimitedFrame(buf); err == nil && frame != nil {
+			w.Header().Set("Content-Type", httpContentType)
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write(frame)
+			return
+		}
+
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	if err != nil {
+		t.counters.inc(&t.counters.Oversized)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	t.acquire()
+	defer t.release()
+
+	frame := t.serve(actions.Source{Addr: client, MaxResponseSize: actions.MaxStreamResponseSize}, buf, true)
+	if frame == nil {
+		http.Error(w, actions.ErrInvalidHeader.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	if isErrorFrame(frame) {
+		status = http.StatusBadRequest
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), jsonContentType) {
+		w.Header().Set("Content-Type", httpContentType)
+		w.WriteHeader(status)
+		w.Write(frame)
+		return
+	}
+
+	id := actions.ID(frame[actions.HeaderSize])
+	response := actions.NewResponse(id)
+	if response == nil || response.UnmarshalBinary(frame[actions.FrameOverhead:]) != nil {
+		http.Error(w, "no JSON representation", http.StatusNotAcceptable)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		t.logger.Printf("[%s] writing action %#x response error: %s", client, id, err)
+	}
+}
+
+// isHTTPURL returns true when addr is an http or https url rather than an ip:port
+func isHTTPURL(addr string) bool {
+	return strings.HasPrefix(addr, ProtocolHTTP+"://") || strings.HasPrefix(addr, ProtocolHTTPS+"://")
+}
+
+// httpRoundTrip posts the request to the tracker at url, and returns its compact response
+// Error responses are returned as well, so the client can decode them.
+func httpRoundTrip(url string, request []byte) ([]byte, error) {
+	resp, err := httpClient.Post(strings.TrimSuffix(url, "/")+HTTPActionPath, httpContentType, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.Header.Get("Content-Type") != httpContentType {
+		return nil, fmt.Errorf("tracker responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
@@ -0,0 +1,63 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+func TestHTTPServer(t *testing.T) {
+	addr, _ := newTestTracker(t, ProtocolHTTP)
+	url := "http://" + addr + HTTPActionPath
+
+	t.Run("Server responds with JSON when accepted", func(t *testing.T) {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(actions.NewHeader(1).Frame(actions.ConnectID, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", jsonContentType)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != jsonContentType {
+			t.Fatalf("Expected content type to be %s, got %s", jsonContentType, ct)
+		}
+
+		response := &actions.ConnectResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+
+		if response.ConnectionID == 0 {
+			t.Fatalf("Expected a connection ID")
+		}
+	})
+
+	t.Run("Server responds with an error status and response on invalid actions", func(t *testing.T) {
+		resp, err := http.Post(url, httpContentType, bytes.NewReader(actions.NewHeader(1).Frame(actions.AnnounceID, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status to be %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+
+		c := NewClient(gorrent.Peer{}, ProtocolHTTP).(*client)
+		_, err = c.roundTrip(addr, actions.AnnounceID, nil)
+
+		trackerErr, ok := err.(*actions.Error)
+		if !ok || trackerErr.Code != actions.ErrorCodeMalformedAction {
+			t.Fatalf("Expected a malformed action error, got %#v", err)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package tracker
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// bucketPruneInterval defines how often buckets refilled to their burst are forgotten
+	bucketPruneInterval = time.Minute
+)
+
+// RateLimiter decides whether an action received from ip can be processed
+type RateLimiter interface {
+	Allow(ip net.IP, now time.Time) bool
+}
+
+// tokenBucket holds the tokens left to a source IP at updatedAt
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex    sync.Mutex
+	buckets  map[string]*tokenBucket
+	prunedAt time.Time
+}
+
+var _ RateLimiter = &rateLimiter{}
+var _ RateLimiter = &DummyRateLimiter{}
+
+// NewRateLimiter returns a RateLimiter allowing each source IP rate actions per second, with bursts up to burst actions
+func NewRateLimiter(rate float64, burst int) RateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from the ip bucket, returning false when it is empty
+func (l *rateLimiter) Allow(ip net.IP, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.prunedAt) >= bucketPruneInterval {
+		l.prune(now)
+	}
+
+	key := ip.String()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updatedAt: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = l.refill(bucket, now)
+	bucket.updatedAt = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+
+	return true
+}
+
+// refill returns the tokens of bucket at now, never more than the burst
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.updatedAt).Seconds()*l.rate
+	if tokens > l.burst {
+		tokens = l.burst
+	}
+
+	return tokens
+}
+
+// prune forgets the buckets back to their burst, which behave as new ones
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.prunedAt = now
+}
+
+// DummyRateLimiter provides a configurable RateLimiter
+type DummyRateLimiter struct {
+	AllowFunc func(ip net.IP, now time.Time) bool
+}
+
+// Allow calls AllowFunc
+func (d *DummyRateLimiter) Allow(ip net.IP, now time.Time) bool {
+	return d.AllowFunc(ip, now)
+}
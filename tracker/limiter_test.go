@@ -0,0 +1,65 @@
+package tracker
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ip := net.ParseIP("10.0.0.1")
+
+	t.Run("Allow accepts bursts, then refills at rate", func(t *testing.T) {
+		l := NewRateLimiter(1, 2)
+		now := time.Now()
+
+		for i := 0; i < 2; i++ {
+			if !l.Allow(ip, now) {
+				t.Fatalf("Expected action %d to be allowed", i)
+			}
+		}
+
+		if l.Allow(ip, now) {
+			t.Fatalf("Expected action to be rate limited")
+		}
+
+		if !l.Allow(net.ParseIP("10.0.0.2"), now) {
+			t.Fatalf("Expected other ips not to be limited")
+		}
+
+		if !l.Allow(ip, now.Add(time.Second)) {
+			t.Fatalf("Expected action to be allowed after refill")
+		}
+	})
+
+	t.Run("Allow forgets refilled buckets", func(t *testing.T) {
+		l := NewRateLimiter(1, 2).(*rateLimiter)
+		now := time.Now()
+
+		l.Allow(ip, now)
+		l.Allow(net.ParseIP("10.0.0.2"), now.Add(bucketPruneInterval))
+
+		if _, ok := l.buckets[ip.String()]; ok {
+			t.Fatalf("Expected refilled bucket to be pruned")
+		}
+	})
+}
+
+func TestLogSampler(t *testing.T) {
+	s := newLogSampler(2)
+	now := time.Now().Truncate(time.Second)
+
+	for i := 0; i < 2; i++ {
+		if !s.sample(now) {
+			t.Fatalf("Expected message %d to be logged", i)
+		}
+	}
+
+	if s.sample(now) {
+		t.Fatalf("Expected message to be suppressed")
+	}
+
+	if !s.sample(now.Add(time.Second)) {
+		t.Fatalf("Expected message to be logged on next second")
+	}
+}
@@ -0,0 +1,68 @@
+package tracker
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxLogsPerSecond defines how many per action messages a server logs each second
+	DefaultMaxLogsPerSecond = 10
+)
+
+// logSampler logs up to max messages per second, and reports how many were suppressed,
+// so a flood of actions doesn't flood the logs too
+type logSampler struct {
+	max int
+
+	mutex      sync.Mutex
+	second     time.Time
+	logged     int
+	suppressed int
+}
+
+func newLogSampler(max int) *logSampler {
+	if max <= 0 {
+		max = DefaultMaxLogsPerSecond
+	}
+
+	return &logSampler{
+		max: max,
+	}
+}
+
+// Printf logs the message, unless max messages have already been logged this second
+func (s *logSampler) Printf(format string, v ...interface{}) {
+	if !s.sample(time.Now()) {
+		return
+	}
+
+	log.Printf(format, v...)
+}
+
+// sample returns true when a message can be logged at now
+func (s *logSampler) sample(now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	second := now.Truncate(time.Second)
+	if !second.Equal(s.second) {
+		if s.suppressed > 0 {
+			log.Printf("%d log messages suppressed", s.suppressed)
+		}
+
+		s.second = second
+		s.logged = 0
+		s.suppressed = 0
+	}
+
+	if s.logged >= s.max {
+		s.suppressed++
+		return false
+	}
+
+	s.logged++
+
+	return true
+}
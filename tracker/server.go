@@ -1,83 +1,269 @@
 package tracker
 
 import (
+	"context"
 	"log"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/daeMOn63/gorrent/tracker/actions"
 )
 
 const (
 	// MaxUDPPacketSize defines the maximum size of udp packets
-	MaxUDPPacketSize = 1024
+	MaxUDPPacketSize = actions.MaxPayloadSize
+
+	// ProtocolUDP serves and sends actions as UDP datagrams
+	ProtocolUDP = "udp"
+	// ProtocolTCP serves and sends actions over TCP, each action and response prefixed by its length
+	ProtocolTCP = "tcp"
+	// ProtocolHTTP serves and sends actions as HTTP requests
+	ProtocolHTTP = "http"
+	// ProtocolHTTPS serves and sends actions as HTTP requests over TLS
+	ProtocolHTTPS = "https"
+
+	// DefaultWorkers defines how many actions a server handles concurrently
+	DefaultWorkers = 64
+)
+
+var (
+	// ErrRateLimited is responded over TCP and HTTP to sources exceeding their rate
+	// Datagrams are dropped silently instead, so spoofed sources can't get responses sent to others.
+	ErrRateLimited = actions.NewError(actions.ErrorCodeRateLimited, "rate limited")
 )
 
 // Server is the base struct for the gorrent tracker server
 type Server interface {
-	Listen() error
+	Listen(ctx context.Context) error
+	Counters() Counters
 }
 
 // ServerConfig describe configuration needed for the tracker server
+// An Addr without host, like ":4444", listens on all interfaces for both IPv4 and IPv6.
+// TLSCertFile and TLSKeyFile are only used with ProtocolHTTPS.
+// When Limiter is set, actions of sources exceeding their rate are dropped.
+// MaxLogsPerSecond limits the per action logs, 0 meaning DefaultMaxLogsPerSecond.
+// Workers limits how many actions are handled concurrently, 0 meaning DefaultWorkers.
 type ServerConfig struct {
-	Addr     string
-	Protocol string
+	Addr             string
+	Protocol         string
+	TLSCertFile      string
+	TLSKeyFile       string
+	Limiter          RateLimiter
+	MaxLogsPerSecond int
+	Workers          int
 }
 
 type server struct {
 	cfg          ServerConfig
 	actionReader actions.Reader
 	actionRouter actions.Router
+	counters     Counters
+	logger       *logSampler
+
+	// workers holds a token for each action being handled, and inFlight tracks them until they are responded
+	workers  chan struct{}
+	inFlight sync.WaitGroup
 }
 
 var _ Server = &server{}
 
 // NewServer creates a new server
 func NewServer(cfg ServerConfig, reader actions.Reader, router actions.Router) Server {
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+
 	return &server{
 		cfg:          cfg,
 		actionReader: reader,
 		actionRouter: router,
+		logger:       newLogSampler(cfg.MaxLogsPerSecond),
+		workers:      make(chan struct{}, cfg.Workers),
+	}
+}
+
+// Counters returns how many actions the server received, and how many were dropped
+func (t *server) Counters() Counters {
+	return t.counters.snapshot()
+}
+
+// allow counts a received action, returning false when its source exceeded its rate
+func (t *server) allow(client net.Addr) bool {
+	t.counters.inc(&t.counters.Received)
+
+	if t.cfg.Limiter == nil {
+		return true
 	}
+
+	if !t.cfg.Limiter.Allow(actions.Source{Addr: client}.IP(), time.Now()) {
+		t.counters.inc(&t.counters.RateLimited)
+		t.logger.Printf("[%s] rate limited", client)
+
+		return false
+	}
+
+	return true
 }
 
-// Listen makes the server to listen on configured address and protocol
-func (t *server) Listen() error {
+// Listen makes the server to listen on configured address and protocol, until ctx is done
+// The server then stops receiving actions, and returns once the ones being handled are responded.
+func (t *server) Listen(ctx context.Context) error {
+	switch t.cfg.Protocol {
+	case ProtocolHTTP, ProtocolHTTPS:
+		return t.listenHTTP(ctx)
+	case ProtocolTCP:
+		return t.listenStream(ctx)
+	}
+
+	return t.listenPacket(ctx)
+}
+
+// acquire waits for a free worker, and tracks the action until release
+func (t *server) acquire() {
+	t.workers <- struct{}{}
+	t.inFlight.Add(1)
+}
+
+func (t *server) release() {
+	<-t.workers
+	t.inFlight.Done()
+}
+
+// listenPacket serves actions received as datagrams, responding to their sender
+// Datagrams are read in turn, and handled by the workers.
+func (t *server) listenPacket(ctx context.Context) error {
 	link, err := net.ListenPacket(t.cfg.Protocol, t.cfg.Addr)
 	if err != nil {
 		return err
 	}
 	defer link.Close()
 
+	// Unblock the pending read on shutdown, the link being closed after the last response is sent
+	go func() {
+		<-ctx.Done()
+		link.SetReadDeadline(time.Now())
+	}()
+
 	for {
-		buf := make([]byte, MaxUDPPacketSize)
+		// One extra byte tells oversized datagrams apart, as they are truncated to the buffer size
+		buf := make([]byte, MaxUDPPacketSize+1)
 		n, client, err := link.ReadFrom(buf)
+		if ctx.Err() != nil {
+			t.inFlight.Wait()
+
+			return nil
+		}
+
 		if err != nil {
 			log.Print("Error while reading from link: ", err)
 
 			continue
 		}
 
-		buf = buf[:n]
+		if !t.allow(client) {
+			continue
+		}
 
-		action, err := t.actionReader.Read(buf)
-		if err != nil {
-			log.Printf("failed to read action: %s", err)
+		if n > MaxUDPPacketSize {
+			t.counters.inc(&t.counters.Oversized)
+			t.logger.Printf("[%s] sent oversized datagram", client)
 
 			continue
 		}
-		log.Printf("[%s] sent action %#x", client, action.ID())
-		resp, err := t.actionRouter.Handle(action)
-		if err != nil {
-			log.Printf("[%s] action %#x handler error: %s", client, action.ID(), err)
 
-			continue
+		t.acquire()
+		go func() {
+			defer t.release()
+
+			t.handlePacket(link, client, buf[:n])
+		}()
+	}
+}
+
+func (t *server) handlePacket(link net.PacketConn, client net.Addr, frame []byte) {
+	resp := t.serve(actions.Source{Addr: client}, frame, false)
+	if resp == nil {
+		return
+	}
+
+	if _, err := link.WriteTo(resp, client); err != nil {
+		t.logger.Printf("[%s] writing response error: %s", client, err)
+	}
+}
+
+// serve handles the framed action received from src, and returns the framed response echoing its transaction ID
+// Failures are responded with an actions.Error, unless the frame is too short to hold a header, which returns nil.
+// Frames of another version or holding a malformed action also return nil unless respondMalformed, as answering
+// them over UDP, like rate limited datagrams, would let spoofed sources bounce errors to their victims.
+func (t *server) serve(src actions.Source, frame []byte, respondMalformed bool) []byte {
+	header, body, err := actions.ParseHeader(frame)
+	if err == actions.ErrInvalidHeader {
+		t.counters.inc(&t.counters.Malformed)
+		t.logger.Printf("[%s] failed to read header: %s", src.Addr, err)
+
+		return nil
+	}
+
+	respHeader := actions.NewHeader(header.TransactionID)
+	if err != nil {
+		t.counters.inc(&t.counters.Malformed)
+		t.logger.Printf("[%s] sent version %d: %s", src.Addr, header.Version, err)
+
+		if !respondMalformed {
+			return nil
 		}
 
-		_, err = link.WriteTo(resp, client)
-		if err != nil {
-			log.Printf("[%s] writing action %#x response error: %s", client, action.ID(), err)
+		return errorFrame(respHeader, err)
+	}
 
-			continue
+	action, err := t.actionReader.Read(body)
+	if err != nil {
+		t.counters.inc(&t.counters.Malformed)
+		t.logger.Printf("[%s] failed to read action: %s", src.Addr, err)
+
+		if !respondMalformed {
+			return nil
 		}
+
+		if _, ok := err.(*actions.Error); !ok {
+			err = actions.NewError(actions.ErrorCodeMalformedAction, err.Error())
+		}
+
+		return errorFrame(respHeader, err)
 	}
+
+	t.logger.Printf("[%s] sent action %#x", src.Addr, action.ID())
+	resp, err := t.actionRouter.Handle(src, action)
+	if err != nil {
+		t.counters.inc(&t.counters.Failed)
+		t.logger.Printf("[%s] action %#x handler error: %s", src.Addr, action.ID(), err)
+
+		return errorFrame(respHeader, err)
+	}
+
+	return respHeader.Frame(action.ID(), resp)
+}
+
+// rateLimitedFrame returns the ErrRateLimited response to the framed action, or nil when it has no header
+func rateLimitedFrame(frame []byte) []byte {
+	header, _, err := actions.ParseHeader(frame)
+	if err == actions.ErrInvalidHeader {
+		return nil
+	}
+
+	return errorFrame(actions.NewHeader(header.TransactionID), ErrRateLimited)
+}
+
+// errorFrame returns the framed error response of err
+func errorFrame(header actions.Header, err error) []byte {
+	payload, _ := actions.ErrorOf(err).MarshalBinary()
+
+	return header.Frame(actions.ErrorID, payload)
+}
+
+// isErrorFrame returns true when the framed response is an error
+func isErrorFrame(frame []byte) bool {
+	return len(frame) > actions.HeaderSize && actions.ID(frame[actions.HeaderSize]) == actions.ErrorID
 }
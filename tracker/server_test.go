@@ -1,12 +1,17 @@
 package tracker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/daeMOn63/gorrent/gorrent"
 	"github.com/daeMOn63/gorrent/tracker/actions"
 )
 
@@ -24,21 +29,29 @@ func getFreePort() int {
 	return l.LocalAddr().(*net.UDPAddr).Port
 }
 
-var cfg = ServerConfig{
-	Addr:     fmt.Sprintf(":%d", getFreePort()),
-	Protocol: "udp",
-}
+// startTestServer starts a server on a free port, stopped when the test ends, and returns it along its config
+func startTestServer(t *testing.T, cfg ServerConfig, reader actions.Reader, router actions.Router) (Server, ServerConfig) {
+	if cfg.Addr == "" {
+		cfg.Addr = fmt.Sprintf("127.0.0.1:%d", getFreePort())
+	}
 
-var reader = &actions.DummyReader{}
-var router = &actions.DummyRouter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
 
-func init() {
 	s := NewServer(cfg, reader, router)
 	go func() {
-		s.Listen()
+		defer close(stopped)
+		s.Listen(ctx)
 	}()
 
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
 	time.Sleep(10 * time.Millisecond) // wait for the server to finish booting up
+
+	return s, cfg
 }
 
 func TestServer(t *testing.T) {
@@ -49,42 +62,297 @@ func TestServer(t *testing.T) {
 		}
 		expectedOutput := []byte("dcba")
 
-		reader.ReadFunc = func(buf []byte) (actions.Action, error) {
-			if reflect.DeepEqual(buf, expectedPayload) == false {
-				t.Fatalf("Expected buf to be %v, got %v", expectedPayload, buf)
-			}
-			fmt.Println("read")
-			return expectedAction, nil
+		reader := &actions.DummyReader{
+			ReadFunc: func(buf []byte) (actions.Action, error) {
+				if reflect.DeepEqual(buf, expectedPayload) == false {
+					t.Fatalf("Expected buf to be %v, got %v", expectedPayload, buf)
+				}
+				fmt.Println("read")
+				return expectedAction, nil
+			},
 		}
 
-		router.HandleFunc = func(action actions.Action) ([]byte, error) {
-			if reflect.DeepEqual(action, expectedAction) == false {
-				t.Fatalf("Expected action to be %#v, got %#v", expectedAction, action)
-			}
-			fmt.Println("handle")
+		router := &actions.DummyRouter{
+			HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+				if reflect.DeepEqual(action, expectedAction) == false {
+					t.Fatalf("Expected action to be %#v, got %#v", expectedAction, action)
+				}
+
+				if !src.IP().IsLoopback() {
+					t.Fatalf("Expected source to be loopback, got %s", src.Addr)
+				}
+				fmt.Println("handle")
 
-			return expectedOutput, nil
+				return expectedOutput, nil
+			},
 		}
 
+		_, cfg := startTestServer(t, ServerConfig{Protocol: ProtocolUDP}, reader, router)
+
 		conn, err := net.Dial(cfg.Protocol, cfg.Addr)
 		if err != nil {
 			t.Fatal("could not connect to server: ", err)
 		}
 		defer conn.Close()
 
-		_, err = conn.Write(expectedPayload)
+		_, err = conn.Write(append([]byte{actions.ProtocolVersion, 0, 0, 0, 42}, expectedPayload...))
 		if err != nil {
 			t.Fatal("could not write to server: ", err)
 		}
 
-		buf := make([]byte, 10)
+		buf := make([]byte, 32)
 		n, err := conn.Read(buf)
 		if err != nil {
 			t.Fatal("could not read from server: ", err)
 		}
 
-		if reflect.DeepEqual(buf[:n], expectedOutput) == false {
-			t.Fatalf("Expected output to be %v, got %v", expectedOutput, buf[:n])
+		expectedFrame := actions.NewHeader(42).Frame(expectedAction.ID(), expectedOutput)
+		if reflect.DeepEqual(buf[:n], expectedFrame) == false {
+			t.Fatalf("Expected output to be %v, got %v", expectedFrame, buf[:n])
+		}
+	})
+
+	t.Run("server responds with an error echoing the transaction ID on failures", func(t *testing.T) {
+		reader := &actions.DummyReader{
+			ReadFunc: func(buf []byte) (actions.Action, error) {
+				return &actions.DummyAction{IDVar: actions.ID(buf[0])}, nil
+			},
+		}
+
+		router := &actions.DummyRouter{
+			HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+				return nil, errors.New("handler failure")
+			},
+		}
+
+		_, cfg := startTestServer(t, ServerConfig{Protocol: ProtocolUDP}, reader, router)
+
+		conn, err := net.Dial(cfg.Protocol, cfg.Addr)
+		if err != nil {
+			t.Fatal("could not connect to server: ", err)
+		}
+		defer conn.Close()
+
+		for _, tc := range []struct {
+			request  []byte
+			expected *actions.Error
+		}{
+			{
+				request:  actions.NewHeader(7).Frame(actions.ConnectID, nil),
+				expected: actions.NewError(actions.ErrorCodeInternal, "handler failure"),
+			},
+		} {
+			if _, err := conn.Write(tc.request); err != nil {
+				t.Fatal("could not write to server: ", err)
+			}
+
+			buf := make([]byte, 64)
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal("could not read from server: ", err)
+			}
+
+			header, body, err := actions.ParseHeader(buf[:n])
+			if err != nil || header.TransactionID != 7 || actions.ID(body[0]) != actions.ErrorID {
+				t.Fatalf("Expected an error response to transaction 7, got %v", buf[:n])
+			}
+
+			response := &actions.Error{}
+			if err := response.UnmarshalBinary(body[1:]); err != nil {
+				t.Fatal(err)
+			}
+
+			if *response != *tc.expected {
+				t.Fatalf("Expected error to be %#v, got %#v", tc.expected, response)
+			}
+		}
+	})
+
+	t.Run("server drops datagrams of another version or holding malformed actions without responding", func(t *testing.T) {
+		reader := &actions.DummyReader{
+			ReadFunc: func(buf []byte) (actions.Action, error) {
+				return nil, actions.ErrUnknowAction
+			},
+		}
+
+		s, cfg := startTestServer(t, ServerConfig{Protocol: ProtocolUDP}, reader, &actions.DummyRouter{})
+
+		conn, err := net.Dial(cfg.Protocol, cfg.Addr)
+		if err != nil {
+			t.Fatal("could not connect to server: ", err)
+		}
+		defer conn.Close()
+
+		conn.Write(append([]byte{actions.ProtocolVersion + 1, 0, 0, 0, 7}, byte(actions.ConnectID)))
+		conn.Write([]byte{byte(actions.ConnectID), 0, 0, 0, 7, 0})
+		conn.Write(actions.NewHeader(7).Frame(actions.ConnectID, nil))
+
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if n, err := conn.Read(make([]byte, 64)); err == nil {
+			t.Fatalf("Expected no response, got %d bytes", n)
+		}
+
+		if counters := s.Counters(); counters.Malformed != 3 {
+			t.Fatalf("Expected 3 malformed datagrams, got %d", counters.Malformed)
+		}
+	})
+}
+
+func TestServerConcurrency(t *testing.T) {
+	t.Run("server handles the actions of concurrent clients in parallel", func(t *testing.T) {
+		const clients = 8
+
+		reader := &actions.DummyReader{
+			ReadFunc: func(buf []byte) (actions.Action, error) {
+				return &actions.DummyAction{IDVar: actions.ID(buf[0])}, nil
+			},
+		}
+
+		// Every handler waits for all the clients actions, which can't happen when actions are handled in turn
+		var arrived sync.WaitGroup
+		arrived.Add(clients)
+		router := &actions.DummyRouter{
+			HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+				arrived.Done()
+				arrived.Wait()
+
+				return []byte{byte(action.ID())}, nil
+			},
+		}
+
+		_, cfg := startTestServer(t, ServerConfig{Protocol: ProtocolUDP}, reader, router)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, clients)
+		for i := 0; i < clients; i++ {
+			wg.Add(1)
+			go func(id byte) {
+				defer wg.Done()
+
+				conn, err := net.Dial(cfg.Protocol, cfg.Addr)
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer conn.Close()
+
+				conn.SetDeadline(time.Now().Add(time.Second))
+				if _, err := conn.Write(actions.NewHeader(uint32(id)).Frame(actions.ID(id), nil)); err != nil {
+					errs <- err
+					return
+				}
+
+				buf := make([]byte, 32)
+				n, err := conn.Read(buf)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				expected := actions.NewHeader(uint32(id)).Frame(actions.ID(id), []byte{id})
+				if reflect.DeepEqual(buf[:n], expected) == false {
+					errs <- fmt.Errorf("Expected response to be %v, got %v", expected, buf[:n])
+				}
+			}(byte(i + 1))
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestServerShutdown(t *testing.T) {
+	for _, protocol := range []string{ProtocolUDP, ProtocolTCP, ProtocolHTTP} {
+		t.Run(fmt.Sprintf("server over %s responds to the actions being handled before stopping", protocol), func(t *testing.T) {
+			handling := make(chan struct{})
+			router := actions.NewRouter()
+			router.Register(actions.ConnectID, &actions.DummyHandler{
+				HandleFunc: func(src actions.Source, action actions.Action) ([]byte, error) {
+					close(handling)
+					time.Sleep(50 * time.Millisecond)
+
+					return (&actions.ConnectResponse{ConnectionID: 42}).MarshalBinary()
+				},
+			})
+
+			cfg := ServerConfig{Addr: fmt.Sprintf("127.0.0.1:%d", getFreePort()), Protocol: protocol}
+			s := NewServer(cfg, actions.NewReader(), router)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- s.Listen(ctx)
+			}()
+			time.Sleep(10 * time.Millisecond)
+
+			go func() {
+				<-handling
+				cancel()
+			}()
+
+			c := NewClient(gorrent.Peer{}, protocol).(*client)
+			resp, err := c.roundTrip(cfg.Addr, actions.ConnectID, nil)
+			if err != nil {
+				t.Fatalf("Expected in flight action to be responded, got %s", err)
+			}
+
+			response := &actions.ConnectResponse{}
+			if err := response.UnmarshalBinary(resp); err != nil || response.ConnectionID != 42 {
+				t.Fatalf("Expected connection ID 42, got %#v (%v)", response, err)
+			}
+
+			select {
+			case err := <-stopped:
+				if err != nil {
+					t.Fatalf("Expected no error, got %s", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected server to stop")
+			}
+		})
+	}
+}
+
+func TestServerCounters(t *testing.T) {
+	t.Run("server drops rate limited, oversized and malformed datagrams, and counts them", func(t *testing.T) {
+		allowed := int32(1)
+		cfg := ServerConfig{
+			Protocol: ProtocolUDP,
+			Limiter: &DummyRateLimiter{
+				AllowFunc: func(ip net.IP, now time.Time) bool {
+					return atomic.LoadInt32(&allowed) == 1
+				},
+			},
+		}
+
+		reader := &actions.DummyReader{
+			ReadFunc: func(buf []byte) (actions.Action, error) {
+				return nil, actions.ErrUnknowAction
+			},
+		}
+
+		s, cfg := startTestServer(t, cfg, reader, &actions.DummyRouter{})
+
+		conn, err := net.Dial(cfg.Protocol, cfg.Addr)
+		if err != nil {
+			t.Fatal("could not connect to server: ", err)
+		}
+		defer conn.Close()
+
+		conn.Write(make([]byte, MaxUDPPacketSize+1))
+		conn.Write([]byte{0xff})
+		time.Sleep(10 * time.Millisecond)
+
+		atomic.StoreInt32(&allowed, 0)
+		conn.Write([]byte{0xff})
+		time.Sleep(10 * time.Millisecond)
+
+		expected := Counters{Received: 3, RateLimited: 1, Oversized: 1, Malformed: 1}
+		if counters := s.Counters(); counters != expected {
+			t.Fatalf("Expected counters to be %s, got %s", expected, counters)
 		}
 	})
 }
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/daeMOn63/gorrent/gorrent"
@@ -11,12 +13,48 @@ import (
 type Announce interface {
 	Save(announce *actions.Announce)
 	Find(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) *StoredAnnounce
-	FindPeers(infoHash gorrent.Sha1Hash, maxAge time.Duration) []gorrent.Peer
+	FindPeers(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer
+	Stats(infoHash gorrent.Sha1Hash, maxAge time.Duration) Stats
+	Remove(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID)
+	RemoveSwarm(infoHash gorrent.Sha1Hash)
+	Purge(maxAge time.Duration) int
+	InfoHashes() []gorrent.Sha1Hash
+	Announces(infoHash gorrent.Sha1Hash) []*StoredAnnounce
+}
+
+// Stats holds the statistics of a swarm
+// Seeders and Leechers only count the peers which announced within maxAge.
+type Stats struct {
+	Seeders   uint32
+	Leechers  uint32
+	Completed uint32
+}
+
+// add counts the announcing peer as a seeder when it has nothing left to download, or as a leecher
+func (s *Stats) add(announce *actions.Announce) {
+	if announce.Status.Left == 0 {
+		s.Seeders++
+	} else {
+		s.Leechers++
+	}
+}
+
+// completes returns true when announce reports a download completion not counted yet.
+// Peers are counted once, when they announce the completed event without being already known as seeders.
+func completes(previous *StoredAnnounce, announce *actions.Announce) bool {
+	if announce.Event != actions.AnnounceEventCompleted || announce.Status.Left > 0 {
+		return false
+	}
+
+	return previous == nil || previous.Announce.Status.Left > 0
 }
 
 // AnnounceMemory defines a tracker storage using memory only
+// Announces are indexed by info hash, then by peer ID.
 type AnnounceMemory struct {
-	announces []*StoredAnnounce
+	mutex     sync.RWMutex
+	swarms    map[gorrent.Sha1Hash]map[gorrent.PeerID]*StoredAnnounce
+	completed map[gorrent.Sha1Hash]uint32
 }
 
 var _ Announce = &AnnounceMemory{}
@@ -24,7 +62,10 @@ var _ Announce = &DummyAnnounce{}
 
 // NewAnnounceMemory creates a new in memory store
 func NewAnnounceMemory() *AnnounceMemory {
-	return &AnnounceMemory{}
+	return &AnnounceMemory{
+		swarms:    make(map[gorrent.Sha1Hash]map[gorrent.PeerID]*StoredAnnounce),
+		completed: make(map[gorrent.Sha1Hash]uint32),
+	}
 }
 
 // StoredAnnounce is how actions.Announce get stored
@@ -35,49 +76,225 @@ type StoredAnnounce struct {
 
 // Save add an announce action to the memory store
 func (m *AnnounceMemory) Save(announce *actions.Announce) {
-	var sa *StoredAnnounce
-	sa = m.Find(announce.InfoHash, announce.Peer.ID)
-	if sa == nil {
-		sa = &StoredAnnounce{}
-		m.announces = append(m.announces, sa)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	swarm, ok := m.swarms[announce.InfoHash]
+	if !ok {
+		swarm = make(map[gorrent.PeerID]*StoredAnnounce)
+		m.swarms[announce.InfoHash] = swarm
 	}
 
-	sa.Announce = announce
-	sa.LastUpdated = time.Now()
+	if completes(swarm[announce.Peer.ID], announce) {
+		m.completed[announce.InfoHash]++
+	}
+
+	swarm[announce.Peer.ID] = &StoredAnnounce{
+		Announce:    announce,
+		LastUpdated: time.Now(),
+	}
 }
 
 // Find retrieve a stored announce
 func (m *AnnounceMemory) Find(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) *StoredAnnounce {
-	for _, a := range m.announces {
-		if a.Announce.InfoHash == infoHash && a.Announce.Peer.ID == peerID {
-			return a
-		}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.swarms[infoHash][peerID]
+}
+
+// Remove deletes the announce of a peer, removing it from the infoHash swarm
+func (m *AnnounceMemory) Remove(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	swarm, ok := m.swarms[infoHash]
+	if !ok {
+		return
+	}
+
+	delete(swarm, peerID)
+	if len(swarm) == 0 {
+		delete(m.swarms, infoHash)
+	}
+}
+
+// RemoveSwarm deletes all the announces of the infoHash swarm, and its completed count
+func (m *AnnounceMemory) RemoveSwarm(infoHash gorrent.Sha1Hash) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.swarms, infoHash)
+	delete(m.completed, infoHash)
+}
+
+// InfoHashes returns the info hashes having at least one stored announce
+func (m *AnnounceMemory) InfoHashes() []gorrent.Sha1Hash {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	infoHashes := make([]gorrent.Sha1Hash, 0, len(m.swarms))
+	for infoHash := range m.swarms {
+		infoHashes = append(infoHashes, infoHash)
+	}
+
+	return infoHashes
+}
+
+// Announces returns all the stored announces of the infoHash swarm, whatever their age
+func (m *AnnounceMemory) Announces(infoHash gorrent.Sha1Hash) []*StoredAnnounce {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	announces := make([]*StoredAnnounce, 0, len(m.swarms[infoHash]))
+	for _, a := range m.swarms[infoHash] {
+		announces = append(announces, a)
 	}
 
-	return nil
+	return announces
 }
 
-// FindPeers retrieve all peers on a given infoHash
-func (m *AnnounceMemory) FindPeers(infoHash gorrent.Sha1Hash, maxAge time.Duration) []gorrent.Peer {
-	var peers []gorrent.Peer
+// Purge deletes the announces older than maxAge, and returns how many were deleted
+// Completed counts of the swarms left empty are deleted along.
+func (m *AnnounceMemory) Purge(maxAge time.Duration) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	for _, a := range m.announces {
-		if a.Announce.InfoHash == infoHash {
-			limit := time.Now().Add(-maxAge)
-			if a.LastUpdated.After(limit) {
-				peers = append(peers, a.Announce.Peer)
+	limit := time.Now().Add(-maxAge)
+	purged := 0
+
+	for infoHash, swarm := range m.swarms {
+		for peerID, a := range swarm {
+			if !a.LastUpdated.After(limit) {
+				delete(swarm, peerID)
+				purged++
 			}
 		}
+
+		if len(swarm) == 0 {
+			delete(m.swarms, infoHash)
+		}
+	}
+
+	// Swarms may also have been emptied by their last peer stopping
+	for infoHash := range m.completed {
+		if _, ok := m.swarms[infoHash]; !ok {
+			delete(m.completed, infoHash)
+		}
+	}
+
+	return purged
+}
+
+// FindPeers retrieve up to max peers on a given infoHash, randomly picked when there are more
+// With preferSeeders, seeders are picked before leechers.
+func (m *AnnounceMemory) FindPeers(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	sampler := newSwarmSampler(max, preferSeeders)
+
+	limit := time.Now().Add(-maxAge)
+	for _, a := range m.swarms[infoHash] {
+		if a.LastUpdated.After(limit) {
+			sampler.add(a.Announce)
+		}
+	}
+
+	return sampler.peers()
+}
+
+// swarmSampler picks up to max random peers of a swarm, seeders first when preferSeeders is set.
+// Only the picked peers are kept, so finding peers never copies whole swarms.
+type swarmSampler struct {
+	max           int
+	preferSeeders bool
+	seeders       peerSampler
+	leechers      peerSampler
+}
+
+func newSwarmSampler(max int, preferSeeders bool) *swarmSampler {
+	if max < 0 {
+		max = 0
+	}
+
+	return &swarmSampler{
+		max:           max,
+		preferSeeders: preferSeeders,
+		seeders:       peerSampler{max: max},
+		leechers:      peerSampler{max: max},
+	}
+}
+
+// add offers the announcing peer to the sample
+func (s *swarmSampler) add(announce *actions.Announce) {
+	if s.preferSeeders && announce.Status.Left == 0 {
+		s.seeders.add(announce.Peer)
+	} else {
+		s.leechers.add(announce.Peer)
+	}
+}
+
+// peers returns the picked peers, the seeders ones completed by leechers up to max
+func (s *swarmSampler) peers() []gorrent.Peer {
+	peers := s.seeders.peers
+	if len(s.leechers.peers) > s.max-len(peers) {
+		return append(peers, s.leechers.peers[:s.max-len(peers)]...)
+	}
+
+	return append(peers, s.leechers.peers...)
+}
+
+// peerSampler keeps a uniform random sample of up to max of the peers it is offered, through reservoir sampling
+type peerSampler struct {
+	max   int
+	seen  int
+	peers []gorrent.Peer
+}
+
+func (s *peerSampler) add(peer gorrent.Peer) {
+	s.seen++
+	if len(s.peers) < s.max {
+		s.peers = append(s.peers, peer)
+
+		return
+	}
+
+	if i := rand.Intn(s.seen); i < s.max {
+		s.peers[i] = peer
+	}
+}
+
+// Stats counts the seeders and leechers of a given infoHash, and how many times it has been completed
+func (m *AnnounceMemory) Stats(infoHash gorrent.Sha1Hash, maxAge time.Duration) Stats {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	stats := Stats{
+		Completed: m.completed[infoHash],
+	}
+
+	limit := time.Now().Add(-maxAge)
+	for _, a := range m.swarms[infoHash] {
+		if a.LastUpdated.After(limit) {
+			stats.add(a.Announce)
+		}
 	}
 
-	return peers
+	return stats
 }
 
 // DummyAnnounce provides a configurable Announce store
 type DummyAnnounce struct {
-	SaveFunc      func(announce *actions.Announce)
-	FindFunc      func(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) *StoredAnnounce
-	FindPeersFunc func(infoHash gorrent.Sha1Hash, maxAge time.Duration) []gorrent.Peer
+	SaveFunc        func(announce *actions.Announce)
+	FindFunc        func(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) *StoredAnnounce
+	FindPeersFunc   func(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer
+	StatsFunc       func(infoHash gorrent.Sha1Hash, maxAge time.Duration) Stats
+	RemoveFunc      func(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID)
+	RemoveSwarmFunc func(infoHash gorrent.Sha1Hash)
+	PurgeFunc       func(maxAge time.Duration) int
+	InfoHashesFunc  func() []gorrent.Sha1Hash
+	AnnouncesFunc   func(infoHash gorrent.Sha1Hash) []*StoredAnnounce
 }
 
 // Save calls SaveFunc
@@ -91,6 +308,36 @@ func (d *DummyAnnounce) Find(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) *
 }
 
 // FindPeers calls FindPeersFunc
-func (d *DummyAnnounce) FindPeers(infoHash gorrent.Sha1Hash, maxAge time.Duration) []gorrent.Peer {
-	return d.FindPeersFunc(infoHash, maxAge)
+func (d *DummyAnnounce) FindPeers(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
+	return d.FindPeersFunc(infoHash, maxAge, max, preferSeeders)
+}
+
+// Stats calls StatsFunc
+func (d *DummyAnnounce) Stats(infoHash gorrent.Sha1Hash, maxAge time.Duration) Stats {
+	return d.StatsFunc(infoHash, maxAge)
+}
+
+// Remove calls RemoveFunc
+func (d *DummyAnnounce) Remove(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) {
+	d.RemoveFunc(infoHash, peerID)
+}
+
+// Purge calls PurgeFunc
+func (d *DummyAnnounce) Purge(maxAge time.Duration) int {
+	return d.PurgeFunc(maxAge)
+}
+
+// RemoveSwarm calls RemoveSwarmFunc
+func (d *DummyAnnounce) RemoveSwarm(infoHash gorrent.Sha1Hash) {
+	d.RemoveSwarmFunc(infoHash)
+}
+
+// InfoHashes calls InfoHashesFunc
+func (d *DummyAnnounce) InfoHashes() []gorrent.Sha1Hash {
+	return d.InfoHashesFunc()
+}
+
+// Announces calls AnnouncesFunc
+func (d *DummyAnnounce) Announces(infoHash gorrent.Sha1Hash) []*StoredAnnounce {
+	return d.AnnouncesFunc(infoHash)
 }
@@ -0,0 +1,470 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"log"
+	"os"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	swarmsBucket    = []byte("swarms")
+	completedBucket = []byte("completed")
+)
+
+// AnnounceBolt defines a tracker storage persisted in a bolt database, surviving tracker restarts
+// Each swarm is a nested bucket of the swarms bucket, keyed by info hash, holding the announces keyed by peer ID.
+// Completed download counters are kept in the completed bucket.
+type AnnounceBolt struct {
+	db *bolt.DB
+}
+
+var _ Announce = &AnnounceBolt{}
+
+// NewAnnounceBolt opens, or creates, the bolt database at path
+// An existing database is compacted first, as bolt never shrinks its file on its own.
+func NewAnnounceBolt(path string, mode os.FileMode) (*AnnounceBolt, error) {
+	if err := compactBolt(path, mode); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, mode, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(swarmsBucket); err != nil {
+			return err
+		}
+
+		_, err := tx.CreateBucketIfNotExists(completedBucket)
+
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &AnnounceBolt{
+		db: db,
+	}, nil
+}
+
+// Close closes the database
+func (b *AnnounceBolt) Close() error {
+	return b.db.Close()
+}
+
+// Save add an announce action to the database
+func (b *AnnounceBolt) Save(announce *actions.Announce) {
+	data, err := encodeStoredAnnounce(&StoredAnnounce{
+		Announce:    announce,
+		LastUpdated: time.Now(),
+	})
+	if err != nil {
+		log.Printf("failed to encode announce: %s", err)
+		return
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		swarm, err := tx.Bucket(swarmsBucket).CreateBucketIfNotExists(announce.InfoHash.Bytes())
+		if err != nil {
+			return err
+		}
+
+		// An undecodable previous announce is handled as unknown
+		var previous *StoredAnnounce
+		if v := swarm.Get(announce.Peer.ID[:]); v != nil {
+			previous, _ = decodeStoredAnnounce(v)
+		}
+
+		if err := swarm.Put(announce.Peer.ID[:], data); err != nil {
+			return err
+		}
+
+		if !completes(previous, announce) {
+			return nil
+		}
+
+		completed := tx.Bucket(completedBucket)
+		count := make([]byte, 4)
+		if v := completed.Get(announce.InfoHash.Bytes()); v != nil {
+			copy(count, v)
+		}
+		binary.BigEndian.PutUint32(count, binary.BigEndian.Uint32(count)+1)
+
+		return completed.Put(announce.InfoHash.Bytes(), count)
+	})
+	if err != nil {
+		log.Printf("failed to save announce: %s", err)
+	}
+}
+
+// Find retrieve a stored announce
+func (b *AnnounceBolt) Find(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) *StoredAnnounce {
+	var sa *StoredAnnounce
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		swarm := tx.Bucket(swarmsBucket).Bucket(infoHash.Bytes())
+		if swarm == nil {
+			return nil
+		}
+
+		v := swarm.Get(peerID[:])
+		if v == nil {
+			return nil
+		}
+
+		var err error
+		sa, err = decodeStoredAnnounce(v)
+
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to find announce: %s", err)
+		return nil
+	}
+
+	return sa
+}
+
+// Remove deletes the announce of a peer, removing it from the infoHash swarm
+func (b *AnnounceBolt) Remove(infoHash gorrent.Sha1Hash, peerID gorrent.PeerID) {
+	if err := b.deleteAnnounces(infoHash, [][]byte{peerID[:]}); err != nil {
+		log.Printf("failed to remove announce: %s", err)
+	}
+}
+
+// deleteAnnounces deletes the announces of the peerIDs from the infoHash swarm, and the swarm when left empty
+func (b *AnnounceBolt) deleteAnnounces(infoHash gorrent.Sha1Hash, peerIDs [][]byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		swarms := tx.Bucket(swarmsBucket)
+		swarm := swarms.Bucket(infoHash.Bytes())
+		if swarm == nil {
+			return nil
+		}
+
+		for _, peerID := range peerIDs {
+			if err := swarm.Delete(peerID); err != nil {
+				return err
+			}
+		}
+
+		if k, _ := swarm.Cursor().First(); k == nil {
+			return swarms.DeleteBucket(infoHash.Bytes())
+		}
+
+		return nil
+	})
+}
+
+// RemoveSwarm deletes all the announces of the infoHash swarm, and its completed count
+func (b *AnnounceBolt) RemoveSwarm(infoHash gorrent.Sha1Hash) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		swarms := tx.Bucket(swarmsBucket)
+		if swarms.Bucket(infoHash.Bytes()) != nil {
+			if err := swarms.DeleteBucket(infoHash.Bytes()); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(completedBucket).Delete(infoHash.Bytes())
+	})
+	if err != nil {
+		log.Printf("failed to remove swarm: %s", err)
+	}
+}
+
+// InfoHashes returns the info hashes having at least one stored announce
+func (b *AnnounceBolt) InfoHashes() []gorrent.Sha1Hash {
+	var infoHashes []gorrent.Sha1Hash
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(swarmsBucket).ForEach(func(k, _ []byte) error {
+			var infoHash gorrent.Sha1Hash
+			copy(infoHash[:], k)
+			infoHashes = append(infoHashes, infoHash)
+
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("failed to list info hashes: %s", err)
+	}
+
+	return infoHashes
+}
+
+// Announces returns all the stored announces of the infoHash swarm, whatever their age
+func (b *AnnounceBolt) Announces(infoHash gorrent.Sha1Hash) []*StoredAnnounce {
+	var announces []*StoredAnnounce
+
+	err := b.forEach(infoHash, func(sa *StoredAnnounce) {
+		announces = append(announces, sa)
+	})
+	if err != nil {
+		log.Printf("failed to list announces: %s", err)
+		return nil
+	}
+
+	return announces
+}
+
+// Purge deletes the announces older than maxAge, and the swarms left empty along their completed count, and returns how many announces were deleted
+// Undecodable announces are deleted along the stale ones. Space freed by deleted entries is reused by the following writes,
+// and given back to the file system when the database is compacted on the next start.
+func (b *AnnounceBolt) Purge(maxAge time.Duration) int {
+	limit := time.Now().Add(-maxAge)
+	purged := 0
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		swarms := tx.Bucket(swarmsBucket)
+
+		// Buckets can't be modified while iterating them, so keys are collected first
+		var infoHashes [][]byte
+		swarms.ForEach(func(infoHash, _ []byte) error {
+			infoHashes = append(infoHashes, append([]byte(nil), infoHash...))
+			return nil
+		})
+
+		for _, infoHash := range infoHashes {
+			swarm := swarms.Bucket(infoHash)
+
+			var stale [][]byte
+			total := 0
+			err := swarm.ForEach(func(peerID, v []byte) error {
+				total++
+
+				sa, err := decodeStoredAnnounce(v)
+				if err != nil {
+					log.Printf("deleting undecodable announce of peer %x in swarm %x: %s", peerID, infoHash, err)
+					stale = append(stale, append([]byte(nil), peerID...))
+
+					return nil
+				}
+
+				if !sa.LastUpdated.After(limit) {
+					stale = append(stale, append([]byte(nil), peerID...))
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			purged += len(stale)
+
+			if len(stale) == total {
+				if err := swarms.DeleteBucket(infoHash); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			for _, peerID := range stale {
+				if err := swarm.Delete(peerID); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Swarms may also have been emptied by their last peer stopping
+		completed := tx.Bucket(completedBucket)
+		var emptied [][]byte
+		completed.ForEach(func(infoHash, _ []byte) error {
+			if swarms.Bucket(infoHash) == nil {
+				emptied = append(emptied, append([]byte(nil), infoHash...))
+			}
+			return nil
+		})
+
+		for _, infoHash := range emptied {
+			if err := completed.Delete(infoHash); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to purge announces: %s", err)
+		return 0
+	}
+
+	return purged
+}
+
+// FindPeers retrieve up to max peers on a given infoHash, randomly picked when there are more
+// With preferSeeders, seeders are picked before leechers.
+func (b *AnnounceBolt) FindPeers(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
+	sampler := newSwarmSampler(max, preferSeeders)
+
+	limit := time.Now().Add(-maxAge)
+	err := b.forEach(infoHash, func(sa *StoredAnnounce) {
+		if sa.LastUpdated.After(limit) {
+			sampler.add(sa.Announce)
+		}
+	})
+	if err != nil {
+		log.Printf("failed to find peers: %s", err)
+		return nil
+	}
+
+	return sampler.peers()
+}
+
+// Stats counts the seeders and leechers of a given infoHash, and how many times it has been completed
+func (b *AnnounceBolt) Stats(infoHash gorrent.Sha1Hash, maxAge time.Duration) Stats {
+	var stats Stats
+
+	limit := time.Now().Add(-maxAge)
+	err := b.forEach(infoHash, func(sa *StoredAnnounce) {
+		if sa.LastUpdated.After(limit) {
+			stats.add(sa.Announce)
+		}
+	})
+	if err != nil {
+		log.Printf("failed to count peers: %s", err)
+	}
+
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(completedBucket).Get(infoHash.Bytes()); v != nil {
+			stats.Completed = binary.BigEndian.Uint32(v)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to read completed count: %s", err)
+	}
+
+	return stats
+}
+
+// forEach calls fn with each stored announce of the infoHash swarm
+// Undecodable announces are skipped, and deleted once the swarm has been read.
+func (b *AnnounceBolt) forEach(infoHash gorrent.Sha1Hash, fn func(sa *StoredAnnounce)) error {
+	var undecodable [][]byte
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		swarm := tx.Bucket(swarmsBucket).Bucket(infoHash.Bytes())
+		if swarm == nil {
+			return nil
+		}
+
+		return swarm.ForEach(func(peerID, v []byte) error {
+			sa, err := decodeStoredAnnounce(v)
+			if err != nil {
+				log.Printf("skipping undecodable announce of peer %x in swarm %s: %s", peerID, infoHash.HexString(), err)
+				undecodable = append(undecodable, append([]byte(nil), peerID...))
+
+				return nil
+			}
+
+			fn(sa)
+
+			return nil
+		})
+	})
+	if err != nil || len(undecodable) == 0 {
+		return err
+	}
+
+	return b.deleteAnnounces(infoHash, undecodable)
+}
+
+// compactBolt rewrites the database at path with only its live entries, dropping the pages freed by deleted ones.
+// It is copied to a temporary file, replacing the original once complete, so an interrupted compaction loses nothing.
+func compactBolt(path string, mode os.FileMode) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	tmpPath := path + ".compact"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	src, err := bolt.Open(path, mode, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := bolt.Open(tmpPath, mode, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+				dstBucket, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+
+				return copyBucket(dstBucket, bucket)
+			})
+		})
+	})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := src.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// copyBucket copies all the keys of src into dst, recursing into nested buckets
+func copyBucket(dst *bolt.Bucket, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		// Nested buckets are listed with a nil value
+		if v == nil {
+			nested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+
+			return copyBucket(nested, src.Bucket(k))
+		}
+
+		return dst.Put(k, v)
+	})
+}
+
+func encodeStoredAnnounce(sa *StoredAnnounce) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(sa); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeStoredAnnounce(data []byte) (*StoredAnnounce, error) {
+	sa := &StoredAnnounce{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(sa); err != nil {
+		return nil, err
+	}
+
+	return sa, nil
+}
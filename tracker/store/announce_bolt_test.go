@@ -0,0 +1,272 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestAnnounceBolt(t *testing.T, path string) *AnnounceBolt {
+	s, err := NewAnnounceBolt(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// saveAt stores the announce as if it was received at lastUpdated
+func saveAt(t *testing.T, s *AnnounceBolt, a *actions.Announce, lastUpdated time.Time) {
+	data, err := encodeStoredAnnounce(&StoredAnnounce{Announce: a, LastUpdated: lastUpdated})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		swarm, err := tx.Bucket(swarmsBucket).CreateBucketIfNotExists(a.InfoHash.Bytes())
+		if err != nil {
+			return err
+		}
+
+		return swarm.Put(a.Peer.ID[:], data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnnounceBolt(t *testing.T) {
+	t.Run("Announces and stats survive a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tracker.db")
+		s := newTestAnnounceBolt(t, path)
+
+		a := &actions.Announce{
+			Event:    actions.AnnounceEventCompleted,
+			InfoHash: gorrent.RandomSha1Hash(),
+			Peer:     gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())},
+		}
+		s.Save(a)
+
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		s = newTestAnnounceBolt(t, path)
+		defer s.Close()
+
+		stored := s.Find(a.InfoHash, a.Peer.ID)
+		if stored == nil {
+			t.Fatalf("Expected announce to be found")
+		}
+
+		if reflect.DeepEqual(stored.Announce, a) == false {
+			t.Fatalf("Expected announce to be %#v, got %#v", a, stored.Announce)
+		}
+
+		expectedPeers := []gorrent.Peer{a.Peer}
+		if peers := s.FindPeers(a.InfoHash, time.Second, 10, false); reflect.DeepEqual(peers, expectedPeers) == false {
+			t.Fatalf("Expected peers to be %#v, got %#v", expectedPeers, peers)
+		}
+
+		expectedStats := Stats{Seeders: 1, Completed: 1}
+		if stats := s.Stats(a.InfoHash, time.Second); stats != expectedStats {
+			t.Fatalf("Expected stats to be %#v, got %#v", expectedStats, stats)
+		}
+	})
+
+	t.Run("Remove deletes the peer announce and its empty swarm", func(t *testing.T) {
+		s := newTestAnnounceBolt(t, filepath.Join(t.TempDir(), "tracker.db"))
+		defer s.Close()
+
+		a := &actions.Announce{
+			InfoHash: gorrent.RandomSha1Hash(),
+			Peer:     gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())},
+		}
+		s.Save(a)
+		s.Remove(a.InfoHash, a.Peer.ID)
+
+		if s.Find(a.InfoHash, a.Peer.ID) != nil {
+			t.Fatalf("Expected announce to be removed")
+		}
+
+		s.db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket(swarmsBucket).Bucket(a.InfoHash.Bytes()) != nil {
+				t.Fatalf("Expected empty swarm to be removed")
+			}
+			return nil
+		})
+	})
+
+	t.Run("InfoHashes, Announces and RemoveSwarm manage whole swarms", func(t *testing.T) {
+		s := newTestAnnounceBolt(t, filepath.Join(t.TempDir(), "tracker.db"))
+		defer s.Close()
+
+		a := &actions.Announce{
+			Event:    actions.AnnounceEventCompleted,
+			InfoHash: gorrent.RandomSha1Hash(),
+			Peer:     gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())},
+		}
+		s.Save(a)
+
+		expectedInfoHashes := []gorrent.Sha1Hash{a.InfoHash}
+		if infoHashes := s.InfoHashes(); reflect.DeepEqual(infoHashes, expectedInfoHashes) == false {
+			t.Fatalf("Expected info hashes to be %v, got %v", expectedInfoHashes, infoHashes)
+		}
+
+		announces := s.Announces(a.InfoHash)
+		if len(announces) != 1 || reflect.DeepEqual(announces[0].Announce, a) == false {
+			t.Fatalf("Expected announces to hold %#v, got %#v", a, announces)
+		}
+
+		s.RemoveSwarm(a.InfoHash)
+
+		if len(s.InfoHashes()) != 0 {
+			t.Fatalf("Expected no info hashes, got %v", s.InfoHashes())
+		}
+
+		if stats := s.Stats(a.InfoHash, time.Second); stats != (Stats{}) {
+			t.Fatalf("Expected empty stats, got %#v", stats)
+		}
+	})
+
+	t.Run("Purge deletes announces older than maxAge", func(t *testing.T) {
+		s := newTestAnnounceBolt(t, filepath.Join(t.TempDir(), "tracker.db"))
+		defer s.Close()
+
+		infoHash := gorrent.RandomSha1Hash()
+		stale := &actions.Announce{InfoHash: infoHash, Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}}
+		fresh := &actions.Announce{InfoHash: infoHash, Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}}
+		staleSwarm := &actions.Announce{InfoHash: gorrent.RandomSha1Hash(), Peer: stale.Peer}
+
+		saveAt(t, s, stale, time.Now().Add(-time.Minute))
+		saveAt(t, s, staleSwarm, time.Now().Add(-time.Minute))
+		s.Save(fresh)
+
+		if purged := s.Purge(time.Second); purged != 2 {
+			t.Fatalf("Expected 2 announces to be purged, got %d", purged)
+		}
+
+		if s.Find(infoHash, stale.Peer.ID) != nil || s.Find(staleSwarm.InfoHash, stale.Peer.ID) != nil {
+			t.Fatalf("Expected stale announces to be purged")
+		}
+
+		if s.Find(infoHash, fresh.Peer.ID) == nil {
+			t.Fatalf("Expected fresh announce to be kept")
+		}
+	})
+
+	t.Run("Completed counts each peer once and is purged with its swarm", func(t *testing.T) {
+		s := newTestAnnounceBolt(t, filepath.Join(t.TempDir(), "tracker.db"))
+		defer s.Close()
+
+		a := &actions.Announce{
+			Event:    actions.AnnounceEventCompleted,
+			InfoHash: gorrent.RandomSha1Hash(),
+			Peer:     gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())},
+		}
+		s.Save(a)
+		s.Save(a)
+
+		if completed := s.Stats(a.InfoHash, time.Second).Completed; completed != 1 {
+			t.Fatalf("Expected completed to be 1, got %d", completed)
+		}
+
+		s.Remove(a.InfoHash, a.Peer.ID)
+		s.Purge(time.Second)
+
+		s.db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket(completedBucket).Get(a.InfoHash.Bytes()) != nil {
+				t.Fatalf("Expected the emptied swarm completed count to be deleted")
+			}
+			return nil
+		})
+	})
+
+	t.Run("Undecodable announces are skipped and deleted", func(t *testing.T) {
+		s := newTestAnnounceBolt(t, filepath.Join(t.TempDir(), "tracker.db"))
+		defer s.Close()
+
+		infoHash := gorrent.RandomSha1Hash()
+		valid := &actions.Announce{InfoHash: infoHash, Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}}
+		s.Save(valid)
+
+		corruptedID := gorrent.PeerID(gorrent.RandomSha1Hash())
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(swarmsBucket).Bucket(infoHash.Bytes()).Put(corruptedID[:], []byte("corrupted"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedPeers := []gorrent.Peer{valid.Peer}
+		if peers := s.FindPeers(infoHash, time.Second, 10, false); reflect.DeepEqual(peers, expectedPeers) == false {
+			t.Fatalf("Expected peers to be %#v, got %#v", expectedPeers, peers)
+		}
+
+		if announces := s.Announces(infoHash); len(announces) != 1 {
+			t.Fatalf("Expected 1 announce to be left, got %d", len(announces))
+		}
+
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(swarmsBucket).Bucket(infoHash.Bytes()).Put(corruptedID[:], []byte("corrupted"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if purged := s.Purge(time.Minute); purged != 1 {
+			t.Fatalf("Expected the undecodable announce to be purged, got %d purged", purged)
+		}
+
+		if s.Find(infoHash, valid.Peer.ID) == nil {
+			t.Fatalf("Expected valid announce to be kept")
+		}
+	})
+
+	t.Run("Reopening the database compacts it", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tracker.db")
+		s := newTestAnnounceBolt(t, path)
+
+		kept := &actions.Announce{InfoHash: gorrent.RandomSha1Hash(), Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}}
+		s.Save(kept)
+		for i := 0; i < 2000; i++ {
+			saveAt(t, s, &actions.Announce{
+				InfoHash: gorrent.RandomSha1Hash(),
+				Peer:     gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())},
+			}, time.Now().Add(-time.Hour))
+		}
+		s.Purge(time.Minute)
+
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		before, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s = newTestAnnounceBolt(t, path)
+		defer s.Close()
+
+		after, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if after.Size() >= before.Size() {
+			t.Fatalf("Expected database to shrink below %d bytes, got %d", before.Size(), after.Size())
+		}
+
+		if s.Find(kept.InfoHash, kept.Peer.ID) == nil {
+			t.Fatalf("Expected announce to survive the compaction")
+		}
+	})
+}
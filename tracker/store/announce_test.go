@@ -2,6 +2,7 @@ package store
 
 import (
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,7 +11,7 @@ import (
 )
 
 func TestAnnounceMemorySave(t *testing.T) {
-	t.Run("Save append to the slice", func(t *testing.T) {
+	t.Run("Save indexes announces by info hash and peer ID", func(t *testing.T) {
 		s := NewAnnounceMemory()
 
 		a := &actions.Announce{
@@ -21,18 +22,21 @@ func TestAnnounceMemorySave(t *testing.T) {
 			},
 		}
 
-		if len(s.announces) != 0 {
-			t.Fatalf("Expected internal announces len to be 0, got %d", len(s.announces))
+		if len(s.swarms) != 0 {
+			t.Fatalf("Expected internal swarms len to be 0, got %d", len(s.swarms))
 		}
 
 		beforeSave := time.Now()
 		s.Save(a)
 
-		if len(s.announces) != 1 {
-			t.Fatalf("Expected internal announces len to be 1, got %d", len(s.announces))
+		if len(s.swarms) != 1 {
+			t.Fatalf("Expected internal swarms len to be 1, got %d", len(s.swarms))
 		}
 
-		storedAnnounce := s.announces[0]
+		storedAnnounce := s.Find(a.InfoHash, a.Peer.ID)
+		if storedAnnounce == nil {
+			t.Fatalf("Expected announce to be found")
+		}
 
 		if storedAnnounce.LastUpdated.Before(beforeSave) {
 			t.Fatalf("Expected storedAnnounce lastUpdated to be before %s, got %s", beforeSave, storedAnnounce.LastUpdated)
@@ -52,12 +56,12 @@ func TestAnnounceMemorySave(t *testing.T) {
 
 		s.Save(a2)
 
-		if len(s.announces) != 2 {
-			t.Fatalf("Expected internal announces len to be 2, got %d", len(s.announces))
+		if len(s.swarms) != 2 {
+			t.Fatalf("Expected internal swarms len to be 2, got %d", len(s.swarms))
 		}
 
-		if reflect.DeepEqual(s.announces[1].Announce, a2) == false {
-			t.Fatalf("Expected announce to be %#v, got %#v", a2, s.announces[1].Announce)
+		if reflect.DeepEqual(s.Find(a2.InfoHash, a2.Peer.ID).Announce, a2) == false {
+			t.Fatalf("Expected announce to be %#v, got %#v", a2, s.Find(a2.InfoHash, a2.Peer.ID).Announce)
 		}
 	})
 
@@ -74,16 +78,16 @@ func TestAnnounceMemorySave(t *testing.T) {
 
 		s.Save(a)
 
-		initialTime := s.announces[0].LastUpdated
+		initialTime := s.Find(a.InfoHash, a.Peer.ID).LastUpdated
 
 		s.Save(a)
 
-		if len(s.announces) != 1 {
-			t.Fatalf("Expected internal announces len to be 1, got %d", len(s.announces))
+		if len(s.swarms[a.InfoHash]) != 1 {
+			t.Fatalf("Expected internal swarm len to be 1, got %d", len(s.swarms[a.InfoHash]))
 		}
 
-		if !s.announces[0].LastUpdated.After(initialTime) {
-			t.Fatalf("Expected lastUpdate to be after %s, got %s", initialTime, s.announces[0].LastUpdated)
+		if !s.Find(a.InfoHash, a.Peer.ID).LastUpdated.After(initialTime) {
+			t.Fatalf("Expected lastUpdate to be after %s, got %s", initialTime, s.Find(a.InfoHash, a.Peer.ID).LastUpdated)
 		}
 	})
 }
@@ -125,13 +129,13 @@ func TestAnnounceMemoryFindPeers(t *testing.T) {
 		s.Save(a2)
 		s.Save(a3)
 
-		peers := s.FindPeers(infoHash1, 1*time.Second)
-		if reflect.DeepEqual(peers, expectedPeers1) == false {
+		peers := s.FindPeers(infoHash1, 1*time.Second, 10, false)
+		if !samePeers(peers, expectedPeers1) {
 			t.Fatalf("Expected peers to be %#v, got %#v", expectedPeers1, peers)
 		}
 
-		peers = s.FindPeers(infoHash2, 1*time.Second)
-		if reflect.DeepEqual(peers, expectedPeers2) == false {
+		peers = s.FindPeers(infoHash2, 1*time.Second, 10, false)
+		if !samePeers(peers, expectedPeers2) {
 			t.Fatalf("Expected peers to be %#v, got %#v", expectedPeers2, peers)
 		}
 	})
@@ -139,11 +143,277 @@ func TestAnnounceMemoryFindPeers(t *testing.T) {
 	t.Run("FindPeers returns no peer by default", func(t *testing.T) {
 		s := NewAnnounceMemory()
 
-		peers := s.FindPeers(gorrent.RandomSha1Hash(), 1*time.Second)
+		peers := s.FindPeers(gorrent.RandomSha1Hash(), 1*time.Second, 10, false)
 		if len(peers) != 0 {
 			t.Fatalf("Expected peers len to be 0, got %d", len(peers))
 		}
 	})
+
+	t.Run("FindPeers returns at most max distinct peers", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		infoHash := gorrent.RandomSha1Hash()
+		for i := 0; i < 20; i++ {
+			s.Save(&actions.Announce{InfoHash: infoHash, Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}})
+		}
+
+		peers := s.FindPeers(infoHash, 1*time.Second, 5, false)
+		if len(peers) != 5 {
+			t.Fatalf("Expected peers len to be 5, got %d", len(peers))
+		}
+
+		seen := make(map[gorrent.PeerID]bool)
+		for _, p := range peers {
+			if seen[p.ID] {
+				t.Fatalf("Expected distinct peers, got %x twice", p.ID)
+			}
+			seen[p.ID] = true
+		}
+	})
+
+	t.Run("FindPeers samples among the whole swarm", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		infoHash := gorrent.RandomSha1Hash()
+		for i := 0; i < 10; i++ {
+			s.Save(&actions.Announce{InfoHash: infoHash, Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}})
+		}
+
+		seen := make(map[gorrent.PeerID]bool)
+		for i := 0; i < 1000 && len(seen) < 10; i++ {
+			for _, p := range s.FindPeers(infoHash, 1*time.Second, 2, false) {
+				seen[p.ID] = true
+			}
+		}
+
+		if len(seen) != 10 {
+			t.Fatalf("Expected all the 10 peers to be sampled, got %d", len(seen))
+		}
+	})
+}
+
+func TestAnnounceMemoryFindPeersPreferSeeders(t *testing.T) {
+	t.Run("FindPeers picks seeders first when preferSeeders is set", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		infoHash := gorrent.RandomSha1Hash()
+		var seeders []gorrent.Peer
+		for i := 0; i < 3; i++ {
+			seeder := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+			seeders = append(seeders, seeder)
+			s.Save(&actions.Announce{InfoHash: infoHash, Peer: seeder})
+		}
+		for i := 0; i < 10; i++ {
+			leecher := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+			s.Save(&actions.Announce{InfoHash: infoHash, Peer: leecher, Status: actions.AnnounceStatus{Left: 1}})
+		}
+
+		peers := s.FindPeers(infoHash, 1*time.Second, 5, true)
+		if len(peers) != 5 {
+			t.Fatalf("Expected peers len to be 5, got %d", len(peers))
+		}
+
+		if !samePeers(peers[:3], seeders) {
+			t.Fatalf("Expected first peers to be the seeders %#v, got %#v", seeders, peers[:3])
+		}
+
+		peers = s.FindPeers(infoHash, 1*time.Second, 2, true)
+		if len(peers) != 2 {
+			t.Fatalf("Expected peers len to be 2, got %d", len(peers))
+		}
+	})
+}
+
+func TestAnnounceMemoryConcurrency(t *testing.T) {
+	t.Run("Store can be used from concurrent handlers", func(t *testing.T) {
+		s := NewAnnounceMemory()
+		infoHash := gorrent.RandomSha1Hash()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				peer := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+				s.Save(&actions.Announce{InfoHash: infoHash, Peer: peer})
+				s.FindPeers(infoHash, time.Second, 5, false)
+				s.Stats(infoHash, time.Second)
+				s.Purge(time.Second)
+				s.Remove(infoHash, peer.ID)
+			}()
+		}
+		wg.Wait()
+
+		if len(s.swarms) != 0 {
+			t.Fatalf("Expected internal swarms len to be 0, got %d", len(s.swarms))
+		}
+	})
+}
+
+// samePeers returns true when both slices hold the same peers, in any order
+func samePeers(peers []gorrent.Peer, expected []gorrent.Peer) bool {
+	if len(peers) != len(expected) {
+		return false
+	}
+
+	ids := make(map[gorrent.PeerID]bool)
+	for _, p := range expected {
+		ids[p.ID] = true
+	}
+
+	for _, p := range peers {
+		if !ids[p.ID] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestAnnounceMemoryStats(t *testing.T) {
+	t.Run("Stats counts seeders, leechers and completed downloads", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		infoHash := gorrent.RandomSha1Hash()
+		seeder := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+		leecher := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+
+		s.Save(&actions.Announce{Event: actions.AnnounceEventStarted, InfoHash: infoHash, Peer: seeder, Status: actions.AnnounceStatus{Left: 10}})
+		s.Save(&actions.Announce{Event: actions.AnnounceEventCompleted, InfoHash: infoHash, Peer: seeder})
+		s.Save(&actions.Announce{Event: actions.AnnounceEventStarted, InfoHash: infoHash, Peer: leecher, Status: actions.AnnounceStatus{Left: 10}})
+		s.Save(&actions.Announce{Event: actions.AnnounceEventCompleted, InfoHash: gorrent.RandomSha1Hash(), Peer: leecher})
+
+		expectedStats := Stats{Seeders: 1, Leechers: 1, Completed: 1}
+		if stats := s.Stats(infoHash, 1*time.Second); stats != expectedStats {
+			t.Fatalf("Expected stats to be %#v, got %#v", expectedStats, stats)
+		}
+
+		expectedStats = Stats{Completed: 1}
+		if stats := s.Stats(infoHash, 0); stats != expectedStats {
+			t.Fatalf("Expected stats to be %#v, got %#v", expectedStats, stats)
+		}
+	})
+
+	t.Run("Stats counts the completion of each peer once", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		infoHash := gorrent.RandomSha1Hash()
+		peer := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+
+		s.Save(&actions.Announce{Event: actions.AnnounceEventStarted, InfoHash: infoHash, Peer: peer, Status: actions.AnnounceStatus{Left: 10}})
+		s.Save(&actions.Announce{Event: actions.AnnounceEventCompleted, InfoHash: infoHash, Peer: peer})
+		s.Save(&actions.Announce{Event: actions.AnnounceEventCompleted, InfoHash: infoHash, Peer: peer})
+
+		if completed := s.Stats(infoHash, time.Second).Completed; completed != 1 {
+			t.Fatalf("Expected completed to be 1, got %d", completed)
+		}
+	})
+}
+
+func TestAnnounceMemoryRemove(t *testing.T) {
+	t.Run("Remove deletes only the given peer announce", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		infoHash := gorrent.RandomSha1Hash()
+		peer1 := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+		peer2 := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+
+		s.Save(&actions.Announce{InfoHash: infoHash, Peer: peer1})
+		s.Save(&actions.Announce{InfoHash: infoHash, Peer: peer2})
+
+		s.Remove(infoHash, peer1.ID)
+		s.Remove(gorrent.RandomSha1Hash(), peer2.ID)
+
+		expectedPeers := []gorrent.Peer{peer2}
+		if peers := s.FindPeers(infoHash, 1*time.Second, 10, false); reflect.DeepEqual(peers, expectedPeers) == false {
+			t.Fatalf("Expected peers to be %#v, got %#v", expectedPeers, peers)
+		}
+	})
+}
+
+func TestAnnounceMemorySwarms(t *testing.T) {
+	t.Run("InfoHashes and Announces list the stored swarms", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		a := &actions.Announce{InfoHash: gorrent.RandomSha1Hash(), Peer: gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}}
+		s.Save(a)
+
+		expectedInfoHashes := []gorrent.Sha1Hash{a.InfoHash}
+		if infoHashes := s.InfoHashes(); reflect.DeepEqual(infoHashes, expectedInfoHashes) == false {
+			t.Fatalf("Expected info hashes to be %v, got %v", expectedInfoHashes, infoHashes)
+		}
+
+		announces := s.Announces(a.InfoHash)
+		if len(announces) != 1 || announces[0].Announce != a {
+			t.Fatalf("Expected announces to hold %#v, got %#v", a, announces)
+		}
+	})
+
+	t.Run("RemoveSwarm deletes the swarm announces and completed count", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		a := &actions.Announce{
+			Event:    actions.AnnounceEventCompleted,
+			InfoHash: gorrent.RandomSha1Hash(),
+			Peer:     gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())},
+		}
+		s.Save(a)
+		s.RemoveSwarm(a.InfoHash)
+
+		if len(s.InfoHashes()) != 0 {
+			t.Fatalf("Expected no info hashes, got %v", s.InfoHashes())
+		}
+
+		if stats := s.Stats(a.InfoHash, time.Second); stats != (Stats{}) {
+			t.Fatalf("Expected empty stats, got %#v", stats)
+		}
+	})
+}
+
+func TestAnnounceMemoryPurge(t *testing.T) {
+	t.Run("Purge deletes announces older than maxAge", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		infoHash := gorrent.RandomSha1Hash()
+		peer1 := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+		peer2 := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+
+		s.Save(&actions.Announce{InfoHash: infoHash, Peer: peer1})
+		s.Save(&actions.Announce{InfoHash: infoHash, Peer: peer2})
+		s.Find(infoHash, peer1.ID).LastUpdated = time.Now().Add(-time.Minute)
+
+		if purged := s.Purge(time.Second); purged != 1 {
+			t.Fatalf("Expected 1 announce to be purged, got %d", purged)
+		}
+
+		if s.Find(infoHash, peer1.ID) != nil {
+			t.Fatalf("Expected stale announce to be purged")
+		}
+
+		if s.Find(infoHash, peer2.ID) == nil {
+			t.Fatalf("Expected fresh announce to be kept")
+		}
+	})
+
+	t.Run("Purge deletes the completed count of emptied swarms", func(t *testing.T) {
+		s := NewAnnounceMemory()
+
+		purged := gorrent.RandomSha1Hash()
+		stopped := gorrent.RandomSha1Hash()
+		peer := gorrent.Peer{ID: gorrent.PeerID(gorrent.RandomSha1Hash())}
+
+		s.Save(&actions.Announce{Event: actions.AnnounceEventCompleted, InfoHash: purged, Peer: peer})
+		s.Save(&actions.Announce{Event: actions.AnnounceEventCompleted, InfoHash: stopped, Peer: peer})
+		s.Find(purged, peer.ID).LastUpdated = time.Now().Add(-time.Minute)
+		s.Remove(stopped, peer.ID)
+
+		s.Purge(time.Second)
+
+		if len(s.completed) != 0 {
+			t.Fatalf("Expected completed counts to be deleted, got %v", s.completed)
+		}
+	})
 }
 
 func TestDummyAnnounce(t *testing.T) {
@@ -187,7 +457,7 @@ func TestDummyAnnounce(t *testing.T) {
 
 			return expectedStoredAnnounce
 		},
-		FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration) []gorrent.Peer {
+		FindPeersFunc: func(infoHash gorrent.Sha1Hash, maxAge time.Duration, max int, preferSeeders bool) []gorrent.Peer {
 			if reflect.DeepEqual(infoHash, expectedInfoHash) == false {
 				t.Fatalf("Expected infoHash to be %#v, got %#v", expectedInfoHash, infoHash)
 			}
@@ -210,7 +480,7 @@ func TestDummyAnnounce(t *testing.T) {
 		t.Fatalf("Expected stored announce to be %#v, got %#v", expectedStoredAnnounce, storedAnnounce)
 	}
 
-	peers := d.FindPeers(expectedInfoHash, expectedMaxAge)
+	peers := d.FindPeers(expectedInfoHash, expectedMaxAge, 10, false)
 	if reflect.DeepEqual(peers, expectedPeers) == false {
 		t.Fatalf("Expected peers to be %#v, got %#v", expectedPeers, peers)
 	}
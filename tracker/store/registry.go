@@ -0,0 +1,168 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+// Registry holds the info hashes allowed on the tracker, along the swarm secrets of the private ones
+type Registry interface {
+	SwarmSecrets
+	// Register allows infoHash on the tracker, as a private swarm when secret is not empty
+	Register(infoHash gorrent.Sha1Hash, secret []byte)
+	Unregister(infoHash gorrent.Sha1Hash)
+	Registered(infoHash gorrent.Sha1Hash) bool
+	All() []gorrent.Sha1Hash
+}
+
+// RegistryMemory defines a registry using memory only
+type RegistryMemory struct {
+	mutex      sync.RWMutex
+	infoHashes map[gorrent.Sha1Hash][]byte
+}
+
+var _ Registry = &RegistryMemory{}
+var _ Registry = &registryOverlay{}
+var _ Registry = &DummyRegistry{}
+
+// NewRegistryMemory creates a new in memory registry
+func NewRegistryMemory() *RegistryMemory {
+	return &RegistryMemory{
+		infoHashes: make(map[gorrent.Sha1Hash][]byte),
+	}
+}
+
+// Register allows infoHash on the tracker, as a private swarm when secret is not empty
+func (m *RegistryMemory) Register(infoHash gorrent.Sha1Hash, secret []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.infoHashes[infoHash] = secret
+}
+
+// Unregister disallows infoHash on the tracker
+func (m *RegistryMemory) Unregister(infoHash gorrent.Sha1Hash) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.infoHashes, infoHash)
+}
+
+// Registered returns true when infoHash is allowed on the tracker
+func (m *RegistryMemory) Registered(infoHash gorrent.Sha1Hash) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, ok := m.infoHashes[infoHash]
+
+	return ok
+}
+
+// Secret returns the swarm secret of infoHash, and false when it is not registered as private
+func (m *RegistryMemory) Secret(infoHash gorrent.Sha1Hash) ([]byte, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	secret := m.infoHashes[infoHash]
+
+	return secret, len(secret) > 0
+}
+
+// All returns the registered info hashes
+func (m *RegistryMemory) All() []gorrent.Sha1Hash {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var infoHashes []gorrent.Sha1Hash
+	for infoHash := range m.infoHashes {
+		infoHashes = append(infoHashes, infoHash)
+	}
+
+	return infoHashes
+}
+
+// registryOverlay adds the read only base registry to the writable one
+type registryOverlay struct {
+	base     Registry
+	registry Registry
+}
+
+// NewRegistryOverlay returns a Registry holding the info hashes of both base and registry, only registry being written to.
+// The swarm secrets of base, like the ones of the tracker configuration, take precedence and can't be unregistered.
+func NewRegistryOverlay(base Registry, registry Registry) Registry {
+	return &registryOverlay{
+		base:     base,
+		registry: registry,
+	}
+}
+
+// Register allows infoHash on the tracker, in the writable registry
+func (o *registryOverlay) Register(infoHash gorrent.Sha1Hash, secret []byte) {
+	o.registry.Register(infoHash, secret)
+}
+
+// Unregister disallows infoHash on the tracker, unless it is in the base registry
+func (o *registryOverlay) Unregister(infoHash gorrent.Sha1Hash) {
+	o.registry.Unregister(infoHash)
+}
+
+// Registered returns true when infoHash is in either registry
+func (o *registryOverlay) Registered(infoHash gorrent.Sha1Hash) bool {
+	return o.base.Registered(infoHash) || o.registry.Registered(infoHash)
+}
+
+// Secret returns the swarm secret of infoHash from the base registry, or else from the writable one
+func (o *registryOverlay) Secret(infoHash gorrent.Sha1Hash) ([]byte, bool) {
+	if secret, ok := o.base.Secret(infoHash); ok {
+		return secret, true
+	}
+
+	return o.registry.Secret(infoHash)
+}
+
+// All returns the info hashes of both registries
+func (o *registryOverlay) All() []gorrent.Sha1Hash {
+	infoHashes := o.base.All()
+	for _, infoHash := range o.registry.All() {
+		if !o.base.Registered(infoHash) {
+			infoHashes = append(infoHashes, infoHash)
+		}
+	}
+
+	return infoHashes
+}
+
+// DummyRegistry provides a configurable Registry
+type DummyRegistry struct {
+	RegisterFunc   func(infoHash gorrent.Sha1Hash, secret []byte)
+	UnregisterFunc func(infoHash gorrent.Sha1Hash)
+	RegisteredFunc func(infoHash gorrent.Sha1Hash) bool
+	SecretFunc     func(infoHash gorrent.Sha1Hash) ([]byte, bool)
+	AllFunc        func() []gorrent.Sha1Hash
+}
+
+// Register calls RegisterFunc
+func (d *DummyRegistry) Register(infoHash gorrent.Sha1Hash, secret []byte) {
+	d.RegisterFunc(infoHash, secret)
+}
+
+// Unregister calls UnregisterFunc
+func (d *DummyRegistry) Unregister(infoHash gorrent.Sha1Hash) {
+	d.UnregisterFunc(infoHash)
+}
+
+// Registered calls RegisteredFunc
+func (d *DummyRegistry) Registered(infoHash gorrent.Sha1Hash) bool {
+	return d.RegisteredFunc(infoHash)
+}
+
+// Secret calls SecretFunc
+func (d *DummyRegistry) Secret(infoHash gorrent.Sha1Hash) ([]byte, bool) {
+	return d.SecretFunc(infoHash)
+}
+
+// All calls AllFunc
+func (d *DummyRegistry) All() []gorrent.Sha1Hash {
+	return d.AllFunc()
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"log"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var registryBucket = []byte("registry")
+
+// RegistryBolt defines a registry persisted in the bolt database of an AnnounceBolt, surviving tracker restarts
+// Each registered info hash is a key of the registry bucket. Its value is a private flag byte, followed by the swarm secret when set.
+type RegistryBolt struct {
+	db *bolt.DB
+}
+
+var _ Registry = &RegistryBolt{}
+
+// NewRegistryBolt creates the registry in the database of announces, which must be open as long as the registry is used
+func NewRegistryBolt(announces *AnnounceBolt) (*RegistryBolt, error) {
+	err := announces.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(registryBucket)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegistryBolt{
+		db: announces.db,
+	}, nil
+}
+
+// Register allows infoHash on the tracker, as a private swarm when secret is not empty
+func (b *RegistryBolt) Register(infoHash gorrent.Sha1Hash, secret []byte) {
+	value := []byte{0}
+	if len(secret) > 0 {
+		value = append([]byte{1}, secret...)
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(registryBucket).Put(infoHash.Bytes(), value)
+	})
+	if err != nil {
+		log.Printf("failed to register %s: %s", infoHash.HexString(), err)
+	}
+}
+
+// Unregister disallows infoHash on the tracker
+func (b *RegistryBolt) Unregister(infoHash gorrent.Sha1Hash) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(registryBucket).Delete(infoHash.Bytes())
+	})
+	if err != nil {
+		log.Printf("failed to unregister %s: %s", infoHash.HexString(), err)
+	}
+}
+
+// Registered returns true when infoHash is allowed on the tracker
+func (b *RegistryBolt) Registered(infoHash gorrent.Sha1Hash) bool {
+	registered := false
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		registered = tx.Bucket(registryBucket).Get(infoHash.Bytes()) != nil
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to read registry: %s", err)
+	}
+
+	return registered
+}
+
+// Secret returns the swarm secret of infoHash, and false when it is not registered as private
+func (b *RegistryBolt) Secret(infoHash gorrent.Sha1Hash) ([]byte, bool) {
+	var secret []byte
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid during the transaction
+		if v := tx.Bucket(registryBucket).Get(infoHash.Bytes()); len(v) > 1 && v[0] == 1 {
+			secret = append([]byte(nil), v[1:]...)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to read registry: %s", err)
+	}
+
+	return secret, len(secret) > 0
+}
+
+// All returns the registered info hashes
+func (b *RegistryBolt) All() []gorrent.Sha1Hash {
+	var infoHashes []gorrent.Sha1Hash
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(registryBucket).ForEach(func(k, _ []byte) error {
+			var infoHash gorrent.Sha1Hash
+			copy(infoHash[:], k)
+			infoHashes = append(infoHashes, infoHash)
+
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("failed to list registry: %s", err)
+	}
+
+	return infoHashes
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+func TestRegistryMemory(t *testing.T) {
+	t.Run("Register and Unregister update the registered info hashes", func(t *testing.T) {
+		r := NewRegistryMemory()
+		infoHash := gorrent.RandomSha1Hash()
+
+		if r.Registered(infoHash) {
+			t.Fatalf("Expected info hash not to be registered")
+		}
+
+		r.Register(infoHash, nil)
+		if !r.Registered(infoHash) {
+			t.Fatalf("Expected info hash to be registered")
+		}
+
+		if _, ok := r.Secret(infoHash); ok {
+			t.Fatalf("Expected info hash to be public")
+		}
+
+		expected := []gorrent.Sha1Hash{infoHash}
+		if all := r.All(); reflect.DeepEqual(all, expected) == false {
+			t.Fatalf("Expected info hashes to be %v, got %v", expected, all)
+		}
+
+		r.Unregister(infoHash)
+		if r.Registered(infoHash) {
+			t.Fatalf("Expected info hash to be unregistered")
+		}
+	})
+}
+
+func TestRegistryBolt(t *testing.T) {
+	t.Run("Registered gorrents and their secrets survive a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tracker.db")
+		public := gorrent.RandomSha1Hash()
+		private := gorrent.RandomSha1Hash()
+		unregistered := gorrent.RandomSha1Hash()
+
+		announces := newTestAnnounceBolt(t, path)
+		r, err := NewRegistryBolt(announces)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Register(public, nil)
+		r.Register(private, []byte("secret"))
+		r.Register(unregistered, nil)
+		r.Unregister(unregistered)
+
+		if err := announces.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		announces = newTestAnnounceBolt(t, path)
+		defer announces.Close()
+
+		r, err = NewRegistryBolt(announces)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !r.Registered(public) || !r.Registered(private) || r.Registered(unregistered) {
+			t.Fatalf("Expected only the public and private info hashes to be registered, got %v", r.All())
+		}
+
+		if _, ok := r.Secret(public); ok {
+			t.Fatalf("Expected the public info hash to have no secret")
+		}
+
+		if secret, ok := r.Secret(private); !ok || string(secret) != "secret" {
+			t.Fatalf("Expected the private info hash secret to be kept, got %s", secret)
+		}
+
+		if all := r.All(); len(all) != 2 {
+			t.Fatalf("Expected 2 registered info hashes, got %v", all)
+		}
+	})
+}
+
+func TestRegistryOverlay(t *testing.T) {
+	t.Run("Base gorrents and secrets take precedence and can't be unregistered", func(t *testing.T) {
+		base := NewRegistryMemory()
+		registry := NewRegistryMemory()
+		o := NewRegistryOverlay(base, registry)
+
+		configured := gorrent.RandomSha1Hash()
+		base.Register(configured, []byte("configured"))
+
+		o.Register(configured, []byte("uploaded"))
+		if secret, ok := o.Secret(configured); !ok || string(secret) != "configured" {
+			t.Fatalf("Expected the base secret to be returned, got %s", secret)
+		}
+
+		o.Unregister(configured)
+		if !o.Registered(configured) {
+			t.Fatalf("Expected the base info hash to stay registered")
+		}
+
+		registered := gorrent.RandomSha1Hash()
+		o.Register(registered, nil)
+		o.Register(configured, nil)
+
+		if all := o.All(); len(all) != 2 {
+			t.Fatalf("Expected 2 registered info hashes, got %v", all)
+		}
+
+		if !registry.Registered(registered) || base.Registered(registered) {
+			t.Fatalf("Expected info hashes to be registered in the writable registry only")
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+)
+
+// SwarmSecrets gives access to the secrets of private swarms
+type SwarmSecrets interface {
+	// Secret returns the swarm secret of infoHash, and false when the swarm is public
+	Secret(infoHash gorrent.Sha1Hash) ([]byte, bool)
+}
+
+// SecretsMemory defines a swarm secrets storage using memory only
+type SecretsMemory struct {
+	mutex   sync.RWMutex
+	secrets map[gorrent.Sha1Hash][]byte
+}
+
+var _ SwarmSecrets = &SecretsMemory{}
+var _ SwarmSecrets = &DummySwarmSecrets{}
+
+// NewSecretsMemory creates a new in memory swarm secrets store
+func NewSecretsMemory() *SecretsMemory {
+	return &SecretsMemory{
+		secrets: make(map[gorrent.Sha1Hash][]byte),
+	}
+}
+
+// Add sets the swarm secret of infoHash
+func (m *SecretsMemory) Add(infoHash gorrent.Sha1Hash, secret []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.secrets[infoHash] = secret
+}
+
+// Remove deletes the swarm secret of infoHash, making its swarm public
+func (m *SecretsMemory) Remove(infoHash gorrent.Sha1Hash) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.secrets, infoHash)
+}
+
+// Secret returns the swarm secret of infoHash
+func (m *SecretsMemory) Secret(infoHash gorrent.Sha1Hash) ([]byte, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	secret, ok := m.secrets[infoHash]
+
+	return secret, ok
+}
+
+// DummySwarmSecrets provides configurable SwarmSecrets
+type DummySwarmSecrets struct {
+	SecretFunc func(infoHash gorrent.Sha1Hash) ([]byte, bool)
+}
+
+// Secret calls SecretFunc
+func (d *DummySwarmSecrets) Secret(infoHash gorrent.Sha1Hash) ([]byte, bool) {
+	return d.SecretFunc(infoHash)
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"log"
+	"time"
+)
+
+// Sweeper periodically purges the stale announces of a store
+type Sweeper interface {
+	SweepForever() error
+	Sweep() int
+}
+
+type sweeper struct {
+	store    Announce
+	maxAge   time.Duration
+	interval time.Duration
+}
+
+var _ Sweeper = &sweeper{}
+
+// NewSweeper creates a new Sweeper, purging the announces older than maxAge from store every interval
+func NewSweeper(store Announce, maxAge time.Duration, interval time.Duration) Sweeper {
+	return &sweeper{
+		store:    store,
+		maxAge:   maxAge,
+		interval: interval,
+	}
+}
+
+func (s *sweeper) SweepForever() error {
+	ticker := time.NewTicker(s.interval)
+	log.Printf("Starting announce sweeper")
+	for range ticker.C {
+		s.Sweep()
+	}
+
+	return nil
+}
+
+// Sweep purges the stale announces once, and returns how many were purged
+func (s *sweeper) Sweep() int {
+	purged := s.store.Purge(s.maxAge)
+	if purged > 0 {
+		log.Printf("Purged %d stale announces", purged)
+	}
+
+	return purged
+}
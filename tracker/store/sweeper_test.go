@@ -0,0 +1,27 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSweeper(t *testing.T) {
+	t.Run("Sweep purges the store with maxAge", func(t *testing.T) {
+		expectedMaxAge := 3 * time.Second
+
+		d := &DummyAnnounce{
+			PurgeFunc: func(maxAge time.Duration) int {
+				if maxAge != expectedMaxAge {
+					t.Fatalf("Expected maxAge to be %s, got %s", expectedMaxAge, maxAge)
+				}
+
+				return 2
+			},
+		}
+
+		s := NewSweeper(d, expectedMaxAge, time.Second)
+		if purged := s.Sweep(); purged != 2 {
+			t.Fatalf("Expected purged to be 2, got %d", purged)
+		}
+	})
+}
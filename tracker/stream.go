@@ -0,0 +1,190 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+const (
+	// StreamIdleTimeout defines how long the server keeps an idle stream connection open
+	StreamIdleTimeout = 30 * time.Second
+)
+
+var (
+	// ErrFrameTooLarge is returned when a length prefixed frame is larger than allowed
+	ErrFrameTooLarge = errors.New("frame too large")
+)
+
+// listenStream serves actions received over stream connections, each action and response being prefixed
+// by its length as a big endian uint32. Several actions can be sent on the same connection, which is closed
+// on the first dropped one.
+func (t *server) listenStream(ctx context.Context) error {
+	listener, err := net.Listen(t.cfg.Protocol, t.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	conns := make(map[net.Conn]struct{})
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+
+		// Idle connections stop waiting for their next action, the others once their action is responded
+		mutex.Lock()
+		for conn := range conns {
+			conn.SetReadDeadline(time.Now())
+		}
+		mutex.Unlock()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if ctx.Err() != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			wg.Wait()
+
+			return nil
+		}
+
+		if err != nil {
+			log.Print("Error while accepting connection: ", err)
+
+			continue
+		}
+
+		mutex.Lock()
+		conns[conn] = struct{}{}
+		mutex.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			t.serveStream(ctx, conn)
+
+			mutex.Lock()
+			delete(conns, conn)
+			mutex.Unlock()
+		}()
+	}
+}
+
+func (t *server) serveStream(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	client := conn.RemoteAddr()
+	for {
+		conn.SetDeadline(time.Now().Add(StreamIdleTimeout))
+		if ctx.Err() != nil {
+			return
+		}
+
+		buf, err := readFrame(conn, actions.MaxPayloadSize)
+		if err != nil && err != ErrFrameTooLarge {
+			if err != io.EOF && ctx.Err() == nil {
+				t.logger.Printf("[%s] failed to read frame: %s", client, err)
+			}
+
+			return
+		}
+
+		if !t.allow(client) {
+			if resp := rateLimitedFrame(buf); resp != nil {
+				writeFrame(conn, resp)
+			}
+
+			return
+		}
+
+		if err == ErrFrameTooLarge {
+			t.counters.inc(&t.counters.Oversized)
+			t.logger.Printf("[%s] sent oversized frame", client)
+
+			return
+		}
+
+		if !t.serveFrame(conn, client, buf) {
+			return
+		}
+	}
+}
+
+// serveFrame handles the action read from a frame on a worker, and writes its response, returning false on failure
+func (t *server) serveFrame(conn net.Conn, client net.Addr, frame []byte) bool {
+	t.acquire()
+	defer t.release()
+
+	resp := t.serve(actions.Source{Addr: client, MaxResponseSize: actions.MaxStreamResponseSize}, frame, true)
+	if resp == nil {
+		return false
+	}
+
+	if err := writeFrame(conn, resp); err != nil {
+		t.logger.Printf("[%s] writing response error: %s", client, err)
+
+		return false
+	}
+
+	return true
+}
+
+// streamRoundTrip sends the request to the tracker at addr over a new stream connection, and returns its response
+func streamRoundTrip(protocol string, addr string, request []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(protocol, addr, RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(RequestTimeout))
+
+	if err := writeFrame(conn, request); err != nil {
+		return nil, err
+	}
+
+	return readFrame(conn, actions.MaxStreamResponseSize)
+}
+
+// writeFrame writes the payload prefixed by its length
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// readFrame reads a length prefixed payload, failing with ErrFrameTooLarge when longer than maxSize
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	if length > uint32(maxSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,126 @@
+package tracker
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daeMOn63/gorrent/gorrent"
+	"github.com/daeMOn63/gorrent/tracker/actions"
+)
+
+func TestFrames(t *testing.T) {
+	t.Run("readFrame returns the payload written by writeFrame", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+		payload := []byte{1, 2, 3}
+		if err := writeFrame(buf, payload); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.Len() != 4+len(payload) {
+			t.Fatalf("Expected frame length to be %d, got %d", 4+len(payload), buf.Len())
+		}
+
+		got, err := readFrame(buf, len(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reflect.DeepEqual(got, payload) == false {
+			t.Fatalf("Expected payload to be %v, got %v", payload, got)
+		}
+	})
+
+	t.Run("readFrame rejects frames larger than maxSize", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+		writeFrame(buf, []byte{1, 2, 3})
+
+		if _, err := readFrame(buf, 2); err != ErrFrameTooLarge {
+			t.Fatalf("Expected err to be %s, got %v", ErrFrameTooLarge, err)
+		}
+	})
+}
+
+func TestStreamServer(t *testing.T) {
+	addr, connects := newTestTracker(t, ProtocolTCP)
+
+	t.Run("Server handles several actions on the same connection", func(t *testing.T) {
+		conn, err := net.Dial(ProtocolTCP, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		for i := 0; i < 2; i++ {
+			if err := writeFrame(conn, actions.NewHeader(uint32(i)).Frame(actions.ConnectID, nil)); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := readFrame(conn, actions.MaxStreamResponseSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header, body, err := actions.ParseHeader(resp)
+			if err != nil || header.TransactionID != uint32(i) {
+				t.Fatalf("Expected a response to transaction %d, got %v", i, resp)
+			}
+
+			response := &actions.ConnectResponse{}
+			if err := response.UnmarshalBinary(body[1:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if connects := atomic.LoadInt32(connects); connects != 2 {
+			t.Fatalf("Expected 2 connects, got %d", connects)
+		}
+	})
+	t.Run("Server responds unsupported versions with an error", func(t *testing.T) {
+		conn, err := net.Dial(ProtocolTCP, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if err := writeFrame(conn, []byte{byte(actions.ConnectID), 0, 0, 0, 7, 0}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := readFrame(conn, actions.MaxStreamResponseSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, body, err := actions.ParseHeader(resp)
+		if err != nil || actions.ID(body[0]) != actions.ErrorID {
+			t.Fatalf("Expected an error response, got %v", resp)
+		}
+
+		response := &actions.Error{}
+		if err := response.UnmarshalBinary(body[1:]); err != nil || *response != *actions.ErrUnsupportedVersion {
+			t.Fatalf("Expected error to be %#v, got %#v", actions.ErrUnsupportedVersion, response)
+		}
+	})
+
+	t.Run("Server responds rate limited sources with an error", func(t *testing.T) {
+		cfg := ServerConfig{
+			Protocol: ProtocolTCP,
+			Limiter: &DummyRateLimiter{
+				AllowFunc: func(ip net.IP, now time.Time) bool {
+					return false
+				},
+			},
+		}
+		_, cfg = startTestServer(t, cfg, actions.NewReader(), actions.NewRouter())
+
+		c := NewClient(gorrent.Peer{}, ProtocolTCP).(*client)
+		if _, err := c.roundTrip(cfg.Addr, actions.ConnectID, nil); !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("Expected err to be %s, got %v", ErrRateLimited, err)
+		}
+	})
+}